@@ -15,12 +15,90 @@ import (
 func Main() {
 	// Get environment variables
 	port := getEnv("PORT")
-	mongodbURI := getEnv("MONGODB_URI")
 
 	// Set up services and handlers
-	db := common.NewMongoClient(mongodbURI)
-	predictionSvc := common.PredictionSvc{DB: db}
+	predictionSvc := common.PredictionSvc{
+		// required unless MODEL_PATH is set - predictions are read from the
+		// prediction set of the chain, and accepted suggestions are only
+		// learned, if it is set
+		ChainID: strings.TrimSpace(os.Getenv("CHAIN_ID")),
+	}
+	if modelPath := strings.TrimSpace(os.Getenv("MODEL_PATH")); modelPath != "" {
+		// serve predictions from a compiled model, which also records
+		// how its chain split text
+		model, err := common.OpenModel(modelPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer model.Close()
+		predictionSvc.Model = model
+		predictionSvc.Tokenizer = model.GetTokenizer()
+		predictionSvc.Segmenter = model.GetSegmenter()
+	}
+
+	if predictionSvc.Model == nil {
+		// prediction sets are stored under the id of their chain
+		predictionSvc.ChainID = getEnv("CHAIN_ID")
+	}
+
+	// the db is optional when serving a model, but feedback and the
+	// admin API need it
+	if predictionSvc.Model == nil || os.Getenv("MONGODB_URI") != "" {
+		db := common.NewMongoClient(getEnv("MONGODB_URI"))
+		predictionSvc.DB = db
+		if predictionSvc.ChainID != "" && predictionSvc.Model == nil {
+			// split input the same way as the chain's training text
+			chainInfo, err := db.GetChainInfoByID(predictionSvc.ChainID)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if predictionSvc.Tokenizer, err = common.TokenizerFromDao(chainInfo); err != nil {
+				log.Fatal(err)
+			}
+			if predictionSvc.Segmenter, err = common.SegmenterFromDao(chainInfo); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		// optional - blanks are only filled in from a chain built with
+		// a reverse chain, which is kept in memory
+		if infillID := strings.TrimSpace(os.Getenv("INFILL_CHAIN_ID")); infillID != "" {
+			dao, err := db.GetChainByID(infillID)
+			if err != nil {
+				log.Fatal(err)
+			}
+			chain, err := common.ChainFromDao(dao)
+			if err != nil {
+				log.Fatal(err)
+			}
+			infiller, err := common.NewInfiller(chain)
+			if err != nil {
+				log.Fatal(err)
+			}
+			predictionSvc.Infiller = &infiller
+			predictionSvc.InfillChainID = infillID
+		}
+	}
+	if predictionSvc.DB == nil {
+		log.Print("Serving the model without a db: only the safety policy it was " +
+			"compiled with is applied, and feedback and the admin API are disabled")
+	}
 	predictionHandler := PredictionHandler{svc: predictionSvc}
+	feedbackHandler := FeedbackHandler{
+		svc:            predictionSvc,
+		trustedProxies: make(map[string]bool),
+	}
+	// optional - X-Forwarded-For is ignored unless sent by these proxies
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			feedbackHandler.trustedProxies[proxy] = true
+		}
+	}
+	adminToken := strings.TrimSpace(os.Getenv("ADMIN_TOKEN")) // optional - admin API is disabled if unset
+	safetyHandler := SafetyHandler{svc: predictionSvc, token: adminToken}
+	statsHandler := StatsHandler{svc: predictionSvc, token: adminToken}
+	distinctiveHandler := DistinctiveHandler{svc: predictionSvc, token: adminToken}
+	infillHandler := InfillHandler{svc: predictionSvc}
 	demoHandler := DemoHandler{}
 
 	r := mux.NewRouter()
@@ -29,6 +107,31 @@ func Main() {
 	r.HandleFunc("/api/prediction", predictionHandler.Handle).
 		Methods(http.MethodGet)
 
+	if predictionSvc.Infiller != nil {
+		r.HandleFunc("/api/infill", infillHandler.Handle).
+			Methods(http.MethodGet)
+	}
+
+	if predictionSvc.DB != nil {
+		r.HandleFunc("/api/feedback", feedbackHandler.Handle).
+			Methods(http.MethodPost)
+	}
+
+	// admin API handling
+	if adminToken != "" && predictionSvc.DB != nil {
+		r.HandleFunc("/api/admin/safety/{chainID}", safetyHandler.Get).
+			Methods(http.MethodGet)
+
+		r.HandleFunc("/api/admin/safety/{chainID}", safetyHandler.Put).
+			Methods(http.MethodPut)
+
+		r.HandleFunc("/api/admin/chains/{id}/stats", statsHandler.Handle).
+			Methods(http.MethodGet)
+
+		r.HandleFunc("/api/admin/chains/{id}/distinctive", distinctiveHandler.Handle).
+			Methods(http.MethodGet)
+	}
+
 	// ui handling
 	wd, _ := os.Getwd()
 	staticDir := filepath.Join(wd, "./cmd/app/static/")
@@ -1,14 +1,24 @@
 package app
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/gorilla/mux"
+	"github.com/zacwhalley/predictivetext/common"
 	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
 )
 
 // PredictionHandler handles requests for predictions
@@ -16,6 +26,36 @@ type PredictionHandler struct {
 	svc domain.PredictionSvc
 }
 
+// FeedbackHandler handles reports of accepted suggestions
+type FeedbackHandler struct {
+	svc            domain.PredictionSvc
+	trustedProxies map[string]bool
+}
+
+// SafetyHandler handles admin requests to manage safety policies
+type SafetyHandler struct {
+	svc   domain.PredictionSvc
+	token string
+}
+
+// StatsHandler handles admin requests for chain statistics
+type StatsHandler struct {
+	svc   domain.PredictionSvc
+	token string
+}
+
+// DistinctiveHandler handles admin requests for the distinctive phrases
+// of a chain
+type DistinctiveHandler struct {
+	svc   domain.PredictionSvc
+	token string
+}
+
+// InfillHandler handles requests to fill in a blank in the input
+type InfillHandler struct {
+	svc domain.PredictionSvc
+}
+
 // DemoHandler handles requests for the demo page
 type DemoHandler struct{}
 
@@ -36,9 +76,18 @@ func (handler PredictionHandler) Handle(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	suggestions := make([]domain.Suggestion, len(predictions))
+	for i, prediction := range predictions {
+		suggestions[i] = domain.Suggestion{
+			Text:   prediction,
+			Attach: util.AttachesLeft(prediction),
+		}
+	}
+
 	response := domain.PredictionResponse{
 		Input:       input,
 		Predictions: predictions,
+		Suggestions: suggestions,
 	}
 
 	// Send response
@@ -48,6 +97,241 @@ func (handler PredictionHandler) Handle(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// Handle records a suggestion accepted by the client
+func (handler FeedbackHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	var feedback domain.FeedbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&feedback); err != nil {
+		http.Error(w, "Invalid feedback", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(feedback.Suggestion) == "" {
+		http.Error(w, "suggestion parameter missing", http.StatusBadRequest)
+		return
+	}
+
+	err := handler.svc.RecordSelection(feedback.Input, feedback.Suggestion, clientID(r, handler.trustedProxies))
+	if err == domain.ErrFeedbackLimit {
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	} else if err != nil {
+		log.Print(err)
+		http.Error(w, "Could not record feedback", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientID identifies the client that sent a request by its address.
+// X-Forwarded-For is only read from trusted proxies, and the client is the
+// last address in it not added by one.
+func clientID(r *http.Request, trustedProxies map[string]bool) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxies[host] {
+		return host
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if addr == "" {
+			continue
+		}
+		host = addr
+		if !trustedProxies[addr] {
+			break
+		}
+	}
+	return host
+}
+
+// Get returns the safety policy of the chain in the path
+func (handler SafetyHandler) Get(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, handler.token) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	policy, err := handler.svc.GetSafetyPolicy(mux.Vars(r)["chainID"])
+	if err != nil {
+		log.Print(err)
+		http.Error(w, "Could not get safety policy", http.StatusInternalServerError)
+		return
+	}
+
+	if err = respondWithJSON(w, http.StatusOK, policy); err != nil {
+		log.Print(err)
+		http.Error(w, "Error returning safety policy", http.StatusInternalServerError)
+	}
+}
+
+// Put replaces the safety policy of the chain in the path
+func (handler SafetyHandler) Put(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, handler.token) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var policy domain.SafetyPolicyDao
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid safety policy", http.StatusBadRequest)
+		return
+	}
+	policy.ChainID = mux.Vars(r)["chainID"]
+
+	err := handler.svc.SetSafetyPolicy(policy)
+	if errors.Is(err, domain.ErrInvalidSafetyPolicy) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Print(err)
+		http.Error(w, "Could not save safety policy", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorized returns true if the request has the admin bearer token
+func authorized(r *http.Request, adminToken string) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return adminToken != "" &&
+		subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
+
+// Handle returns the statistics of the chain in the path
+func (handler StatsHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	// statistics are computed from the whole chain, so are not public
+	if !authorized(r, handler.token) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	top, err := countParam(r, "top", defaultStatsTop, common.MaxTop)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	stats, err := handler.svc.GetChainStats(mux.Vars(r)["id"], top)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, "Could not get chain stats", http.StatusNotFound)
+		return
+	}
+
+	if err = respondWithJSON(w, http.StatusOK, stats); err != nil {
+		log.Print(err)
+		http.Error(w, "Error returning chain stats", http.StatusInternalServerError)
+	}
+}
+
+// defaultStatsTop is the number of top prefixes and suffixes returned
+// if a request does not say
+const defaultStatsTop = 10
+
+// Handle returns the words and phrases of the chain in the path that are
+// most over-represented compared with the background chain
+func (handler DistinctiveHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	if !authorized(r, handler.token) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := r.URL.Query()
+	background := query.Get("background")
+	if background == "" {
+		http.Error(w, "background parameter missing", http.StatusBadRequest)
+		return
+	}
+	method := query.Get("method")
+	if method == "" {
+		method = common.DistinctByLLR
+	}
+	if method != common.DistinctByLLR && method != common.DistinctByTFIDF {
+		http.Error(w, "method must be llr or tfidf", http.StatusBadRequest)
+		return
+	}
+
+	top, err := countParam(r, "top", defaultStatsTop, common.MaxTop)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minCount, err := countParam(r, "minCount", defaultDistinctiveMinCount, math.MaxInt32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	phrases, err := handler.svc.GetDistinctivePhrases(mux.Vars(r)["id"], background,
+		method, top, minCount)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, "Could not get distinctive phrases", http.StatusNotFound)
+		return
+	}
+
+	if err = respondWithJSON(w, http.StatusOK, phrases); err != nil {
+		log.Print(err)
+		http.Error(w, "Error returning distinctive phrases", http.StatusInternalServerError)
+	}
+}
+
+// Handle returns the words most likely to fill in the blank in the input
+func (handler InfillHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	input := r.URL.Query().Get("input")
+	if input == "" {
+		http.Error(w, "input parameter missing", http.StatusBadRequest)
+		return
+	}
+	limit, err := countParam(r, "limit", defaultInfillLimit, common.MaxTop)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	candidates, err := handler.svc.Infill(input, limit)
+	if err == domain.ErrNoPrediction {
+		http.Error(w, "Could not fill in blank", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response := domain.InfillResponse{Input: input, Candidates: candidates}
+	if err = respondWithJSON(w, http.StatusOK, response); err != nil {
+		log.Print(err)
+		http.Error(w, "Error returning infill", http.StatusInternalServerError)
+	}
+}
+
+// defaultInfillLimit is the number of words returned for a blank if a
+// request does not say
+const defaultInfillLimit = 5
+
+// defaultDistinctiveMinCount is the fewest times a phrase must be in the
+// chain to be returned if a request does not say
+const defaultDistinctiveMinCount = 3
+
+// countParam returns the integer query parameter name, from 0 to max,
+// or fallback if the request does not have it
+func countParam(r *http.Request, name string, fallback, max int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 || count > max {
+		return 0, fmt.Errorf("%s must be an integer from 0 to %v", name, max)
+	}
+	return count, nil
+}
+
 // Handle handles requests for the demo page
 func (handler DemoHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	wd, _ := os.Getwd()
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientID(t *testing.T) {
+	trusted := map[string]bool{"10.0.0.1": true, "10.0.0.2": true}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"direct", "203.0.113.5:1234", "", "203.0.113.5"},
+		{"spoofed header from client", "203.0.113.5:1234", "198.51.100.1", "203.0.113.5"},
+		{"trusted proxy", "10.0.0.1:80", "198.51.100.1", "198.51.100.1"},
+		{"spoofed header behind proxy", "10.0.0.1:80", "192.0.2.9, 198.51.100.1", "198.51.100.1"},
+		{"chain of proxies", "10.0.0.1:80", "198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		{"trusted proxy without header", "10.0.0.1:80", "", "10.0.0.1"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "/api/feedback", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if got := clientID(r, trusted); got != test.want {
+			t.Errorf("%v: clientID() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCountParam(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    int
+		wantErr bool
+	}{
+		{"", 10, false},
+		{"top=0", 0, false},
+		{"top=25", 25, false},
+		{"top=100", 100, false},
+		{"top=101", 0, true},
+		{"top=1099511627776", 0, true},
+		{"top=-1", 0, true},
+		{"top=many", 0, true},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/admin/chains/id/stats?"+test.query, nil)
+		got, err := countParam(r, "top", 10, 100)
+		if (err != nil) != test.wantErr || got != test.want {
+			t.Errorf("countParam(%q) = %v, %v, want %v", test.query, got, err, test.want)
+		}
+	}
+}
+
+func TestAdminHandlersUnauthorized(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"no header", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer guess", http.StatusUnauthorized},
+		{"token without bearer", "secret", "guess", http.StatusUnauthorized},
+		{"admin API disabled", "", "Bearer ", http.StatusUnauthorized},
+		{"admin token", "secret", "Bearer secret", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		// the requests have no valid parameters, so authorized requests
+		// are rejected before the chain is read
+		handlers := map[string]http.HandlerFunc{
+			"stats":       StatsHandler{token: test.token}.Handle,
+			"distinctive": DistinctiveHandler{token: test.token}.Handle,
+		}
+		for path, handle := range handlers {
+			r := httptest.NewRequest("GET", "/api/admin/chains/id/"+path+"?top=-1", nil)
+			if test.header != "" {
+				r.Header.Set("Authorization", test.header)
+			}
+			w := httptest.NewRecorder()
+			handle(w, r)
+			if w.Code != test.want {
+				t.Errorf("%v: %v returned %v, want %v", test.name, path, w.Code, test.want)
+			}
+		}
+	}
+}
@@ -44,7 +44,7 @@
       if (response.status === 200) {
         response.json().then(body => {
           // display predictions in result
-          if (!body || !body.predictions || body.predictions.length < 1) {
+          if (!body || !body.suggestions || body.suggestions.length < 1) {
             return;
           }
     
@@ -54,9 +54,9 @@
           }
     
           // add all new results to the list
-          for (const prediction of body.predictions)  {
-            if (prediction && prediction.trim()) {
-              const newItem = createResult(body.input, prediction);
+          for (const suggestion of body.suggestions)  {
+            if (suggestion.text && suggestion.text.trim()) {
+              const newItem = createResult(body.input, suggestion);
               resultsList.appendChild(newItem);
             }
           }  
@@ -67,14 +67,23 @@
     }).catch(displayError)
   }
 
-  function createResult(input, prediction) {
+  function createResult(input, suggestion) {
+    const separator = suggestion.attach ? "" : " ";
     let newLi = document.createElement("li");
     newLi.innerHTML = `
-    <p>${input} <span class="predicted">${prediction}</span>
+    <p>${input}${separator}<span class="predicted">${suggestion.text}</span>
     `;
+    newLi.addEventListener("click", () => acceptPrediction(input, suggestion));
     return newLi;
   }
 
+  function acceptPrediction(input, suggestion) {
+    const separator = suggestion.attach ? "" : " ";
+    inputBox.value = `${input}${separator}${suggestion.text}`;
+    resetResults();
+    makeFeedbackRequest(input, suggestion.text).catch(displayError);
+  }
+
   function displayError(err) {
     results.style.display = "none";
     errorMessage.style.display = "";
@@ -88,6 +97,17 @@
     return fetch(request);
   }
 
+  function makeFeedbackRequest(input, suggestion) {
+    const requestUrl = `${apiUrl}/feedback`
+    const request = new Request(requestUrl, {
+      method: "POST",
+      headers: {"Content-Type": "application/json"},
+      body: JSON.stringify({input, suggestion})
+    });
+
+    return fetch(request);
+  }
+
   function resetResults() {
     results.style.display = "none";
     errorMessage.style.display = "none";
@@ -5,7 +5,10 @@ type CommentsPageDto struct {
 	Data struct {
 		Children []struct {
 			Data struct {
-				Body string `json:"body"`
+				Name       string  `json:"name"`
+				Author     string  `json:"author"`
+				Body       string  `json:"body"`
+				CreatedUTC float64 `json:"created_utc"`
 			} `json:"data"`
 		} `json:"children"`
 		After string `json:"after"`
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/urfave/cli"
+	"github.com/zacwhalley/predictivetext/common"
+)
+
+// benchmarkResult is the average cost of building one chain
+type benchmarkResult struct {
+	tokens  int
+	elapsed time.Duration
+	bytes   uint64
+	allocs  uint64
+}
+
+func benchmarkAction(c *cli.Context) error {
+	repeat := c.Int("repeat")
+	if repeat <= 0 {
+		return errors.New("repeat must be greater than 0")
+	}
+	workers := c.Int("workers")
+	names := c.StringSlice("tokenizer")
+	if len(names) == 0 {
+		names = []string{
+			common.WhitespaceTokenizerName,
+			common.RegexTokenizerName,
+			common.WordBoundaryTokenizerName,
+		}
+	}
+
+	corpus, err := readCorpus(c.Args())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Corpus: %v bytes, %v builds per tokenizer, %v workers\n\n",
+		len(corpus), repeat, workers)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "tokenizer\ttokens\ttime/build\ttokens/s\tMB/build\tallocs/token\t")
+	for _, name := range names {
+		tokenizer, err := common.MakeTokenizer(name, nil)
+		if err != nil {
+			return err
+		}
+		result := benchmarkBuild(common.NewChain(2).WithTokenizer(tokenizer), corpus, repeat, workers)
+		fmt.Fprintf(w, "%s\t%d\t%v\t%.0f\t%.1f\t%.1f\t\n",
+			name,
+			result.tokens,
+			result.elapsed.Round(time.Millisecond),
+			float64(result.tokens)/result.elapsed.Seconds(),
+			float64(result.bytes)/(1<<20),
+			float64(result.allocs)/float64(result.tokens))
+	}
+	return w.Flush()
+}
+
+// readCorpus reads the text of all files, or of stdin if there are none
+func readCorpus(paths []string) ([]byte, error) {
+	if len(paths) == 0 {
+		return ioutil.ReadAll(os.Stdin)
+	}
+
+	var corpus bytes.Buffer
+	for _, path := range paths {
+		text, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		corpus.Write(text)
+		corpus.WriteByte('\n')
+	}
+	return corpus.Bytes(), nil
+}
+
+// benchmarkBuild builds empty copies of chain from corpus repeat
+// times with workers goroutines and returns the average cost of a build
+func benchmarkBuild(chain common.Chain, corpus []byte, repeat, workers int) benchmarkResult {
+	var result benchmarkResult
+	var before, after runtime.MemStats
+	for i := 0; i < repeat; i++ {
+		built := common.NewChain(chain.GetPrefixLen()).
+			WithTokenizer(chain.GetTokenizer()).
+			WithSegmenter(chain.GetSegmenter())
+
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		if workers > 1 {
+			built.BuildParallel(bytes.NewReader(corpus), time.Time{}, workers)
+		} else {
+			built.Build(bytes.NewReader(corpus))
+		}
+		result.elapsed += time.Since(start)
+		runtime.ReadMemStats(&after)
+
+		result.bytes += after.TotalAlloc - before.TotalAlloc
+		result.allocs += after.Mallocs - before.Mallocs
+		result.tokens = built.GetData().(common.SetMap).Total()
+	}
+
+	result.elapsed /= time.Duration(repeat)
+	result.bytes /= uint64(repeat)
+	result.allocs /= uint64(repeat)
+	return result
+}
+
+// syncResult is the throughput of a chain read and written concurrently
+type syncResult struct {
+	gets   int64
+	writes int64
+	// p99 is the 99th percentile latency of a sample of gets
+	p99     time.Duration
+	elapsed time.Duration
+}
+
+// benchmarkSyncAction measures a SyncChain read by request handlers
+// while text is added to it. Run it with -race to check for data races.
+func benchmarkSyncAction(c *cli.Context) error {
+	readers, writers := c.Int("readers"), c.Int("writers")
+	if readers <= 0 || writers <= 0 {
+		return errors.New("readers and writers must be greater than 0")
+	}
+	stripes := c.IntSlice("stripes")
+	if len(stripes) == 0 {
+		stripes = []int{1, common.DefaultSyncStripes}
+	}
+
+	corpus, err := readCorpus(c.Args())
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(corpus), "\n")
+	if len(lines) < 2 {
+		return errors.New("corpus must have at least two lines")
+	}
+
+	// readers look up the keys of the first half of the text while
+	// writers add the second half
+	base := common.NewChain(2)
+	base.Build(strings.NewReader(strings.Join(lines[:len(lines)/2], "\n")))
+	keys := make([]string, 0)
+	for key := range base.GetData().(common.SetMap) {
+		keys = append(keys, key)
+	}
+	fmt.Printf("Corpus: %v keys, %v readers, %v writers, %v per benchmark\n\n",
+		len(keys), readers, writers, c.Duration("duration"))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "stripes\tgets/s\tp99 get\tlines written/s\t")
+	for _, n := range stripes {
+		chain := common.NewSyncChain(base, n)
+		result := benchmarkSync(chain, keys, lines[len(lines)/2:],
+			readers, writers, c.Duration("duration"))
+		fmt.Fprintf(w, "%d\t%.0f\t%v\t%.0f\t\n",
+			n,
+			float64(result.gets)/result.elapsed.Seconds(),
+			result.p99,
+			float64(result.writes)/result.elapsed.Seconds())
+	}
+	return w.Flush()
+}
+
+// benchmarkSync gets random keys from chain with readers goroutines
+// while writers goroutines add lines to it, until duration has passed
+func benchmarkSync(chain *common.SyncChain, keys, lines []string,
+	readers, writers int, duration time.Duration) syncResult {
+
+	var result syncResult
+	var stop int32
+	var wg sync.WaitGroup
+	latencies := make([][]time.Duration, readers)
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(int64(i)))
+			gets := int64(0)
+			for ; atomic.LoadInt32(&stop) == 0; gets++ {
+				start := time.Now()
+				chain.Get(keys[rng.Intn(len(keys))])
+				// sample latencies to keep the benchmark's own
+				// allocations small
+				if gets%64 == 0 {
+					latencies[i] = append(latencies[i], time.Since(start))
+				}
+			}
+			atomic.AddInt64(&result.gets, gets)
+		}(i)
+	}
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			writes := int64(0)
+			for j := i; atomic.LoadInt32(&stop) == 0; j += writers {
+				chain.BuildAt(strings.NewReader(lines[j%len(lines)]), time.Now())
+				writes++
+			}
+			atomic.AddInt64(&result.writes, writes)
+		}(i)
+	}
+
+	start := time.Now()
+	time.Sleep(duration)
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	result.elapsed = time.Since(start)
+
+	sampled := make([]time.Duration, 0)
+	for _, l := range latencies {
+		sampled = append(sampled, l...)
+	}
+	if len(sampled) > 0 {
+		sort.Slice(sampled, func(i, j int) bool { return sampled[i] < sampled[j] })
+		result.p99 = sampled[len(sampled)*99/100]
+	}
+	return result
+}
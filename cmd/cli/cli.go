@@ -2,11 +2,17 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 
 	"github.com/urfave/cli"
 	"github.com/zacwhalley/predictivetext/common"
@@ -25,13 +31,34 @@ func setInfo(app *cli.App) {
 	app.Version = "1.0.0"
 }
 
+// pruneFlags are the options for pruning a chain before it is saved
+var pruneFlags = []cli.Flag{
+	cli.IntFlag{
+		Name:  "minCount",
+		Usage: "remove suffixes seen fewer than minCount times",
+	},
+	cli.IntFlag{
+		Name:  "topN",
+		Usage: "keep only the topN most common suffixes of each prefix",
+	},
+	cli.Float64Flag{
+		Name:  "minEntropy",
+		Usage: "remove suffixes contributing fewer bits of relative entropy than minEntropy",
+	},
+	cli.IntFlag{
+		Name:  "maxBytes",
+		Usage: "remove the least common suffixes until the chain fits in maxBytes",
+	},
+}
+
 func setCommands(app *cli.App) {
 	app.Commands = []cli.Command{
 		{
-			Name:    "build-chain",
-			Aliases: []string{"bc"},
-			Usage:   "Build the markov chain and store it in the db",
-			Flags: []cli.Flag{
+			Name:      "build-chain",
+			Aliases:   []string{"bc"},
+			Usage:     "Build the markov chain and store it in the db",
+			ArgsUsage: "[files (text source only, reads stdin if none)]",
+			Flags: append([]cli.Flag{
 				cli.IntFlag{
 					Name:  "pageLimit",
 					Value: 0,
@@ -40,7 +67,72 @@ func setCommands(app *cli.App) {
 					Name:  "source",
 					Value: reddit.String(),
 				},
-			},
+				cli.StringFlag{
+					Name:  "tokenizer",
+					Value: common.WhitespaceTokenizerName,
+					Usage: "how text is split into words: whitespace, regex or uax29",
+				},
+				cli.StringFlag{
+					Name:  "tokenPattern",
+					Value: common.DefaultTokenPattern,
+					Usage: "pattern matching each token for the regex tokenizer",
+				},
+				cli.StringFlag{
+					Name:  "language",
+					Value: common.DefaultLanguage,
+					Usage: "language code of the abbreviations used to find sentence ends",
+				},
+				cli.BoolFlag{
+					Name:  "learnAbbreviations",
+					Usage: "learn abbreviations from the text before building the chain",
+				},
+				cli.DurationFlag{
+					Name:  "halfLife",
+					Usage: "weight text by age, halving every halfLife (e.g. 8760h). 0 disables decay",
+				},
+				cli.Float64Flag{
+					Name:  "holdout",
+					Usage: "fraction of the text to hold out of the chain for evaluation",
+				},
+				cli.IntFlag{
+					Name:  "folds",
+					Usage: "divide the text into folds for cross-validation, building a chain holding out each",
+				},
+				cli.IntFlag{
+					Name:  "fold",
+					Usage: "only build the chain holding out this fold (from 1)",
+				},
+				cli.StringFlag{
+					Name:  "splitBy",
+					Value: common.SplitByDocument,
+					Usage: "hold out whole documents or users: document or user",
+				},
+				cli.Int64Flag{
+					Name:  "seed",
+					Usage: "seed choosing which text is held out",
+				},
+				cli.BoolFlag{
+					Name:  "reverse",
+					Usage: "also build a reverse chain, used to fill in blanks from the words after them",
+				},
+				cli.IntFlag{
+					Name:  "workers",
+					Value: runtime.NumCPU(),
+					Usage: "number of goroutines building the chain",
+				},
+				cli.IntFlag{
+					Name:  "memoryLimit",
+					Usage: "build text out of core, keeping at most this many bytes of counts in memory (0 = in memory)",
+				},
+				cli.BoolFlag{
+					Name:  "compact",
+					Usage: "build text in a compact chain, dropping the least common suffixes whenever their counts pass maxBytes",
+				},
+				cli.StringFlag{
+					Name:  "tempDir",
+					Usage: "directory for the temporary files of out-of-core builds (default: system temp dir)",
+				},
+			}, pruneFlags...),
 			Action: func(c *cli.Context) error {
 				return buildAction(c)
 			},
@@ -53,11 +145,328 @@ func setCommands(app *cli.App) {
 				return generateAction(c)
 			},
 		},
+		{
+			Name:      "compile-model",
+			Aliases:   []string{"co"},
+			Usage:     "Compile a chain's predictions to a model file the app can serve",
+			ArgsUsage: "[chain id]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Value: "model.ptm",
+					Usage: "path to write the model to",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return compileAction(c)
+			},
+		},
+		{
+			Name:      "evaluate",
+			Aliases:   []string{"ev"},
+			Usage:     "Measure how well a chain predicts held-out text",
+			ArgsUsage: "[files (reads stdin if none and not using --holdout)]",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "chain",
+					Usage: "id of the chain to evaluate, may be repeated to average folds",
+				},
+				cli.BoolFlag{
+					Name:  "holdout",
+					Usage: "evaluate on the text held out of each chain instead of files",
+				},
+				cli.BoolFlag{
+					Name:  "predictions",
+					Usage: "evaluate the chain's saved prediction set instead of the chain",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the report as JSON",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return evaluateAction(c)
+			},
+		},
+		{
+			Name:      "compare-models",
+			Aliases:   []string{"cm"},
+			Usage:     "Compare a candidate model to a baseline, failing if it regresses",
+			ArgsUsage: "[baseline id] [candidate id] [files (reads stdin if none)]. Prefix an id with predictions: to compare its prediction set",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "holdout",
+					Usage: "compare on the text held out of the baseline instead of files",
+				},
+				cli.Float64Flag{
+					Name:  "threshold",
+					Value: 0.01,
+					Usage: "largest drop in a rate, or relative rise in perplexity, that is not a regression",
+				},
+				cli.Float64Flag{
+					Name:  "confidence",
+					Value: 0.95,
+					Usage: "width of the confidence intervals",
+				},
+				cli.IntFlag{
+					Name:  "samples",
+					Value: 1000,
+					Usage: "number of bootstrap resamples",
+				},
+				cli.IntFlag{
+					Name:  "changed",
+					Value: 20,
+					Usage: "number of changed prefixes to list",
+				},
+				cli.Int64Flag{
+					Name:  "seed",
+					Usage: "seed for resampling",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the comparison as JSON",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return compareAction(c)
+			},
+		},
+		{
+			Name:      "benchmark-build",
+			Aliases:   []string{"bb"},
+			Usage:     "Measure how many tokens per second chains are built from",
+			ArgsUsage: "[files (reads stdin if none)]",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "tokenizer",
+					Usage: "tokenizer to benchmark, may be repeated (default: all)",
+				},
+				cli.IntFlag{
+					Name:  "repeat",
+					Value: 3,
+					Usage: "number of times to build each chain",
+				},
+				cli.IntFlag{
+					Name:  "workers",
+					Value: 1,
+					Usage: "number of goroutines building each chain",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return benchmarkAction(c)
+			},
+		},
+		{
+			Name:      "benchmark-sync",
+			Aliases:   []string{"bs"},
+			Usage:     "Measure reads and writes of a chain shared between goroutines",
+			ArgsUsage: "[files (reads stdin if none)]",
+			Flags: []cli.Flag{
+				cli.IntSliceFlag{
+					Name:  "stripes",
+					Usage: "number of stripes to benchmark, may be repeated (default: 1 and 64)",
+				},
+				cli.IntFlag{
+					Name:  "readers",
+					Value: runtime.NumCPU(),
+					Usage: "number of goroutines reading the chain",
+				},
+				cli.IntFlag{
+					Name:  "writers",
+					Value: 1,
+					Usage: "number of goroutines adding text to the chain",
+				},
+				cli.DurationFlag{
+					Name:  "duration",
+					Value: 3 * time.Second,
+					Usage: "how long to run each benchmark",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return benchmarkSyncAction(c)
+			},
+		},
+		{
+			Name:      "stats",
+			Aliases:   []string{"st"},
+			Usage:     "Show what a stored chain contains",
+			ArgsUsage: "[chain id]",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "top",
+					Value: 10,
+					Usage: "number of the most common prefixes and suffixes to list",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the statistics as JSON",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return statsAction(c)
+			},
+		},
+		{
+			Name:      "diff-chains",
+			Aliases:   []string{"dc"},
+			Usage:     "Show what changed between two stored chains",
+			ArgsUsage: "[old chain id] [new chain id]",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "top",
+					Value: 20,
+					Usage: "number of prefixes and words to list of each kind",
+				},
+				cli.StringFlag{
+					Name:  "by",
+					Value: common.ShiftByDivergence,
+					Usage: "rank shifted prefixes by KL divergence (kl) or count change (delta)",
+				},
+				cli.IntFlag{
+					Name:  "minCount",
+					Value: 5,
+					Usage: "fewest times a prefix must be seen in both chains to list its shift",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the differences as JSON",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return diffAction(c)
+			},
+		},
+		{
+			Name:      "distinctive-phrases",
+			Aliases:   []string{"dp"},
+			Usage:     "Show the words and phrases a chain uses more than a background chain",
+			ArgsUsage: "[chain id] [background chain id]",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "method",
+					Value: common.DistinctByLLR,
+					Usage: "score phrases by log-likelihood ratio (llr) or TF-IDF (tfidf)",
+				},
+				cli.IntFlag{
+					Name:  "top",
+					Value: 20,
+					Usage: "number of words and of phrases to list",
+				},
+				cli.IntFlag{
+					Name:  "minCount",
+					Value: 3,
+					Usage: "fewest times a phrase must be in the chain to be listed",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "print the phrases as JSON",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return distinctiveAction(c)
+			},
+		},
+		{
+			Name:      "merge-chains",
+			Aliases:   []string{"mc"},
+			Usage:     "Combine stored chains into a new chain",
+			ArgsUsage: "[chain id] [chain id]...",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "weight, w",
+					Usage: "weight of each chain, in the order of the chain ids (default 1 each)",
+				},
+				cli.StringFlag{
+					Name:  "normalization",
+					Value: common.MergeRaw,
+					Usage: "add weighted counts (raw) or average weighted probabilities (probability)",
+				},
+				cli.StringSliceFlag{
+					Name:  "users",
+					Usage: "users to save the merged chain for (default every user of the chains)",
+				},
+				cli.BoolFlag{
+					Name:  "overwrite",
+					Usage: "replace a chain already stored for the users",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return mergeAction(c)
+			},
+		},
+		{
+			Name:      "prune-chain",
+			Aliases:   []string{"pc"},
+			Usage:     "Remove rare and uninformative suffixes from a stored chain",
+			ArgsUsage: "[chain id]",
+			Flags:     pruneFlags,
+			Action: func(c *cli.Context) error {
+				return pruneAction(c)
+			},
+		},
 	}
 }
 
 func buildAction(c *cli.Context) error {
 	source := c.String("source")
+	halfLife := c.Duration("halfLife")
+	if halfLife < 0 {
+		return errors.New("halfLife must not be negative")
+	}
+	tokenizer, err := common.MakeTokenizer(c.String("tokenizer"),
+		map[string]string{"pattern": c.String("tokenPattern")})
+	if err != nil {
+		return err
+	}
+	segmenter, err := common.NewSegmenter(c.String("language"))
+	if err != nil {
+		return err
+	}
+	chain := common.NewDecayChain(2, halfLife, time.Now()).
+		WithTokenizer(tokenizer).
+		WithSegmenter(segmenter)
+	if c.Bool("reverse") {
+		chain = chain.WithReverse()
+	}
+	opts := buildOptions{
+		prune:   pruneOptions(c),
+		learn:   c.Bool("learnAbbreviations"),
+		workers: c.Int("workers"),
+		split: common.Split{
+			By:       c.String("splitBy"),
+			Seed:     c.Int64("seed"),
+			Fraction: c.Float64("holdout"),
+			Folds:    c.Int("folds"),
+			Fold:     c.Int("fold"),
+		},
+	}
+	if err := opts.split.WithFold(util.MaxInt(opts.split.Fold, 1)).Validate(); err != nil {
+		return err
+	}
+	if opts.split.By == common.SplitByUser && source != reddit.String() {
+		return errors.New("only reddit text can be split by user")
+	}
+
+	if c.Bool("compact") {
+		if source != text.String() || halfLife > 0 || !opts.split.IsZero() || c.Bool("reverse") {
+			return errors.New("only text without a half-life, holdout or reverse chain can be built compactly")
+		}
+		if c.Int("memoryLimit") > 0 {
+			return errors.New("a chain cannot be built both compactly and out of core")
+		}
+		return buildChainCompactly(chain, c.Args(), opts)
+	}
+
+	if limit := c.Int("memoryLimit"); limit > 0 {
+		if source != text.String() || halfLife > 0 || !opts.split.IsZero() || c.Bool("reverse") {
+			return errors.New("only text without a half-life, holdout or reverse chain can be built out of core")
+		}
+		if opts.prune.TopN > 0 || opts.prune.MinEntropy > 0 || opts.prune.MaxBytes > 0 {
+			return errors.New("only minCount pruning can be used out of core")
+		}
+		return buildChainExternally(chain, c.Args(), opts, limit, c.String("tempDir"))
+	}
+
 	if source == reddit.String() {
 		// Generate data from scraping reddit comments
 		pageLimit := c.Int("pageLimit")
@@ -66,12 +475,16 @@ func buildAction(c *cli.Context) error {
 		}
 		users := readUsers()
 		log.Println("Done getting user names. Please wait for data to generate.")
-		if err := buildChainFromReddit(users, pageLimit); err != nil {
+		if err := buildChainFromReddit(chain, users, pageLimit, opts); err != nil {
 			return err
 		}
 		return nil
 	} else if source == text.String() {
-		if err := buildChainFromStdin(); err != nil {
+		if c.NArg() == 0 {
+			if err := buildChainFromStdin(chain, opts); err != nil {
+				return err
+			}
+		} else if err := buildChainFromFiles(chain, c.Args(), opts); err != nil {
 			return err
 		}
 	} else {
@@ -81,6 +494,55 @@ func buildAction(c *cli.Context) error {
 	return nil
 }
 
+// buildOptions are the steps taken around building a chain
+type buildOptions struct {
+	prune common.PruneOptions
+	// learn is true if abbreviations are learned from the text first
+	learn bool
+	// split holds text out of the chain. If it has folds but no fold,
+	// a chain is built for each fold.
+	split common.Split
+	// workers is the number of goroutines building the chain
+	workers int
+}
+
+func pruneAction(c *cli.Context) error {
+	opts := pruneOptions(c)
+	if opts.IsZero() {
+		return errors.New("at least one pruning option must be set")
+	}
+
+	dao, err := db.GetChainByID(c.Args().Get(0))
+	if err != nil {
+		return err
+	}
+
+	chain, err := common.ChainFromDao(dao)
+	if err != nil {
+		return err
+	}
+	return saveChain(dao.Users, chain, opts)
+}
+
+func pruneOptions(c *cli.Context) common.PruneOptions {
+	return common.PruneOptions{
+		MinCount:   c.Int("minCount"),
+		TopN:       c.Int("topN"),
+		MinEntropy: c.Float64("minEntropy"),
+		MaxBytes:   c.Int("maxBytes"),
+	}
+}
+
+// saveChain prunes the chain if any options are set and saves it to the db
+func saveChain(users []string, chain common.Chain, opts common.PruneOptions) error {
+	if !opts.IsZero() {
+		report := chain.Prune(opts)
+		log.Printf("Pruned chain\n%v", report)
+	}
+
+	return db.UpsertChain(users, chain)
+}
+
 func generateAction(c *cli.Context) error {
 	predSvc := common.PredictionSvc{DB: db}
 	id := c.Args().Get(0)
@@ -88,6 +550,31 @@ func generateAction(c *cli.Context) error {
 	return err
 }
 
+func compileAction(c *cli.Context) error {
+	// compile next to the output and rename it into place, so a failed
+	// compile leaves the model being served untouched
+	output := c.String("output")
+	f, err := ioutil.TempFile(filepath.Dir(output), filepath.Base(output)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	predSvc := common.PredictionSvc{DB: db}
+	if err := predSvc.CompileModel(c.Args().Get(0), f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), output)
+}
+
 // readUsers reads an arbitrary number of usernames from standard input
 func readUsers() []string {
 	var users []string
@@ -110,11 +597,11 @@ func readUsers() []string {
 
 // getUserComments makes requests to all (or pageLimit) pages of comments
 // and sends them to the comments channel
-func getUserComments(comments chan<- [][]string, usernames <-chan string,
+func getUserComments(comments chan<- [][]comment, usernames <-chan string,
 	done chan<- bool, pageLimit int) {
 
-	var userComments [][]string
-	var page []string
+	var userComments [][]comment
+	var page []comment
 	api := redditAPIClient{}
 	pageRef := ""
 	for username := range usernames {
@@ -135,8 +622,8 @@ func getUserComments(comments chan<- [][]string, usernames <-chan string,
 
 // getAllComments gets up to pageLimit comments for each user in users and
 // passes it to the comments channel
-func getAllComments(users []string, pageLimit int) <-chan [][]string {
-	comments := make(chan [][]string, 100)
+func getAllComments(users []string, pageLimit int) <-chan [][]comment {
+	comments := make(chan [][]comment, 100)
 	go (func() {
 		// create an arbitrary number of workers to get the comments
 		// see https://gobyexample.com/worker-pools
@@ -163,37 +650,291 @@ func getAllComments(users []string, pageLimit int) <-chan [][]string {
 	return comments
 }
 
-func buildChainFromReddit(users []string, pageLimit int) error {
-	chain := common.NewChain(2)
+func buildChainFromReddit(chain common.Chain, users []string, pageLimit int,
+	opts buildOptions) error {
+
+	// comments are only kept until they are built from, unless they are
+	// needed to split them or learn abbreviations from all of them
+	keep := opts.learn || !opts.split.IsZero()
+	docs := make([]common.Document, 0)
 	for commentSet := range getAllComments(users, pageLimit) {
 		for _, page := range commentSet {
 			for _, comment := range page {
-				reader := strings.NewReader(comment)
-				chain.Build(reader)
+				docs = append(docs, common.Document{
+					ID:      comment.id,
+					User:    comment.author,
+					Text:    comment.body,
+					Created: comment.created,
+				})
 			}
 		}
+		if !keep {
+			chain.BuildDocuments(docs, opts.workers)
+			docs = docs[:0]
+		}
 	}
 
 	// Save chain for fast lookup later
-	err := db.UpsertChain(users, chain)
+	var err error
+	if keep {
+		err = buildChainFromDocuments(chain, users, docs, opts)
+	} else {
+		err = saveChain(users, chain, opts.prune)
+	}
 	if err == nil {
 		log.Println("Save successful.")
 	}
 	return err
 }
 
-func buildChainFromStdin() error {
-	reader := bufio.NewReader(os.Stdin)
-	chain := common.NewChain(2)
+// buildChainFromDocuments builds and saves a chain from the documents
+// not held out by the split, or a chain for each fold
+func buildChainFromDocuments(chain common.Chain, users []string,
+	docs []common.Document, opts buildOptions) error {
+
+	splits := []common.Split{opts.split}
+	if opts.split.Folds > 0 && opts.split.Fold == 0 {
+		splits = make([]common.Split, opts.split.Folds)
+		for i := range splits {
+			splits[i] = opts.split.WithFold(i + 1)
+		}
+	}
+
+	for _, split := range splits {
+		train, test := split.Divide(docs)
+		built := chain.Empty()
+		if !split.IsZero() {
+			built = built.WithHoldout(split, test)
+			log.Printf("Holding out %v of %v documents (%v)", len(test), len(docs), split)
+		}
+
+		if opts.learn {
+			trainer := common.NewPunktTrainer()
+			for _, doc := range train {
+				trainer.TrainText(built.GetTokenizer(), strings.NewReader(doc.Text))
+			}
+			built = learnAbbreviations(built, trainer)
+		}
+
+		built.BuildDocuments(train, opts.workers)
+
+		if err := saveChain(users, built, opts.prune); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func buildChainFromStdin(chain common.Chain, opts buildOptions) error {
+	var reader io.Reader = bufio.NewReader(os.Stdin)
+
+	if !opts.split.IsZero() {
+		// each line of stdin is a document that can be held out
+		docs := make([]common.Document, 0)
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(nil, 1<<24)
+		for i := 1; scanner.Scan(); i++ {
+			if strings.TrimSpace(scanner.Text()) != "" {
+				docs = append(docs, common.Document{
+					ID:      fmt.Sprintf("stdin:%v", i),
+					Text:    scanner.Text() + "\n",
+					Created: time.Now(),
+				})
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return buildChainFromDocuments(chain, []string{}, docs, opts)
+	}
+
+	if opts.learn {
+		// stdin can only be read once, so keep it to build from
+		text, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		trainer := common.NewPunktTrainer()
+		trainer.TrainText(chain.GetTokenizer(), bytes.NewReader(text))
+		chain = learnAbbreviations(chain, trainer)
+		reader = bytes.NewReader(text)
+	}
 
 	// Generate
-	chain.Build(reader)
+	if err := chain.BuildParallel(reader, time.Now(), opts.workers); err != nil {
+		return err
+	}
 	log.Printf("Chain generated")
 
 	// Save
-	if err := db.UpsertChain([]string{}, chain); err != nil {
+	if err := saveChain([]string{}, chain, opts.prune); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// buildChainFromFiles builds a chain from text files, dating the text in
+// each file by the time it was last modified
+func buildChainFromFiles(chain common.Chain, paths []string, opts buildOptions) error {
+	if !opts.split.IsZero() {
+		// each file is a document that can be held out
+		docs := make([]common.Document, len(paths))
+		for i, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			text, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			docs[i] = common.Document{ID: path, Text: string(text), Created: info.ModTime()}
+		}
+		return buildChainFromDocuments(chain, []string{}, docs, opts)
+	}
+
+	if opts.learn {
+		var err error
+		if chain, err = learnFromFiles(chain, paths); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		err = chain.BuildParallel(file, info.ModTime(), opts.workers)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		log.Printf("Read %s", path)
+	}
+	log.Printf("Chain generated")
+
+	return saveChain([]string{}, chain, opts.prune)
+}
+
+// buildChainExternally builds a chain from text files, or stdin if there
+// are none, spilling counts to disk to keep within limit bytes of memory,
+// and saves it as an external chain
+func buildChainExternally(chain common.Chain, paths []string, opts buildOptions,
+	limit int, dir string) error {
+
+	if opts.learn {
+		if len(paths) == 0 {
+			return errors.New("abbreviations can only be learned from files out of core")
+		}
+		var err error
+		if chain, err = learnFromFiles(chain, paths); err != nil {
+			return err
+		}
+	}
+
+	builder, err := common.NewExternalBuilder(chain, limit, dir)
+	if err != nil {
+		return err
+	}
+	defer builder.Close()
+	builder.MinCount = opts.prune.MinCount
+
+	if len(paths) == 0 {
+		if err := builder.Build(bufio.NewReader(os.Stdin)); err != nil {
+			return err
+		}
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = builder.Build(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		log.Printf("Read %s", path)
+	}
+
+	entries, err := builder.Entries()
+	if err != nil {
+		return err
+	}
+	log.Printf("Chain generated")
+
+	return db.UpsertExternalChain([]string{}, builder.Chain(), entries)
+}
+
+// buildChainCompactly builds a chain from text files, or stdin if there
+// are none, in a compact chain that keeps its counts within the maxBytes
+// prune option, and saves it
+func buildChainCompactly(chain common.Chain, paths []string, opts buildOptions) error {
+	if opts.learn {
+		if len(paths) == 0 {
+			return errors.New("abbreviations can only be learned from files when building compactly")
+		}
+		var err error
+		if chain, err = learnFromFiles(chain, paths); err != nil {
+			return err
+		}
+	}
+
+	compact, err := common.NewCompactChain(chain.GetPrefixLen(), opts.prune.MaxBytes)
+	if err != nil {
+		return err
+	}
+	compact.WithSplitters(chain.GetTokenizer(), chain.GetSegmenter())
+
+	if len(paths) == 0 {
+		compact.Build(bufio.NewReader(os.Stdin))
+	}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		compact.Build(file)
+		file.Close()
+		log.Printf("Read %s", path)
+	}
+	log.Printf("Chain generated in about %v bytes", compact.EstimateBytes())
+	if dropped := compact.Dropped(); dropped > 0 {
+		log.Printf("Dropped suffixes seen up to %v times to fit in %v bytes", dropped, opts.prune.MaxBytes)
+	}
+
+	// the counts were already kept within maxBytes
+	opts.prune.MaxBytes = 0
+	return saveChain([]string{}, compact.ToChain(), opts.prune)
+}
+
+// learnFromFiles returns a copy of chain that also treats the
+// abbreviations learned from the text files at paths as abbreviations
+func learnFromFiles(chain common.Chain, paths []string) (common.Chain, error) {
+	trainer := common.NewPunktTrainer()
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return chain, err
+		}
+		trainer.TrainText(chain.GetTokenizer(), bufio.NewReader(file))
+		file.Close()
+	}
+	return learnAbbreviations(chain, trainer), nil
+}
+
+// learnAbbreviations returns a copy of chain that also treats the
+// abbreviations found by trainer as abbreviations
+func learnAbbreviations(chain common.Chain, trainer *common.PunktTrainer) common.Chain {
+	learned := trainer.Abbreviations()
+	log.Printf("Learned %v abbreviations: %v", len(learned), learned)
+	return chain.WithLearnedAbbreviations(learned)
+}
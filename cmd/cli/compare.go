@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/evaluation"
+)
+
+// predictionsModel prefixes a chain id to compare its saved prediction set
+const predictionsModel = "predictions:"
+
+func compareAction(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return errors.New("a baseline and a candidate model must be given")
+	}
+	if c.Bool("holdout") && c.NArg() > 2 {
+		return errors.New("files cannot be compared with --holdout")
+	}
+	if c.Int("samples") <= 0 {
+		return errors.New("samples must be greater than 0")
+	}
+	if c.Float64("confidence") <= 0 || c.Float64("confidence") >= 1 {
+		return errors.New("confidence must be between 0 and 1")
+	}
+
+	baseline, holdout, err := newModelEvaluator(c.Args().Get(0), c.Bool("holdout"))
+	if err != nil {
+		return err
+	}
+	candidate, _, err := newModelEvaluator(c.Args().Get(1), false)
+	if err != nil {
+		return err
+	}
+
+	// both models must read the same text
+	if c.Bool("holdout") {
+		err = evaluateHoldout(baseline, holdout)
+		if err == nil {
+			err = evaluateHoldout(candidate, holdout)
+		}
+	} else {
+		var corpus []byte
+		if corpus, err = readCorpus(c.Args()[2:]); err == nil {
+			err = baseline.Evaluate(bytes.NewReader(corpus))
+		}
+		if err == nil {
+			err = candidate.Evaluate(bytes.NewReader(corpus))
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	comparison, err := evaluation.Compare(baseline, candidate, evaluation.CompareOptions{
+		Samples:    c.Int("samples"),
+		Confidence: c.Float64("confidence"),
+		Threshold:  c.Float64("threshold"),
+		Changed:    c.Int("changed"),
+		Seed:       c.Int64("seed"),
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		err = json.NewEncoder(os.Stdout).Encode(comparison)
+	} else {
+		err = printComparison(comparison, c.Float64("confidence"))
+	}
+	if err != nil {
+		return err
+	}
+
+	if comparison.Regressed {
+		return cli.NewExitError("candidate regressed", 1)
+	}
+	return nil
+}
+
+// newModelEvaluator creates an evaluator for a chain id, or the
+// prediction set of a chain id prefixed with predictionsModel
+func newModelEvaluator(model string, holdout bool) (*evaluation.Evaluator,
+	*domain.HoldoutDao, error) {
+
+	id := strings.TrimPrefix(model, predictionsModel)
+	return newEvaluator(id, id != model, holdout)
+}
+
+func printComparison(comparison evaluation.Comparison, confidence float64) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if !comparison.Paired {
+		fmt.Fprintln(w, "Models split the corpus differently, so tokens were resampled independently.")
+	}
+	fmt.Fprintf(w, "metric\tbaseline\tcandidate\tdelta\t%g%% interval\t\n", confidence*100)
+	for _, d := range comparison.Deltas {
+		flag := ""
+		if d.Regressed {
+			flag = "REGRESSED"
+		}
+		fmt.Fprintf(w, "%s\t%.4f\t%.4f\t%+.4f\t[%+.4f, %+.4f]\t%s\n",
+			d.Metric, d.Baseline, d.Candidate, d.Delta, d.Low, d.High, flag)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if len(comparison.Changed) == 0 {
+		return nil
+	}
+	fmt.Println("\nMost changed prefixes")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "prefix\tcount\tbaseline\tcandidate\t")
+	for _, change := range comparison.Changed {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t\n", change.Key, change.Count,
+			strings.Join(change.Baseline, " | "), strings.Join(change.Candidate, " | "))
+	}
+	return w.Flush()
+}
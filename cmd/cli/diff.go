@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+	"github.com/zacwhalley/predictivetext/common"
+)
+
+func diffAction(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("two chain ids are required")
+	}
+	if c.Int("top") < 0 || c.Int("top") > common.MaxTop {
+		return fmt.Errorf("top must be from 0 to %v", common.MaxTop)
+	}
+
+	chains := make([]common.Chain, 2)
+	for i := range chains {
+		dao, err := db.GetChainByID(c.Args().Get(i))
+		if err != nil {
+			return err
+		}
+		if chains[i], err = common.ChainFromDao(dao); err != nil {
+			return err
+		}
+	}
+
+	diff, err := common.DiffChains(chains[0], chains[1], common.DiffOptions{
+		Top:      c.Int("top"),
+		By:       c.String("by"),
+		MinCount: c.Int("minCount"),
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(diff)
+	}
+	return printDiff(diff)
+}
+
+// printDiff prints a diff as tables
+func printDiff(diff common.ChainDiff) error {
+	fmt.Printf("Prefixes: %v shared, %v added, %v removed\n",
+		diff.PrefixesShared, diff.PrefixesAdded, diff.PrefixesRemoved)
+
+	if err := printCounts("Most common added prefixes", "prefix", diff.Added); err != nil {
+		return err
+	}
+	if err := printCounts("Most common removed prefixes", "prefix", diff.Removed); err != nil {
+		return err
+	}
+
+	if len(diff.Shifted) > 0 {
+		fmt.Println("\nMost shifted prefixes")
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "prefix\tbefore\tafter\tKL (bits)\tdelta\ttop before\ttop after\t")
+		for _, shift := range diff.Shifted {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%.3f\t%d\t%s\t%s\t\n",
+				shift.Key, shift.Before, shift.After, shift.Divergence, shift.Delta,
+				strings.Join(shift.TopBefore, " | "), strings.Join(shift.TopAfter, " | "))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if err := printCounts("New words", "word", diff.NewWords); err != nil {
+		return err
+	}
+	return printCounts("Lost words", "word", diff.LostWords)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+	"github.com/zacwhalley/predictivetext/common"
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+func distinctiveAction(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("a chain id and a background chain id are required")
+	}
+	top := c.Int("top")
+	if top < 0 || top > common.MaxTop {
+		return fmt.Errorf("top must be from 0 to %v", common.MaxTop)
+	}
+
+	predSvc := common.PredictionSvc{DB: db}
+	phrases, err := predSvc.GetDistinctivePhrases(c.Args().Get(0), c.Args().Get(1),
+		c.String("method"), top, c.Int("minCount"))
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(phrases)
+	}
+	if err := printPhrases("Distinctive words", "word", phrases.Words); err != nil {
+		return err
+	}
+	return printPhrases("Distinctive phrases", "phrase", phrases.Phrases)
+}
+
+// printPhrases prints a titled table of distinctive phrases
+func printPhrases(title, name string, phrases []domain.DistinctivePhrase) error {
+	if len(phrases) == 0 {
+		return nil
+	}
+	fmt.Printf("\n%s\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tscore\tcount\tbackground\t\n", name)
+	for _, phrase := range phrases {
+		fmt.Fprintf(w, "%s\t%.3f\t%d\t%d\t\n",
+			phrase.Phrase, phrase.Score, phrase.Count, phrase.BackgroundCount)
+	}
+	return w.Flush()
+}
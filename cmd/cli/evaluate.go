@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli"
+	"github.com/zacwhalley/predictivetext/common"
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/evaluation"
+)
+
+// crossValidationReport is the JSON output when evaluating several chains
+type crossValidationReport struct {
+	Reports map[string]evaluation.Report `json:"reports"`
+	Mean    evaluation.Report            `json:"mean"`
+}
+
+func evaluateAction(c *cli.Context) error {
+	ids := c.StringSlice("chain")
+	if len(ids) == 0 {
+		return errors.New("chain must be set")
+	}
+	if c.Bool("holdout") && c.NArg() > 0 {
+		return errors.New("files cannot be evaluated with --holdout")
+	}
+	if len(ids) > 1 && !c.Bool("holdout") && c.NArg() == 0 {
+		return errors.New("stdin can only be evaluated against one chain")
+	}
+
+	reports := make(map[string]evaluation.Report)
+	all := make([]evaluation.Report, 0, len(ids))
+	for _, id := range ids {
+		report, err := evaluateChain(c, id)
+		if err != nil {
+			return err
+		}
+		reports[id] = report
+		all = append(all, report)
+	}
+
+	if len(ids) == 1 {
+		if c.Bool("json") {
+			return json.NewEncoder(os.Stdout).Encode(all[0])
+		}
+		fmt.Println(all[0])
+		return nil
+	}
+
+	mean := evaluation.Mean(all)
+	if c.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(crossValidationReport{reports, mean})
+	}
+	for _, id := range ids {
+		fmt.Printf("Chain %s\n%v\n\n", id, reports[id])
+	}
+	fmt.Printf("Mean of %v chains\n%v\n", len(ids), mean)
+	return nil
+}
+
+// evaluateChain evaluates one chain or its prediction set
+func evaluateChain(c *cli.Context, id string) (evaluation.Report, error) {
+	evaluator, holdout, err := newEvaluator(id, c.Bool("predictions"), c.Bool("holdout"))
+	if err != nil {
+		return evaluation.Report{}, err
+	}
+
+	if c.Bool("holdout") {
+		err = evaluateHoldout(evaluator, holdout)
+	} else {
+		err = evaluateFiles(evaluator, c.Args())
+	}
+	return evaluator.Report(), err
+}
+
+// newEvaluator creates an evaluator for a chain or, if predictions is
+// true, the prediction set saved from it. The chain's held-out text is
+// also returned if holdout is true.
+func newEvaluator(id string, predictions, holdout bool) (*evaluation.Evaluator,
+	*domain.HoldoutDao, error) {
+
+	// the chain's data is only needed to predict from the chain, and
+	// its held-out text to evaluate on it
+	getChain := db.GetChainByID
+	if predictions && !holdout {
+		getChain = db.GetChainInfoByID
+	}
+	dao, err := getChain(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if holdout && dao.Holdout == nil {
+		return nil, nil, fmt.Errorf("chain %s has no held-out text", id)
+	}
+
+	tokenizer, err := common.TokenizerFromDao(dao)
+	if err != nil {
+		return nil, nil, err
+	}
+	segmenter, err := common.SegmenterFromDao(dao)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var predictor domain.Predictor = common.PredictionSetPredictor{DB: db, Source: id}
+	if predictions {
+		// every prediction set predicts the start of a sentence
+		start := common.NewPrefix(dao.PrefixLen).ToString()
+		if _, err := db.GetPrediction(start, id); err != nil {
+			return nil, nil, fmt.Errorf("chain %s has no prediction set: %v", id, err)
+		}
+	} else {
+		chain, err := common.ChainFromDao(dao)
+		if err != nil {
+			return nil, nil, err
+		}
+		predictor = common.NewChainPredictor(chain)
+	}
+
+	evaluator := &evaluation.Evaluator{
+		Predictor: predictor,
+		Tokenizer: tokenizer,
+		Segmenter: segmenter,
+		PrefixLen: dao.PrefixLen,
+	}
+	return evaluator, dao.Holdout, nil
+}
+
+// evaluateHoldout evaluates each held-out document
+func evaluateHoldout(evaluator *evaluation.Evaluator, holdout *domain.HoldoutDao) error {
+	for _, doc := range holdout.Documents {
+		if err := evaluator.Evaluate(strings.NewReader(doc.Text)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluateFiles evaluates the text in each file, or stdin if there are none
+func evaluateFiles(evaluator *evaluation.Evaluator, paths []string) error {
+	if len(paths) == 0 {
+		return evaluator.Evaluate(bufio.NewReader(os.Stdin))
+	}
+
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = evaluator.Evaluate(bufio.NewReader(file))
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/urfave/cli"
+	"github.com/zacwhalley/predictivetext/common"
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+func mergeAction(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return errors.New("at least two chain ids are required")
+	}
+
+	weights := c.StringSlice("weight")
+	if len(weights) > 0 && len(weights) != c.NArg() {
+		return fmt.Errorf("%v weights given for %v chains", len(weights), c.NArg())
+	}
+
+	sources := make([]domain.ChainSourceDao, c.NArg())
+	for i, id := range c.Args() {
+		sources[i] = domain.ChainSourceDao{ChainID: id, Weight: 1}
+		if len(weights) > 0 {
+			weight, err := strconv.ParseFloat(weights[i], 64)
+			if err != nil {
+				return err
+			}
+			sources[i].Weight = weight
+		}
+	}
+
+	predSvc := common.PredictionSvc{DB: db}
+	return predSvc.MergeChains(c.StringSlice("users"), sources, c.String("normalization"),
+		c.Bool("overwrite"))
+}
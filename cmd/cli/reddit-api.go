@@ -11,8 +11,16 @@ import (
 type redditAPIClient struct {
 }
 
+// comment is the plain text of a reddit comment, who wrote it and when
+type comment struct {
+	id      string
+	author  string
+	body    string
+	created time.Time
+}
+
 // getUserComments returns an array of the comments by username on page, and a reference to the next page
-func (r redditAPIClient) getUserComments(username string, pageRef string) ([]string, string) {
+func (r redditAPIClient) getUserComments(username string, pageRef string) ([]comment, string) {
 	// make request to /u/username's comments
 	url := fmt.Sprintf("https://www.reddit.com/user/%s/comments.json", username)
 	req, err := http.NewRequest("GET", url, nil)
@@ -43,9 +51,14 @@ func (r redditAPIClient) getUserComments(username string, pageRef string) ([]str
 		panic(err)
 	}
 
-	comments := make([]string, len(page.Data.Children))
-	for i, comment := range page.Data.Children {
-		comments[i] = comment.Data.Body
+	comments := make([]comment, len(page.Data.Children))
+	for i, child := range page.Data.Children {
+		comments[i] = comment{
+			id:      child.Data.Name,
+			author:  child.Data.Author,
+			body:    preprocessComment(child.Data.Author, child.Data.Body),
+			created: time.Unix(int64(child.Data.CreatedUTC), 0),
+		}
 	}
 
 	return comments, page.Data.After
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// botAuthors are accounts whose comments are never written by a person
+var botAuthors = map[string]bool{
+	"automoderator": true,
+}
+
+// botSignatures match paragraphs that bots and moderation tools add to
+// comments. Paragraphs matching any of them are dropped.
+var botSignatures = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\bI(?:'m| am) a bot\b`),
+	regexp.MustCompile(`(?i)this action was performed automatically`),
+	regexp.MustCompile(`(?i)contact the moderators of this subreddit`),
+	regexp.MustCompile(`(?i)\bbeep,? boop\b`),
+	regexp.MustCompile(`(?i)\bbot\b.*\b(?:opt.?out|feedback|source|github)\b`),
+}
+
+// Block level markdown
+var (
+	fencePattern     = regexp.MustCompile("^\\s*(?:```|~~~)")
+	indentedPattern  = regexp.MustCompile(`^(?: {4}|\t)`)
+	quotePattern     = regexp.MustCompile(`^\s*>`)
+	rulePattern      = regexp.MustCompile(`^\s*(?:(?:\*\s*){3,}|(?:-\s*){3,}|(?:_\s*){3,})$`)
+	tableRowPattern  = regexp.MustCompile(`^\s*\|.*\|\s*$`)
+	tableRulePattern = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(?:\|\s*:?-+:?\s*)+\|?\s*$`)
+	headingPattern   = regexp.MustCompile(`^\s*#{1,6}\s*`)
+	listPattern      = regexp.MustCompile(`^\s*(?:[*+-]|\d+[.)])\s+`)
+)
+
+// Inline markdown
+var (
+	codePattern          = regexp.MustCompile("`[^`]*`")
+	strikethroughPattern = regexp.MustCompile(`~~.*?~~`)
+	spoilerPattern       = regexp.MustCompile(`>!(.*?)!<`)
+	linkPattern          = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	superscriptPattern   = regexp.MustCompile(`\^\(([^)]*)\)|\^`)
+	emphasisPattern      = regexp.MustCompile(`\*\*|__|\*`)
+	userPattern          = regexp.MustCompile(`(^|[^\w/])/?u/[\w-]+`)
+	subredditPattern     = regexp.MustCompile(`(^|[^\w/])/?r/\w+`)
+)
+
+// preprocessComment converts the markdown of a reddit comment by author
+// to plain text. Quoted text, code, tables, struck out text and bot
+// signatures are removed since they were not written by the author,
+// and mentions of users and subreddits become placeholder tokens.
+func preprocessComment(author, body string) string {
+	if botAuthors[strings.ToLower(author)] {
+		return ""
+	}
+	if body == "[deleted]" || body == "[removed]" {
+		return ""
+	}
+
+	// reddit escapes &, < and > in comment bodies
+	body = html.UnescapeString(body)
+
+	paragraphs := make([]string, 0)
+	paragraph := make([]string, 0)
+	endParagraph := func() {
+		text := strings.Join(paragraph, "\n")
+		paragraph = paragraph[:0]
+		if strings.TrimSpace(text) == "" || isBotSignature(text) {
+			return
+		}
+		paragraphs = append(paragraphs, text)
+	}
+
+	inFence := false
+	for _, line := range strings.Split(body, "\n") {
+		if fencePattern.MatchString(line) {
+			inFence = !inFence
+			continue
+		}
+
+		switch {
+		case inFence, indentedPattern.MatchString(line), quotePattern.MatchString(line),
+			tableRowPattern.MatchString(line), tableRulePattern.MatchString(line):
+			// not the author's prose
+			continue
+		case strings.TrimSpace(line) == "", rulePattern.MatchString(line):
+			endParagraph()
+			continue
+		}
+
+		line = headingPattern.ReplaceAllString(line, "")
+		line = listPattern.ReplaceAllString(line, "")
+		paragraph = append(paragraph, preprocessInline(line))
+	}
+	endParagraph()
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// preprocessInline removes inline markdown from a line of a comment
+func preprocessInline(line string) string {
+	line = codePattern.ReplaceAllString(line, "")
+	line = strikethroughPattern.ReplaceAllString(line, "")
+	line = spoilerPattern.ReplaceAllString(line, "$1")
+	line = linkPattern.ReplaceAllString(line, "$1")
+	line = superscriptPattern.ReplaceAllString(line, "$1")
+	line = emphasisPattern.ReplaceAllString(line, "")
+	line = userPattern.ReplaceAllString(line, "$1 "+util.UserMention+" ")
+	line = subredditPattern.ReplaceAllString(line, "$1 "+util.SubredditMention+" ")
+	return line
+}
+
+// isBotSignature returns true if text matches a known bot signature
+func isBotSignature(text string) bool {
+	for _, signature := range botSignatures {
+		if signature.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
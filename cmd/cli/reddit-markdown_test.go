@@ -0,0 +1,52 @@
+package main
+
+import "testing"
+
+func TestPreprocessComment(t *testing.T) {
+	tests := []struct {
+		name   string
+		author string
+		body   string
+		want   string
+	}{
+		{"prose", "user", "Just some text.", "Just some text."},
+		{"link", "user", "See [the docs](http://example.com) now", "See the docs now"},
+		{"emphasis", "user", "It is **very** *good*", "It is very good"},
+		{"inline code", "user", "Run `ls -l` now", "Run  now"},
+		{"fenced code", "user", "Before\n```\ncode here\n```\nAfter", "Before\nAfter"},
+		{"indented code", "user", "Text\n\n    code here\n\nMore", "Text\n\nMore"},
+		{"quote", "user", "> what they said\nMy reply", "My reply"},
+		{"table", "user", "| a | b |\n|---|---|\n| 1 | 2 |\n\nAfter", "After"},
+		{"prose with pipes", "user", "Use a | b | c in the shell", "Use a | b | c in the shell"},
+		{"heading and list", "user", "# Title\n- one\n1. two", "Title\none\ntwo"},
+		{"escaped html", "user", "you &amp; me", "you & me"},
+		{"bot signature", "user", "Hi\n\nI am a bot, beep boop", "Hi"},
+		{"bot author", "AutoModerator", "Your post was removed", ""},
+		{"deleted", "user", "[deleted]", ""},
+	}
+
+	for _, test := range tests {
+		if got := preprocessComment(test.author, test.body); got != test.want {
+			t.Errorf("%v: preprocessComment() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPreprocessInline(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"thanks u/someone", "thanks  <user> "},
+		{"see /r/golang", "see  <subreddit> "},
+		{"~~struck~~ kept", " kept"},
+		{">!spoiler!< here", "spoiler here"},
+		{"x^(2) and y^2", "x2 and y2"},
+	}
+
+	for _, test := range tests {
+		if got := preprocessInline(test.in); got != test.want {
+			t.Errorf("preprocessInline(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
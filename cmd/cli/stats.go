@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/urfave/cli"
+	"github.com/zacwhalley/predictivetext/common"
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+func statsAction(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return errors.New("a chain id is required")
+	}
+	top := c.Int("top")
+	if top < 0 || top > common.MaxTop {
+		return fmt.Errorf("top must be from 0 to %v", common.MaxTop)
+	}
+
+	predSvc := common.PredictionSvc{DB: db}
+	stats, err := predSvc.GetChainStats(c.Args().Get(0), top)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("json") {
+		return json.NewEncoder(os.Stdout).Encode(stats)
+	}
+	return printStats(stats)
+}
+
+// printStats prints chain statistics as tables
+func printStats(stats domain.ChainStats) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "prefix length\t%d\t\n", stats.PrefixLen)
+	fmt.Fprintf(w, "tokens\t%d\t\n", stats.Tokens)
+	fmt.Fprintf(w, "vocabulary\t%d\t\n", stats.Vocabulary)
+	fmt.Fprintf(w, "prefixes\t%d\t\n", stats.Prefixes)
+	fmt.Fprintf(w, "branching factor\t%.2f\t\n", stats.BranchingFactor)
+	fmt.Fprintf(w, "conditional entropy\t%.3f bits\t\n", stats.ConditionalEntropy)
+	fmt.Fprintf(w, "singleton ratio\t%.2f%%\t\n", stats.SingletonRatio*100)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("\nN-grams")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "order\tdistinct\tsingletons\t")
+	for _, order := range stats.NGrams {
+		fmt.Fprintf(w, "%d\t%d\t%d\t\n", order.Order, order.Distinct, order.Singletons)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Println("\nCount of counts")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "count\tn-grams\t")
+	last := 0
+	for _, bucket := range stats.CountOfCounts {
+		fmt.Fprintf(w, "%d\t%d\t\n", bucket.Count, bucket.NGrams)
+		last = bucket.Count
+	}
+	fmt.Fprintf(w, ">%d\t%d\t\n", last, stats.CountOfCountsOver)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if err := printCounts("Top prefixes", "prefix", stats.TopPrefixes); err != nil {
+		return err
+	}
+	return printCounts("Top suffixes", "suffix", stats.TopSuffixes)
+}
+
+// printCounts prints a titled table of counts
+func printCounts(title, name string, counts []domain.CountStat) error {
+	if len(counts) == 0 {
+		return nil
+	}
+	fmt.Printf("\n%s\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tcount\t\n", name)
+	for _, count := range counts {
+		fmt.Fprintf(w, "%s\t%d\t\n", count.Key, count.Count)
+	}
+	return w.Flush()
+}
@@ -2,8 +2,9 @@ package common
 
 import (
 	"bufio"
-	"fmt"
 	"io"
+	"strings"
+	"time"
 
 	"github.com/zacwhalley/predictivetext/domain"
 	"github.com/zacwhalley/predictivetext/util"
@@ -12,14 +13,142 @@ import (
 // Chain contains a map ("chain") of Prefixes to a list of suffixes
 // A Prefix is a string of PrefixLen words joined with spaces
 // A suffix is a single word. A Prefix can have multiple suffixes
+//
+// Suffixes are stored in lower case without punctuation. forms counts
+// how each word is written so suggestions can be shown in their usual case.
+//
+// If the chain has a half-life it also keeps a decayed weight for each
+// suffix, measured at epoch, so that recent text ranks above old text
+//
+// A chain made WithReverse also counts each word after the words that
+// follow it, so blanks can be filled in from both sides
 type Chain struct {
 	data      domain.SetMap
 	prefixLen int
+	forms     SetMap
+	tokenizer domain.Tokenizer
+	segmenter domain.Segmenter
+	weights   WeightMap
+	halfLife  time.Duration
+	epoch     time.Time
+	holdout   *domain.HoldoutDao
+	merge     *domain.MergeDao
+	reverse   SetMap
 }
 
 // NewChain returns a string with Prefixes of length PrefixLen
 func NewChain(prefixLen int) Chain {
-	return Chain{make(SetMap), prefixLen}
+	return Chain{
+		data:      make(SetMap),
+		prefixLen: prefixLen,
+		forms:     make(SetMap),
+		tokenizer: DefaultTokenizer,
+		segmenter: DefaultSegmenter,
+	}
+}
+
+// NewDecayChain returns a chain whose suffix weights halve every halfLife
+// before epoch. A halfLife of 0 returns a chain without decay.
+func NewDecayChain(prefixLen int, halfLife time.Duration, epoch time.Time) Chain {
+	chain := NewChain(prefixLen)
+	if halfLife > 0 {
+		chain.weights = make(WeightMap)
+		chain.halfLife = halfLife
+		chain.epoch = epoch
+	}
+	return chain
+}
+
+// Empty returns a chain built the same way as c but without any text
+func (c Chain) Empty() Chain {
+	c.data = make(SetMap)
+	c.forms = make(SetMap)
+	if c.weights != nil {
+		c.weights = make(WeightMap)
+	}
+	if c.reverse != nil {
+		c.reverse = make(SetMap)
+	}
+	c.holdout = nil
+	c.merge = nil
+	return c
+}
+
+// WithTokenizer returns a copy of the chain that tokenizes text with tokenizer
+func (c Chain) WithTokenizer(tokenizer domain.Tokenizer) Chain {
+	c.tokenizer = tokenizer
+	return c
+}
+
+// WithSegmenter returns a copy of the chain that finds the ends of
+// sentences with segmenter
+func (c Chain) WithSegmenter(segmenter domain.Segmenter) Chain {
+	c.segmenter = segmenter
+	return c
+}
+
+// WithLearnedAbbreviations returns a copy of the chain whose segmenter
+// also treats learned as abbreviations
+func (c Chain) WithLearnedAbbreviations(learned []string) Chain {
+	if segmenter, ok := c.segmenter.(Segmenter); ok {
+		c.segmenter = segmenter.WithAbbreviations(learned)
+	}
+	return c
+}
+
+// WithReverse returns a copy of the chain that also builds a reverse
+// chain, mapping the words after each word to it
+func (c Chain) WithReverse() Chain {
+	if c.reverse == nil {
+		c.reverse = make(SetMap)
+	}
+	return c
+}
+
+// WithHoldout returns a copy of the chain recording that docs were
+// held out of its training text by split
+func (c Chain) WithHoldout(split Split, docs []Document) Chain {
+	holdout := &domain.HoldoutDao{
+		Split:     split.String(),
+		Documents: make([]domain.DocumentDao, len(docs)),
+	}
+	for i, doc := range docs {
+		holdout.Documents[i] = domain.DocumentDao(doc)
+	}
+	c.holdout = holdout
+	return c
+}
+
+// ChainFromDao creates a chain from its stored representation. It returns
+// an error if the way the chain split text is not known.
+func ChainFromDao(dao domain.UserChainDao) (Chain, error) {
+	tokenizer, err := TokenizerFromDao(dao)
+	if err != nil {
+		return Chain{}, err
+	}
+	segmenter, err := SegmenterFromDao(dao)
+	if err != nil {
+		return Chain{}, err
+	}
+
+	chain := Chain{
+		data:      MakeSetMap(dao.Data),
+		prefixLen: dao.PrefixLen,
+		forms:     MakeSetMap(dao.Forms),
+		tokenizer: tokenizer,
+		segmenter: segmenter,
+		holdout:   dao.Holdout,
+		merge:     dao.Merge,
+	}
+	if dao.Reverse != nil {
+		chain.reverse = MakeSetMap(dao.Reverse)
+	}
+	if dao.HalfLife > 0 {
+		chain.weights = MakeWeightMap(dao.Weights)
+		chain.halfLife = dao.HalfLife
+		chain.epoch = dao.Epoch
+	}
+	return chain, nil
 }
 
 // GetData returns the chain's chain Data value
@@ -32,28 +161,190 @@ func (c Chain) GetPrefixLen() int {
 	return c.prefixLen
 }
 
+// GetForms returns the number of times each word was written in each case
+func (c Chain) GetForms() map[string]map[string]int {
+	return c.forms.ToPrimitive()
+}
+
+// GetTokenizer returns the tokenizer the chain was built with
+func (c Chain) GetTokenizer() domain.Tokenizer {
+	return c.tokenizer
+}
+
+// GetSegmenter returns the segmenter the chain was built with
+func (c Chain) GetSegmenter() domain.Segmenter {
+	return c.segmenter
+}
+
+// GetWeights returns the chain's decayed weights, or nil if it has no decay
+func (c Chain) GetWeights() map[string]map[string]float64 {
+	if c.weights == nil {
+		return nil
+	}
+	return c.weights.ToPrimitive()
+}
+
+// GetHalfLife returns the chain's half-life, 0 if it has no decay
+func (c Chain) GetHalfLife() time.Duration {
+	return c.halfLife
+}
+
+// GetEpoch returns the time the chain's decayed weights are measured at
+func (c Chain) GetEpoch() time.Time {
+	return c.epoch
+}
+
+// GetHoldout returns the text held out of the chain's training text,
+// or nil if none was
+func (c Chain) GetHoldout() *domain.HoldoutDao {
+	return c.holdout
+}
+
+// GetMerge returns the chains the chain was merged from, or nil if it
+// was built from text
+func (c Chain) GetMerge() *domain.MergeDao {
+	return c.merge
+}
+
+// GetReverse returns the counts of the reverse chain, or nil if the
+// chain has none
+func (c Chain) GetReverse() map[string]map[string]int {
+	if c.reverse == nil {
+		return nil
+	}
+	return c.reverse.ToPrimitive()
+}
+
 // Get returns the value in the chain indexed by key
 func (c Chain) Get(key string) (domain.Set, bool) {
 	set, ok := c.data.Get(key)
 	return set, ok
 }
 
+// AddAt counts word as a suffix of key, observed at time t
+func (c Chain) AddAt(key, word string, t time.Time) {
+	c.add(key, word, t, true)
+}
+
+// add counts word as a suffix of key, and counts the way it was written
+// if countForm is true
+func (c Chain) add(key, word string, t time.Time, countForm bool) {
+	value := util.Clean(word)
+	if value == " " {
+		return
+	}
+
+	c.data.Add(key, value)
+	if countForm && util.IsWord(value) {
+		c.forms.Add(value, util.Strip(word))
+	}
+	if c.weights != nil {
+		c.weights.Add(key, value, decayFactor(t, c.epoch, c.halfLife))
+	}
+}
+
+// Form returns the way word is most often written, preferring
+// lower case when forms are equally common
+func (c Chain) Form(word string) string {
+	if form, ok := placeholderForms[word]; ok {
+		return form
+	}
+
+	forms, ok := c.forms[word]
+	if !ok {
+		return word
+	}
+
+	best, bestCount := word, 0
+	for form, count := range forms {
+		if count > bestCount || (count == bestCount && form > best) {
+			best, bestCount = form, count
+		}
+	}
+	return best
+}
+
+// Render writes each token of phrase in its most common form,
+// attaching punctuation to its words and ending the phrase at
+// the end of a sentence
+func (c Chain) Render(phrase string) string {
+	tokens := make([]string, 0)
+	for _, token := range strings.Fields(phrase) {
+		if token == util.SentenceEnd {
+			break
+		}
+		tokens = append(tokens, c.Form(token))
+	}
+	return util.JoinTokens(tokens)
+}
+
+// placeholderForms are how placeholders are shown in suggestions
+var placeholderForms = map[string]string{
+	util.UserMention:      "/u/",
+	util.SubredditMention: "/r/",
+}
+
+// Union merges other into the chain. Decayed weights from other are
+// moved to this chain's epoch; counts without weights are treated as
+// observed at the epoch.
+func (c Chain) Union(other Chain) {
+	if c.weights != nil {
+		if other.weights != nil {
+			scale := decayFactor(other.epoch, c.epoch, c.halfLife)
+			c.weights.Union(other.weights, scale)
+		} else {
+			for key, set := range other.data.(SetMap) {
+				for value, count := range set {
+					c.weights.Add(key, value, float64(count))
+				}
+			}
+		}
+	}
+	c.data.Union(other.data)
+	c.forms.Union(other.forms)
+	if c.reverse != nil && other.reverse != nil {
+		c.reverse.Union(other.reverse)
+	}
+}
+
+// rankData returns the counts used to rank suffixes, which are the
+// decayed weights if the chain has them
+func (c Chain) rankData() SetMap {
+	if c.weights != nil {
+		return c.weights.ToSetMap()
+	}
+	return c.data.(SetMap)
+}
+
 // Build reads text from the provided Reader and parses it into Prefixes
 // and suffixes stored in the chain
 func (c Chain) Build(r io.Reader) {
+	c.BuildAt(r, c.epoch)
+}
+
+// BuildAt is Build for text written at time t
+func (c Chain) BuildAt(r io.Reader, t time.Time) {
 	br := bufio.NewReader(r)
-	p := make(Prefix, c.prefixLen)
+	p := NewPrefix(c.prefixLen)
+	var sentence []string
 	for {
-		var s string
-		if _, err := fmt.Fscan(br, &s); err != nil {
-			break
-		}
-		s = util.Filter(s)
-		if s != "" {
-			// If s was filtered out
+		text, err := ReadText(br)
+		for _, token := range SentenceTokens(c.tokenizer, c.segmenter, text) {
 			key := p.ToString()
-			c.data.Add(key, s)
-			p.Shift(s)
+			// words starting a sentence are capitalized because of
+			// their position, so their form is not counted
+			c.add(key, token, t, !p.IsEmpty())
+			p.Shift(token)
+			sentence = c.readReverse(sentence, token)
+		}
+		if err != nil {
+			break
 		}
 	}
+
+	// the end of the text also ends its last sentence
+	if !p.IsEmpty() {
+		c.add(p.ToString(), util.SentenceEnd, t, false)
+		c.addReverse(sentence)
+	}
 }
@@ -0,0 +1,137 @@
+package common
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// benchmarkCorpus returns about size bytes of made-up sentences
+func benchmarkCorpus(size int) []byte {
+	words := strings.Fields(`the a cat dog sat ran on under mat rug door
+		quickly slowly because it was happy tired, and but I you we they
+		Hello world don't can't café naïve "quoted" (aside) example.com`)
+	endings := []string{".", "!", "?"}
+	rnd := rand.New(rand.NewSource(1))
+
+	var corpus bytes.Buffer
+	for corpus.Len() < size {
+		n := 3 + rnd.Intn(12)
+		for i := 0; i < n; i++ {
+			word := words[rnd.Intn(len(words))]
+			if i == 0 {
+				word = util.Capitalize(word)
+			}
+			corpus.WriteString(word)
+			corpus.WriteByte(' ')
+		}
+		corpus.WriteString(endings[rnd.Intn(len(endings))])
+		if rnd.Intn(5) == 0 {
+			corpus.WriteByte('\n')
+		} else {
+			corpus.WriteByte(' ')
+		}
+	}
+	return corpus.Bytes()
+}
+
+func TestBuild(t *testing.T) {
+	chain := NewChain(2)
+	chain.Build(strings.NewReader("The cat sat. The cat ran!"))
+	tests := []struct {
+		key    string
+		suffix string
+		count  int
+	}{
+		{"<s> <s>", "the", 2},
+		{"<s> the", "cat", 2},
+		{"the cat", "sat", 1},
+		{"the cat", "ran", 1},
+		{"cat sat", ".", 1},
+		{"sat .", "</s>", 1},
+		{"ran !", "</s>", 1},
+	}
+
+	data := chain.data.(SetMap)
+	for _, test := range tests {
+		if got := data[test.key][test.suffix]; got != test.count {
+			t.Errorf("count of %q after %q = %v, want %v", test.suffix, test.key, got, test.count)
+		}
+	}
+	if got := chain.Form("the"); got != "the" {
+		t.Errorf("form of the = %q, want the", got)
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	corpus := benchmarkCorpus(1 << 20)
+	for _, name := range []string{WhitespaceTokenizerName, RegexTokenizerName, WordBoundaryTokenizerName} {
+		tokenizer, err := MakeTokenizer(name, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		tokens := len(SentenceTokens(tokenizer, DefaultSegmenter, string(corpus)))
+
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(corpus)))
+			for i := 0; i < b.N; i++ {
+				chain := NewChain(2).WithTokenizer(tokenizer)
+				chain.Build(bytes.NewReader(corpus))
+			}
+			b.ReportMetric(float64(tokens*b.N)/b.Elapsed().Seconds(), "tokens/s")
+		})
+	}
+}
+
+func TestForm(t *testing.T) {
+	chain := NewChain(2)
+	chain.forms = SetMap{
+		"the":  {"the": 3, "The": 1},
+		"nasa": {"NASA": 2, "Nasa": 1},
+		"tie":  {"Tie": 1, "tie": 1},
+	}
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"the", "the"},
+		{"nasa", "NASA"},
+		// ties go to the same form every time
+		{"tie", "tie"},
+		{"unseen", "unseen"},
+		{util.UserMention, "/u/"},
+		{util.SubredditMention, "/r/"},
+	}
+
+	for _, test := range tests {
+		if got := chain.Form(test.word); got != test.want {
+			t.Errorf("Form(%q) = %q, want %q", test.word, got, test.want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	chain := NewChain(2)
+	chain.forms = SetMap{"nasa": {"NASA": 2}, "i": {"I": 5}}
+	tests := []struct {
+		phrase string
+		want   string
+	}{
+		{"nasa launched", "NASA launched"},
+		{"i think , nasa", "I think, NASA"},
+		{"( nasa )", "(NASA)"},
+		{"nasa . </s> more", "NASA."},
+		{"</s>", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := chain.Render(test.phrase); got != test.want {
+			t.Errorf("Render(%q) = %q, want %q", test.phrase, got, test.want)
+		}
+	}
+}
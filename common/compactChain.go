@@ -0,0 +1,446 @@
+package common
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// MaxCompactPrefixLen is the longest prefix a CompactChain can store
+const MaxCompactPrefixLen = 4
+
+// Rough in-memory sizes used to keep a CompactChain within its budget
+const (
+	compactPrefixOverhead = 64 // map entry + counts header for each prefix
+	compactSuffixOverhead = 8  // id + count for each suffix
+	compactWordOverhead   = 40 // map entry + slice entry for each word
+)
+
+// wordID identifies a word interned in a Vocabulary
+type wordID uint32
+
+// Vocabulary interns words as integer ids so each word is stored once
+type Vocabulary struct {
+	words []string
+	ids   map[string]wordID
+}
+
+// NewVocabulary creates an empty vocabulary
+func NewVocabulary() *Vocabulary {
+	return &Vocabulary{ids: make(map[string]wordID)}
+}
+
+// Intern returns the id of word, adding it if it is new
+func (v *Vocabulary) Intern(word string) wordID {
+	if id, ok := v.ids[word]; ok {
+		return id
+	}
+	id := wordID(len(v.words))
+	v.words = append(v.words, word)
+	v.ids[word] = id
+	return id
+}
+
+// ID returns the id of word, and false if it has not been interned
+func (v *Vocabulary) ID(word string) (wordID, bool) {
+	id, ok := v.ids[word]
+	return id, ok
+}
+
+// Word returns the word with the given id
+func (v *Vocabulary) Word(id wordID) string {
+	return v.words[id]
+}
+
+// Len returns the number of words in the vocabulary
+func (v *Vocabulary) Len() int {
+	return len(v.words)
+}
+
+// idTuple is a prefix of word ids. Positions past the chain's prefix
+// length are unused.
+type idTuple [MaxCompactPrefixLen]wordID
+
+// counts counts ids, kept sorted by id so they can be binary searched
+type counts struct {
+	ids    []wordID
+	counts []uint32
+}
+
+// add adds n to the count of id and returns true if id is new
+func (c *counts) add(id wordID, n uint32) bool {
+	i := sort.Search(len(c.ids), func(i int) bool { return c.ids[i] >= id })
+	if i < len(c.ids) && c.ids[i] == id {
+		c.counts[i] += n
+		return false
+	}
+
+	c.ids = append(c.ids, 0)
+	c.counts = append(c.counts, 0)
+	copy(c.ids[i+1:], c.ids[i:])
+	copy(c.counts[i+1:], c.counts[i:])
+	c.ids[i] = id
+	c.counts[i] = n
+	return true
+}
+
+// removeAtMost removes ids counted at most n times and returns how
+// many were removed
+func (c *counts) removeAtMost(n uint32) int {
+	kept := 0
+	for i := range c.ids {
+		if c.counts[i] > n {
+			c.ids[kept] = c.ids[i]
+			c.counts[kept] = c.counts[i]
+			kept++
+		}
+	}
+	removed := len(c.ids) - kept
+	c.ids = c.ids[:kept]
+	c.counts = c.counts[:kept]
+	return removed
+}
+
+// CompactChain is a Chain that interns words, stores prefixes as
+// fixed-width tuples of word ids and stores suffix counts in sorted
+// arrays. It uses a fraction of the memory of Chain, but does not
+// decay its counts.
+//
+// If it has a byte budget, the least common suffixes are dropped while
+// it is built whenever their counts grow past the budget, so counts are
+// lower bounds in the style of lossy counting. The vocabulary and forms
+// are not dropped and do not count against the budget.
+type CompactChain struct {
+	vocab     *Vocabulary
+	prefixLen int
+	data      map[idTuple]*counts
+	forms     map[wordID]*counts
+	tokenizer domain.Tokenizer
+	segmenter domain.Segmenter
+	start     wordID
+	end       wordID
+
+	maxBytes int
+	bytes    int
+	// countBytes is the part of bytes used by suffix counts
+	countBytes int
+	// dropped is the highest count of a suffix dropped to fit the budget
+	dropped uint32
+}
+
+// NewCompactChain creates a chain with prefixes of length prefixLen,
+// holding at most maxBytes of suffix counts. A maxBytes of 0 is unlimited.
+func NewCompactChain(prefixLen, maxBytes int) (*CompactChain, error) {
+	if prefixLen < 1 || prefixLen > MaxCompactPrefixLen {
+		return nil, fmt.Errorf("prefix length must be between 1 and %v", MaxCompactPrefixLen)
+	}
+
+	c := &CompactChain{
+		vocab:     NewVocabulary(),
+		prefixLen: prefixLen,
+		data:      make(map[idTuple]*counts),
+		forms:     make(map[wordID]*counts),
+		tokenizer: DefaultTokenizer,
+		segmenter: DefaultSegmenter,
+		maxBytes:  maxBytes,
+	}
+	c.start = c.intern(util.SentenceStart)
+	c.end = c.intern(util.SentenceEnd)
+	return c, nil
+}
+
+// CompactChainFromSetMap creates a compact chain holding the counts in data
+func CompactChainFromSetMap(data SetMap, prefixLen int) (*CompactChain, error) {
+	c, err := NewCompactChain(prefixLen, 0)
+	if err != nil {
+		return nil, err
+	}
+	for key, set := range data {
+		prefix, ok := c.parseKey(key, true)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a prefix of length %v", key, prefixLen)
+		}
+		for suffix, count := range set {
+			c.addCount(prefix, c.intern(suffix), uint32(count))
+		}
+	}
+	return c, nil
+}
+
+// CompactChainFromChain creates a compact chain with the counts, forms,
+// tokenizer and segmenter of chain. Decayed weights are not kept.
+func CompactChainFromChain(chain Chain) (*CompactChain, error) {
+	c, err := CompactChainFromSetMap(chain.data.(SetMap), chain.prefixLen)
+	if err != nil {
+		return nil, err
+	}
+	for word, forms := range chain.forms {
+		for form, count := range forms {
+			c.addForm(c.intern(word), form, uint32(count))
+		}
+	}
+	c.tokenizer = chain.tokenizer
+	c.segmenter = chain.segmenter
+	return c, nil
+}
+
+// WithSplitters sets how the chain splits text into tokens and sentences
+func (c *CompactChain) WithSplitters(tokenizer domain.Tokenizer,
+	segmenter domain.Segmenter) *CompactChain {
+
+	c.tokenizer = tokenizer
+	c.segmenter = segmenter
+	return c
+}
+
+// ToSetMap converts the chain's counts to a SetMap
+func (c *CompactChain) ToSetMap() SetMap {
+	sm := make(SetMap, len(c.data))
+	for prefix, suffixes := range c.data {
+		sm[c.key(prefix)] = c.toSet(suffixes)
+	}
+	return sm
+}
+
+// ToChain converts the compact chain to a Chain
+func (c *CompactChain) ToChain() Chain {
+	chain := NewChain(c.prefixLen).
+		WithTokenizer(c.tokenizer).
+		WithSegmenter(c.segmenter)
+	chain.data = c.ToSetMap()
+	chain.forms = c.formSetMap()
+	return chain
+}
+
+// Dropped returns the highest count of any suffix dropped to keep the
+// chain within its budget, or 0 if none were
+func (c *CompactChain) Dropped() int {
+	return int(c.dropped)
+}
+
+// EstimateBytes estimates the memory used by the chain
+func (c *CompactChain) EstimateBytes() int {
+	return c.bytes
+}
+
+// GetData returns the chain's counts as a SetMap
+func (c *CompactChain) GetData() domain.SetMap {
+	return c.ToSetMap()
+}
+
+// GetPrefixLen returns the chain's prefix length
+func (c *CompactChain) GetPrefixLen() int {
+	return c.prefixLen
+}
+
+// GetForms returns the number of times each word was written in each case
+func (c *CompactChain) GetForms() map[string]map[string]int {
+	return c.formSetMap().ToPrimitive()
+}
+
+// GetTokenizer returns the tokenizer the chain was built with
+func (c *CompactChain) GetTokenizer() domain.Tokenizer {
+	return c.tokenizer
+}
+
+// GetSegmenter returns the segmenter the chain was built with
+func (c *CompactChain) GetSegmenter() domain.Segmenter {
+	return c.segmenter
+}
+
+// GetWeights returns nil since compact chains do not decay
+func (c *CompactChain) GetWeights() map[string]map[string]float64 {
+	return nil
+}
+
+// GetHalfLife returns 0 since compact chains do not decay
+func (c *CompactChain) GetHalfLife() time.Duration {
+	return 0
+}
+
+// GetEpoch returns the zero time since compact chains do not decay
+func (c *CompactChain) GetEpoch() time.Time {
+	return time.Time{}
+}
+
+// GetHoldout returns nil since compact chains do not record held-out text
+func (c *CompactChain) GetHoldout() *domain.HoldoutDao {
+	return nil
+}
+
+// GetMerge returns nil since compact chains do not record their sources
+func (c *CompactChain) GetMerge() *domain.MergeDao {
+	return nil
+}
+
+// GetReverse returns nil since compact chains have no reverse chain
+func (c *CompactChain) GetReverse() map[string]map[string]int {
+	return nil
+}
+
+// Get returns the suffixes of a key made by Prefix.ToString
+func (c *CompactChain) Get(key string) (domain.Set, bool) {
+	prefix, ok := c.parseKey(key, false)
+	if !ok {
+		return nil, false
+	}
+	suffixes, ok := c.data[prefix]
+	if !ok {
+		return nil, false
+	}
+	return c.toSet(suffixes), true
+}
+
+// Build reads text from r and counts the suffixes of each prefix,
+// the same way as Chain.Build
+func (c *CompactChain) Build(r io.Reader) {
+	br := bufio.NewReader(r)
+	prefix := c.startTuple()
+	empty := true
+	for {
+		text, err := ReadText(br)
+		for _, token := range SentenceTokens(c.tokenizer, c.segmenter, text) {
+			value := util.Clean(token)
+			if value == " " {
+				continue
+			}
+			id := c.intern(value)
+			c.addCount(prefix, id, 1)
+			// words starting a sentence are capitalized because of
+			// their position, so their form is not counted
+			if !empty && util.IsWord(value) {
+				c.addForm(id, util.Strip(token), 1)
+			}
+
+			if id == c.end {
+				prefix, empty = c.startTuple(), true
+			} else {
+				copy(prefix[:c.prefixLen-1], prefix[1:c.prefixLen])
+				prefix[c.prefixLen-1] = id
+				empty = false
+			}
+		}
+		c.fitBudget()
+		if err != nil {
+			break
+		}
+	}
+
+	// the end of the text also ends its last sentence
+	if !empty {
+		c.addCount(prefix, c.end, 1)
+	}
+}
+
+func (c *CompactChain) intern(word string) wordID {
+	if _, ok := c.vocab.ID(word); !ok {
+		c.bytes += compactWordOverhead + len(word)
+	}
+	return c.vocab.Intern(word)
+}
+
+func (c *CompactChain) addCount(prefix idTuple, suffix wordID, n uint32) {
+	suffixes, ok := c.data[prefix]
+	if !ok {
+		suffixes = &counts{}
+		c.data[prefix] = suffixes
+		c.bytes += compactPrefixOverhead
+		c.countBytes += compactPrefixOverhead
+	}
+	if suffixes.add(suffix, n) {
+		c.bytes += compactSuffixOverhead
+		c.countBytes += compactSuffixOverhead
+	}
+}
+
+func (c *CompactChain) addForm(word wordID, form string, n uint32) {
+	forms, ok := c.forms[word]
+	if !ok {
+		forms = &counts{}
+		c.forms[word] = forms
+		c.bytes += compactPrefixOverhead
+	}
+	if forms.add(c.intern(form), n) {
+		c.bytes += compactSuffixOverhead
+	}
+}
+
+// fitBudget drops the least common suffixes until their counts are
+// within the budget. Words are kept, since forms may still refer to them.
+func (c *CompactChain) fitBudget() {
+	for c.maxBytes > 0 && c.countBytes > c.maxBytes && len(c.data) > 0 {
+		c.dropped++
+		for prefix, suffixes := range c.data {
+			freed := suffixes.removeAtMost(c.dropped) * compactSuffixOverhead
+			if len(suffixes.ids) == 0 {
+				delete(c.data, prefix)
+				freed += compactPrefixOverhead
+			}
+			c.bytes -= freed
+			c.countBytes -= freed
+		}
+	}
+}
+
+func (c *CompactChain) startTuple() idTuple {
+	var prefix idTuple
+	for i := 0; i < c.prefixLen; i++ {
+		prefix[i] = c.start
+	}
+	return prefix
+}
+
+// parseKey converts a key made by Prefix.ToString to a tuple, padding
+// it with sentence starts. Unknown words are interned if intern is true.
+func (c *CompactChain) parseKey(key string, intern bool) (idTuple, bool) {
+	words := strings.Fields(key)
+	if len(words) > c.prefixLen {
+		return idTuple{}, false
+	}
+
+	prefix := c.startTuple()
+	offset := c.prefixLen - len(words)
+	for i, word := range words {
+		if intern {
+			prefix[offset+i] = c.intern(word)
+			continue
+		}
+		id, ok := c.vocab.ID(word)
+		if !ok {
+			return idTuple{}, false
+		}
+		prefix[offset+i] = id
+	}
+	return prefix, true
+}
+
+// key converts a tuple to the key Prefix.ToString would make
+func (c *CompactChain) key(prefix idTuple) string {
+	words := make([]string, c.prefixLen)
+	for i := range words {
+		words[i] = c.vocab.Word(prefix[i])
+	}
+	return strings.Join(words, " ")
+}
+
+func (c *CompactChain) toSet(suffixes *counts) Set {
+	set := make(Set, len(suffixes.ids))
+	for i, id := range suffixes.ids {
+		set[c.vocab.Word(id)] = int(suffixes.counts[i])
+	}
+	return set
+}
+
+func (c *CompactChain) formSetMap() SetMap {
+	sm := make(SetMap, len(c.forms))
+	for word, forms := range c.forms {
+		sm[c.vocab.Word(word)] = c.toSet(forms)
+	}
+	return sm
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompactChainBuild(t *testing.T) {
+	corpus := benchmarkCorpus(20000)
+	for _, prefixLen := range []int{1, 2, 3} {
+		chain := NewChain(prefixLen)
+		chain.Build(bytes.NewReader(corpus))
+		compact, err := NewCompactChain(prefixLen, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compact.Build(bytes.NewReader(corpus))
+
+		if !reflect.DeepEqual(compact.ToSetMap(), chain.data.(SetMap)) {
+			t.Errorf("prefix length %v: compact counts differ from Chain.Build", prefixLen)
+		}
+		if !reflect.DeepEqual(compact.formSetMap(), chain.forms) {
+			t.Errorf("prefix length %v: compact forms differ from Chain.Build", prefixLen)
+		}
+	}
+}
+
+func TestCompactChainFromSetMap(t *testing.T) {
+	chain := NewChain(2)
+	chain.Build(strings.NewReader("The cat sat. The dog sat on the mat."))
+	compact, err := CompactChainFromChain(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := compact.ToChain(); !reflect.DeepEqual(got.data, chain.data) || !reflect.DeepEqual(got.forms, chain.forms) {
+		t.Errorf("round trip changed the chain: %v, want %v", got.data, chain.data)
+	}
+
+	set, ok := compact.Get("the cat")
+	if !ok || set.(Set)["sat"] != 1 {
+		t.Errorf("Get(the cat) = %v, %v", set, ok)
+	}
+	if _, ok := compact.Get("unknown words"); ok {
+		t.Errorf("Get found a prefix of unknown words")
+	}
+}
+
+func TestCompactChainBudget(t *testing.T) {
+	corpus := benchmarkCorpus(50000)
+	tests := []struct {
+		maxBytes int
+		dropped  bool
+	}{
+		{0, false},
+		{1 << 30, false},
+		{20000, true},
+		{2000, true},
+	}
+
+	for _, test := range tests {
+		compact, err := NewCompactChain(2, test.maxBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compact.Build(bytes.NewReader(corpus))
+
+		if (compact.Dropped() > 0) != test.dropped {
+			t.Errorf("maxBytes %v: dropped counts up to %v", test.maxBytes, compact.Dropped())
+		}
+		if test.maxBytes > 0 && compact.countBytes > test.maxBytes {
+			t.Errorf("maxBytes %v: counts use %v bytes", test.maxBytes, compact.countBytes)
+		}
+		// the most common suffixes survive however small the budget
+		if len(compact.data) == 0 {
+			t.Errorf("maxBytes %v: every prefix was dropped", test.maxBytes)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+// fakeDB is an in-memory DBClient for tests
+type fakeDB struct {
+	sync.Mutex
+	chains      map[string]domain.UserChainDao
+	predictions map[string]domain.Prediction
+	feedback    []domain.FeedbackDao
+	policies    map[string]domain.SafetyPolicyDao
+
+	// conflicts is how many updates fail because another instance
+	// wrote the chain first
+	conflicts int
+	// updateErr is returned by every other update of a chain
+	updateErr error
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{
+		chains:      make(map[string]domain.UserChainDao),
+		predictions: make(map[string]domain.Prediction),
+		policies:    make(map[string]domain.SafetyPolicyDao),
+	}
+}
+
+// storedChain returns chain as it would be stored for users
+func storedChain(users []string, chain domain.Chain) domain.UserChainDao {
+	dao := chainDao(users, chain)
+	dao.Data = chain.GetData().ToPrimitive()
+	dao.Forms = chain.GetForms()
+	dao.Reverse = chain.GetReverse()
+	return dao
+}
+
+func (db *fakeDB) GetChainByID(id string) (domain.UserChainDao, error) {
+	db.Lock()
+	defer db.Unlock()
+	dao, ok := db.chains[id]
+	if !ok {
+		return domain.UserChainDao{}, fmt.Errorf("no chain %v", id)
+	}
+
+	// each read decodes a new copy of the chain
+	dao.Data, dao.Forms, dao.Reverse = copyCounts(dao.Data), copyCounts(dao.Forms), copyCounts(dao.Reverse)
+	if dao.Weights != nil {
+		weights := make(map[string]map[string]float64, len(dao.Weights))
+		for key, set := range dao.Weights {
+			weights[key] = make(map[string]float64, len(set))
+			for value, weight := range set {
+				weights[key][value] = weight
+			}
+		}
+		dao.Weights = weights
+	}
+	return dao, nil
+}
+
+func copyCounts(counts map[string]map[string]int) map[string]map[string]int {
+	if counts == nil {
+		return nil
+	}
+	copied := make(map[string]map[string]int, len(counts))
+	for key, set := range counts {
+		copied[key] = make(map[string]int, len(set))
+		for value, count := range set {
+			copied[key][value] = count
+		}
+	}
+	return copied
+}
+
+func (db *fakeDB) GetChainInfoByID(id string) (domain.UserChainDao, error) {
+	dao, err := db.GetChainByID(id)
+	dao.Data, dao.Forms, dao.Weights, dao.Holdout, dao.Reverse = nil, nil, nil, nil, nil
+	return dao, err
+}
+
+func (db *fakeDB) HasChain(users []string) (bool, error) {
+	db.Lock()
+	defer db.Unlock()
+	_, ok := db.chains[strings.Join(users, " ")]
+	return ok, nil
+}
+
+// UpsertChain stores the chain with the users as its id
+func (db *fakeDB) UpsertChain(users []string, chain domain.Chain) error {
+	db.Lock()
+	defer db.Unlock()
+	sort.Strings(users)
+	id := strings.Join(users, " ")
+	dao := storedChain(users, chain)
+	dao.Version = db.chains[id].Version + 1
+	db.chains[id] = dao
+	return nil
+}
+
+func (db *fakeDB) UpdateChain(id string, version int64, users []string, chain domain.Chain) error {
+	db.Lock()
+	defer db.Unlock()
+	if db.conflicts > 0 {
+		db.conflicts--
+		stored := db.chains[id]
+		stored.Version++
+		db.chains[id] = stored
+		return domain.ErrChainChanged
+	}
+	if db.updateErr != nil {
+		return db.updateErr
+	}
+	if db.chains[id].Version != version {
+		return domain.ErrChainChanged
+	}
+	dao := storedChain(users, chain)
+	dao.Version = version + 1
+	db.chains[id] = dao
+	return nil
+}
+
+func (db *fakeDB) UpsertExternalChain(users []string, chain domain.Chain,
+	entries domain.ChainEntryReader) error {
+	return errors.New("external chains are not supported")
+}
+
+func (db *fakeDB) GetPrediction(prefix, source string) (domain.Prediction, error) {
+	db.Lock()
+	defer db.Unlock()
+	prediction, ok := db.predictions[source+"|"+prefix]
+	if !ok {
+		return domain.Prediction{}, domain.ErrNoPrediction
+	}
+	return prediction, nil
+}
+
+func (db *fakeDB) UpsertPrediction(prediction domain.Prediction) error {
+	db.Lock()
+	defer db.Unlock()
+	db.predictions[prediction.Source+"|"+prediction.Prefix] = prediction
+	return nil
+}
+
+func (db *fakeDB) InsertFeedback(feedback domain.FeedbackDao) error {
+	db.Lock()
+	defer db.Unlock()
+	feedback.ID = fmt.Sprint(len(db.feedback))
+	db.feedback = append(db.feedback, feedback)
+	return nil
+}
+
+func (db *fakeDB) CountFeedbackByClient(client string, since time.Time) (int64, error) {
+	db.Lock()
+	defer db.Unlock()
+	var count int64
+	for _, f := range db.feedback {
+		if f.Client == client && !f.Created.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (db *fakeDB) GetFeedback(prefix, suggestion string) ([]domain.FeedbackDao, error) {
+	db.Lock()
+	defer db.Unlock()
+	results := make([]domain.FeedbackDao, 0)
+	for _, f := range db.feedback {
+		if f.Prefix == prefix && f.Suggestion == suggestion {
+			results = append(results, f)
+		}
+	}
+	return results, nil
+}
+
+func (db *fakeDB) MarkFeedbackPromoted(ids []string) ([]string, error) {
+	return db.setPromoted(ids, true), nil
+}
+
+func (db *fakeDB) UnmarkFeedbackPromoted(ids []string) error {
+	db.setPromoted(ids, false)
+	return nil
+}
+
+// setPromoted flags the feedback with the given ids and returns the ids
+// whose flag changed
+func (db *fakeDB) setPromoted(ids []string, promoted bool) []string {
+	db.Lock()
+	defer db.Unlock()
+	changed := make([]string, 0)
+	for _, id := range ids {
+		for i := range db.feedback {
+			if db.feedback[i].ID == id && db.feedback[i].Promoted != promoted {
+				db.feedback[i].Promoted = promoted
+				changed = append(changed, id)
+			}
+		}
+	}
+	return changed
+}
+
+func (db *fakeDB) GetSafetyPolicy(chainID string) (domain.SafetyPolicyDao, bool, error) {
+	db.Lock()
+	defer db.Unlock()
+	policy, ok := db.policies[chainID]
+	return policy, ok, nil
+}
+
+func (db *fakeDB) UpsertSafetyPolicy(policy domain.SafetyPolicyDao) error {
+	db.Lock()
+	defer db.Unlock()
+	db.policies[policy.ChainID] = policy
+	return nil
+}
@@ -0,0 +1,109 @@
+package common
+
+import (
+	"math"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// Bounds on the scale that converts decayed weights to the integer values
+// used for ranking
+const (
+	// decayScale is the smallest scale, ranking weights in at least
+	// thousandths of a count
+	decayScale = 1000
+	// maxRankCount is the largest value a weight is scaled to, leaving
+	// room to sum many of them without overflowing
+	maxRankCount = 1 << 40
+)
+
+// decayFactor returns the weight at epoch of one observation made at t,
+// halving for every halfLife that t is older than epoch. Observations
+// made after epoch count as if made at epoch, so a weight is never more
+// than its count.
+func decayFactor(t, epoch time.Time, halfLife time.Duration) float64 {
+	if halfLife <= 0 || t.IsZero() || !t.Before(epoch) {
+		return 1
+	}
+	return math.Exp2(t.Sub(epoch).Hours() / halfLife.Hours())
+}
+
+// WeightSet maps strings to their decayed weight
+type WeightSet map[string]float64
+
+// WeightMap is a map from a key to a WeightSet
+type WeightMap map[string]WeightSet
+
+// MakeWeightMap converts a primitive map into a weight map
+func MakeWeightMap(data map[string]map[string]float64) WeightMap {
+	newWeightMap := make(WeightMap)
+	for key, value := range data {
+		newWeightMap[key] = value
+	}
+
+	return newWeightMap
+}
+
+// Add adds weight to value in the set associated with key
+func (wm WeightMap) Add(key, value string, weight float64) {
+	if _, ok := wm[key]; !ok {
+		wm[key] = make(WeightSet)
+	}
+	wm[key][value] += weight
+}
+
+// Union merges other into wm, multiplying its weights by scale
+func (wm WeightMap) Union(other WeightMap, scale float64) {
+	for key, set := range other {
+		for value, weight := range set {
+			wm.Add(key, value, weight*scale)
+		}
+	}
+}
+
+// ToPrimitive returns the WeightMap as a primitive map type w/ no methods
+func (wm WeightMap) ToPrimitive() map[string]map[string]float64 {
+	primitiveMap := make(map[string]map[string]float64)
+	for key, value := range wm {
+		primitiveMap[key] = value
+	}
+
+	return primitiveMap
+}
+
+// rankScale returns the scale that converts the weights to integer counts
+// for ranking. It is large enough for the smallest weight to be a count
+// of at least 1, as long as the largest weight stays at most maxRankCount.
+func (wm WeightMap) rankScale() float64 {
+	min, max := math.Inf(1), 0.0
+	for _, set := range wm {
+		for _, weight := range set {
+			if weight > 0 {
+				min = math.Min(min, weight)
+				max = math.Max(max, weight)
+			}
+		}
+	}
+	if max == 0 {
+		return decayScale
+	}
+	return math.Min(math.Max(1/min, decayScale), maxRankCount/max)
+}
+
+// ToSetMap converts the weights to integer counts for ranking, scaled by
+// rankScale. Weights too small to round to a count are kept with a count
+// of 1.
+func (wm WeightMap) ToSetMap() SetMap {
+	scale := wm.rankScale()
+	setMap := make(SetMap)
+	for key, set := range wm {
+		counts := make(Set)
+		for value, weight := range set {
+			counts[value] = util.MaxInt(int(math.Round(weight*scale)), 1)
+		}
+		setMap[key] = counts
+	}
+
+	return setMap
+}
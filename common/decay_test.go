@@ -0,0 +1,100 @@
+package common
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDecayFactor(t *testing.T) {
+	epoch := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		name     string
+		t        time.Time
+		halfLife time.Duration
+		want     float64
+	}{
+		{"at epoch", epoch, day, 1},
+		{"one half-life old", epoch.Add(-day), day, 0.5},
+		{"three half-lives old", epoch.Add(-3 * day), day, 0.125},
+		{"half a half-life old", epoch.Add(-day / 2), day, math.Sqrt(0.5)},
+		{"after epoch", epoch.Add(1000 * day), day, 1},
+		{"no decay", epoch.Add(-day), 0, 1},
+		{"no time", time.Time{}, day, 1},
+	}
+
+	for _, test := range tests {
+		got := decayFactor(test.t, epoch, test.halfLife)
+		if math.Abs(got-test.want) > 1e-12 {
+			t.Errorf("%v: decayFactor() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestWeightMapToSetMap(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights WeightMap
+		// order lists the suffixes of "a" from highest count to lowest
+		order []string
+	}{
+		{
+			name:    "recent text",
+			weights: WeightMap{"a": {"x": 3, "y": 1.5, "z": 0.25}},
+			order:   []string{"x", "y", "z"},
+		},
+		{
+			name:    "old text keeps its order",
+			weights: WeightMap{"a": {"x": 1e-6, "y": 5e-7, "z": 1e-7}},
+			order:   []string{"x", "y", "z"},
+		},
+		{
+			name:    "old text below recent text",
+			weights: WeightMap{"a": {"x": 2, "y": math.Exp2(-30), "z": math.Exp2(-35)}},
+			order:   []string{"x", "y", "z"},
+		},
+		{
+			name:    "large weights",
+			weights: WeightMap{"a": {"x": 1e15, "y": 1e14, "z": 1}},
+			order:   []string{"x", "y", "z"},
+		},
+	}
+
+	for _, test := range tests {
+		counts := test.weights.ToSetMap()["a"]
+		for i := 1; i < len(test.order); i++ {
+			higher, lower := counts[test.order[i-1]], counts[test.order[i]]
+			if higher <= lower {
+				t.Errorf("%v: count of %v is %v, not above count of %v, %v",
+					test.name, test.order[i-1], higher, test.order[i], lower)
+			}
+		}
+		for value, count := range counts {
+			if count < 1 || count > maxRankCount {
+				t.Errorf("%v: count of %v is %v", test.name, value, count)
+			}
+		}
+	}
+}
+
+func TestDecayChainRanksRecentText(t *testing.T) {
+	epoch := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	chain := NewDecayChain(1, 24*time.Hour, epoch)
+	// "old" is seen more often but long ago, and the feedback from after
+	// the epoch must not outweigh everything else
+	for i := 0; i < 10; i++ {
+		chain.AddAt("a", "old", epoch.Add(-100*24*time.Hour))
+	}
+	chain.AddAt("a", "new", epoch.Add(-time.Hour))
+	chain.AddAt("a", "newer", epoch.Add(10000*24*time.Hour))
+
+	weights := chain.weights["a"]
+	if weights["newer"] > 1 {
+		t.Errorf("weight after epoch = %v, want at most 1", weights["newer"])
+	}
+	counts := chain.rankData()["a"]
+	if counts["new"] <= counts["old"] {
+		t.Errorf("recent count %v not above old count %v", counts["new"], counts["old"])
+	}
+}
@@ -0,0 +1,170 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// Ways of ranking shifted suffix distributions
+const (
+	ShiftByDivergence = "kl"
+	ShiftByDelta      = "delta"
+)
+
+// diffSmoothing is added to every suffix count when measuring divergence,
+// so suffixes missing from one chain do not make it infinite
+const diffSmoothing = 0.5
+
+// ChainDiff is what changed from one chain to another
+type ChainDiff struct {
+	PrefixesShared int `json:"prefixesShared"`
+	// PrefixesAdded and PrefixesRemoved count every prefix only in the
+	// newer or older chain, while Added and Removed list the most common
+	PrefixesAdded   int                `json:"prefixesAdded"`
+	PrefixesRemoved int                `json:"prefixesRemoved"`
+	Added           []domain.CountStat `json:"added"`
+	Removed         []domain.CountStat `json:"removed"`
+	Shifted         []SuffixShift      `json:"shifted"`
+	NewWords        []domain.CountStat `json:"newWords"`
+	LostWords       []domain.CountStat `json:"lostWords"`
+}
+
+// SuffixShift is how the suffixes of a prefix in both chains changed
+type SuffixShift struct {
+	Key    string `json:"key"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+	// Divergence is the KL divergence of the new suffixes from the old
+	// in bits, and Delta the total change in suffix counts
+	Divergence float64  `json:"divergence"`
+	Delta      int      `json:"delta"`
+	TopBefore  []string `json:"topBefore"`
+	TopAfter   []string `json:"topAfter"`
+}
+
+// DiffOptions configures a diff
+type DiffOptions struct {
+	// Top is the number of prefixes and words to list of each kind
+	Top int
+	// By is ShiftByDivergence or ShiftByDelta
+	By string
+	// MinCount is the fewest times a prefix must be seen in both chains
+	// for its shift to be listed, since rare prefixes shift by chance
+	MinCount int
+}
+
+// DiffChains finds what changed from chain a to chain b. The chains must
+// have the same prefix length, tokenizer and segmenter, or their prefixes
+// would differ however similar their text.
+func DiffChains(a, b Chain, opts DiffOptions) (ChainDiff, error) {
+	if differ := splitDifference(a, b); differ != "" {
+		return ChainDiff{}, fmt.Errorf("chains with different %v cannot be compared", differ)
+	}
+	if opts.By == "" {
+		opts.By = ShiftByDivergence
+	}
+	if opts.By != ShiftByDivergence && opts.By != ShiftByDelta {
+		return ChainDiff{}, errors.New(opts.By + " is not a way of ranking shifts")
+	}
+
+	before, after := a.data.(SetMap), b.data.(SetMap)
+	var diff ChainDiff
+	added, removed := make(Set), make(Set)
+	shifts := make([]SuffixShift, 0)
+	for key, set := range after {
+		old, ok := before[key]
+		if !ok {
+			added[key] = set.Total()
+			continue
+		}
+
+		diff.PrefixesShared++
+		shift := diffSets(old, set)
+		shift.Key = key
+		if shift.Before >= opts.MinCount && shift.After >= opts.MinCount && shift.Delta > 0 {
+			shifts = append(shifts, shift)
+		}
+	}
+	for key, set := range before {
+		if _, ok := after[key]; !ok {
+			removed[key] = set.Total()
+		}
+	}
+
+	sort.Slice(shifts, func(i, j int) bool {
+		if opts.By == ShiftByDelta && shifts[i].Delta != shifts[j].Delta {
+			return shifts[i].Delta > shifts[j].Delta
+		}
+		if shifts[i].Divergence != shifts[j].Divergence {
+			return shifts[i].Divergence > shifts[j].Divergence
+		}
+		return shifts[i].Key < shifts[j].Key
+	})
+	diff.Shifted = shifts[:util.MinInt(opts.Top, len(shifts))]
+
+	diff.PrefixesAdded, diff.PrefixesRemoved = len(added), len(removed)
+	diff.Added = topCounts(added, opts.Top)
+	diff.Removed = topCounts(removed, opts.Top)
+
+	wordsBefore, wordsAfter := before.words(), after.words()
+	newWords, lostWords := make(Set), make(Set)
+	for word, count := range wordsAfter {
+		if _, ok := wordsBefore[word]; !ok {
+			newWords[word] = count
+		}
+	}
+	for word, count := range wordsBefore {
+		if _, ok := wordsAfter[word]; !ok {
+			lostWords[word] = count
+		}
+	}
+	diff.NewWords = topCounts(newWords, opts.Top)
+	diff.LostWords = topCounts(lostWords, opts.Top)
+
+	return diff, nil
+}
+
+// diffSets measures how the suffixes of a prefix changed
+func diffSets(before, after Set) SuffixShift {
+	shift := SuffixShift{
+		Before:    before.Total(),
+		After:     after.Total(),
+		TopBefore: before.top(predictionBreadth),
+		TopAfter:  after.top(predictionBreadth),
+	}
+
+	suffixes := make(map[string]bool)
+	for suffix := range before {
+		suffixes[suffix] = true
+	}
+	for suffix := range after {
+		suffixes[suffix] = true
+	}
+
+	smoothing := diffSmoothing * float64(len(suffixes))
+	for suffix := range suffixes {
+		shift.Delta += util.AbsInt(after[suffix] - before[suffix])
+		p := (float64(after[suffix]) + diffSmoothing) / (float64(shift.After) + smoothing)
+		q := (float64(before[suffix]) + diffSmoothing) / (float64(shift.Before) + smoothing)
+		shift.Divergence += p * math.Log2(p/q)
+	}
+	return shift
+}
+
+// words counts every suffix of the SetMap except the end of a sentence
+func (sm SetMap) words() Set {
+	words := make(Set)
+	for _, set := range sm {
+		for suffix, count := range set {
+			if suffix != util.SentenceEnd {
+				words[suffix] += count
+			}
+		}
+	}
+	return words
+}
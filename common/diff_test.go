@@ -0,0 +1,132 @@
+package common
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+func TestDiffChains(t *testing.T) {
+	a, b := NewChain(1), NewChain(1)
+	a.data = SetMap{
+		"x":    {"p": 3, "q": 1},
+		"y":    {"m": 100, "k": 100},
+		"same": {"s": 4},
+		"old":  {"z": 2},
+	}
+	b.data = SetMap{
+		"x":    {"p": 1, "q": 3, "r": 2},
+		"y":    {"m": 110, "k": 90},
+		"same": {"s": 4},
+		"new":  {"n": 5},
+	}
+
+	// the suffixes of x shift by 6 and of y by 20, but x is the larger
+	// change in proportion
+	xShift := SuffixShift{
+		Key: "x", Before: 4, After: 6, Delta: 6,
+		Divergence: 1.5/7.5*math.Log2((1.5/7.5)/(3.5/5.5)) +
+			3.5/7.5*math.Log2((3.5/7.5)/(1.5/5.5)) +
+			2.5/7.5*math.Log2((2.5/7.5)/(0.5/5.5)),
+		TopBefore: []string{"p", "q"},
+		TopAfter:  []string{"q", "r", "p"},
+	}
+	yShift := SuffixShift{
+		Key: "y", Before: 200, After: 200, Delta: 20,
+		Divergence: 110.5/201*math.Log2(110.5/100.5) + 90.5/201*math.Log2(90.5/100.5),
+		TopBefore:  []string{"k", "m"},
+		TopAfter:   []string{"m", "k"},
+	}
+	tests := []struct {
+		name    string
+		opts    DiffOptions
+		shifted []SuffixShift
+	}{
+		{"divergence", DiffOptions{Top: 10}, []SuffixShift{xShift, yShift}},
+		{"delta", DiffOptions{Top: 10, By: ShiftByDelta}, []SuffixShift{yShift, xShift}},
+		{"top", DiffOptions{Top: 1}, []SuffixShift{xShift}},
+		{"min count", DiffOptions{Top: 10, MinCount: 5}, []SuffixShift{yShift}},
+	}
+
+	for _, test := range tests {
+		got, err := DiffChains(a, b, test.opts)
+		if err != nil {
+			t.Errorf("%v: DiffChains() error = %v", test.name, err)
+			continue
+		}
+		if got.PrefixesShared != 3 || got.PrefixesAdded != 1 || got.PrefixesRemoved != 1 {
+			t.Errorf("%v: DiffChains() found %v shared, %v added and %v removed prefixes, want 3, 1 and 1",
+				test.name, got.PrefixesShared, got.PrefixesAdded, got.PrefixesRemoved)
+		}
+		if want := []domain.CountStat{{Key: "new", Count: 5}}; !reflect.DeepEqual(got.Added, want) {
+			t.Errorf("%v: DiffChains() added %v, want %v", test.name, got.Added, want)
+		}
+		if want := []domain.CountStat{{Key: "old", Count: 2}}; !reflect.DeepEqual(got.Removed, want) {
+			t.Errorf("%v: DiffChains() removed %v, want %v", test.name, got.Removed, want)
+		}
+
+		if len(got.Shifted) != len(test.shifted) {
+			t.Errorf("%v: DiffChains() shifted %v, want %v", test.name, got.Shifted, test.shifted)
+			continue
+		}
+		for i, shift := range got.Shifted {
+			want := test.shifted[i]
+			if math.Abs(shift.Divergence-want.Divergence) > 1e-9 {
+				t.Errorf("%v: divergence of %q = %v, want %v", test.name, shift.Key, shift.Divergence, want.Divergence)
+			}
+			shift.Divergence = want.Divergence
+			if !reflect.DeepEqual(shift, want) {
+				t.Errorf("%v: shift %v = %+v, want %+v", test.name, i, shift, want)
+			}
+		}
+	}
+}
+
+func TestDiffChainsWords(t *testing.T) {
+	a, b := NewChain(1), NewChain(1)
+	a.data = SetMap{"x": {"p": 3, "z": 2}, "y": {"z": 1}}
+	b.data = SetMap{"x": {"p": 1, "n": 5, "r": 2}}
+
+	got, err := DiffChains(a, b, DiffOptions{Top: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []domain.CountStat{{Key: "n", Count: 5}, {Key: "r", Count: 2}}; !reflect.DeepEqual(got.NewWords, want) {
+		t.Errorf("DiffChains() new words = %v, want %v", got.NewWords, want)
+	}
+	if want := []domain.CountStat{{Key: "z", Count: 3}}; !reflect.DeepEqual(got.LostWords, want) {
+		t.Errorf("DiffChains() lost words = %v, want %v", got.LostWords, want)
+	}
+}
+
+func TestDiffChainsMismatch(t *testing.T) {
+	french, err := NewSegmenter("fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	regex, err := MakeTokenizer(RegexTokenizerName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		other Chain
+	}{
+		{"prefix length", NewChain(2)},
+		{"tokenizer", NewChain(1).WithTokenizer(regex)},
+		{"segmenter language", NewChain(1).WithSegmenter(french)},
+		{"learned abbreviations", NewChain(1).WithLearnedAbbreviations([]string{"approx"})},
+	}
+
+	for _, test := range tests {
+		if _, err := DiffChains(NewChain(1), test.other, DiffOptions{Top: 10}); err == nil {
+			t.Errorf("%v: DiffChains() compared chains with a different %v", test.name, test.name)
+		}
+	}
+	if _, err := DiffChains(NewChain(1), NewChain(1), DiffOptions{By: "size"}); err == nil {
+		t.Errorf("DiffChains() ranked shifts by an unknown method")
+	}
+}
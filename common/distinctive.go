@@ -0,0 +1,176 @@
+package common
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// Ways of scoring distinctive phrases
+const (
+	// DistinctByLLR scores phrases by Dunning's log-likelihood ratio,
+	// which favours phrases that are both common and over-represented
+	DistinctByLLR = "llr"
+	// DistinctByTFIDF scores phrases by their frequency in the chain times
+	// the log of their inverse frequency in the background
+	DistinctByTFIDF = "tfidf"
+)
+
+// DistinctOptions configures a search for distinctive phrases
+type DistinctOptions struct {
+	// Top is the number of words and of phrases to return
+	Top int
+	// By is DistinctByLLR or DistinctByTFIDF
+	By string
+	// MinCount is the fewest times a phrase must be in the chain
+	MinCount int
+	// Exclude subtracts the chain's counts from the background's, for
+	// backgrounds built from text that includes the chain's
+	Exclude bool
+}
+
+// DistinctivePhrases finds the words and phrases that chain uses more
+// often than background. Phrases are the n-grams of words up to the
+// prefix length plus one, without punctuation or sentence boundaries.
+func DistinctivePhrases(chain, background Chain, opts DistinctOptions) (domain.DistinctivePhrases, error) {
+	if opts.By == "" {
+		opts.By = DistinctByLLR
+	}
+	if opts.By != DistinctByLLR && opts.By != DistinctByTFIDF {
+		return domain.DistinctivePhrases{}, errors.New(opts.By + " is not a way of scoring phrases")
+	}
+
+	result := domain.DistinctivePhrases{
+		Method:  opts.By,
+		Words:   make([]domain.DistinctivePhrase, 0),
+		Phrases: make([]domain.DistinctivePhrase, 0),
+	}
+	orders, backgroundOrders := chain.ngrams(), background.ngrams()
+	for i := 0; i < len(orders) && i < len(backgroundOrders); i++ {
+		ngrams, backgroundNgrams := phraseCounts(orders[i]), phraseCounts(backgroundOrders[i])
+		if opts.Exclude {
+			for ngram, count := range ngrams {
+				backgroundNgrams[ngram] = util.MaxInt(backgroundNgrams[ngram]-count, 0)
+			}
+		}
+		total, backgroundTotal := ngrams.Total(), backgroundNgrams.Total()
+
+		for ngram, count := range ngrams {
+			backgroundCount := backgroundNgrams[ngram]
+			if count < opts.MinCount ||
+				count*backgroundTotal <= backgroundCount*total {
+				continue
+			}
+
+			phrase := domain.DistinctivePhrase{
+				Phrase:          ngram,
+				Count:           count,
+				BackgroundCount: backgroundCount,
+			}
+			if opts.By == DistinctByLLR {
+				phrase.Score = logLikelihood(count, backgroundCount, total, backgroundTotal)
+			} else {
+				phrase.Score = float64(count) / float64(total) *
+					math.Log(float64(backgroundTotal+1)/float64(backgroundCount+1))
+			}
+			if i == 0 {
+				result.Words = append(result.Words, phrase)
+			} else {
+				result.Phrases = append(result.Phrases, phrase)
+			}
+		}
+	}
+
+	result.Words = topPhrases(result.Words, opts.Top)
+	result.Phrases = topPhrases(result.Phrases, opts.Top)
+	return result, nil
+}
+
+// phraseCounts returns the counts of n-grams made only of words
+func phraseCounts(ngrams Set) Set {
+	phrases := make(Set, len(ngrams))
+	for ngram, count := range ngrams {
+		isPhrase := true
+		for _, word := range strings.Fields(ngram) {
+			if !util.IsWord(word) {
+				isPhrase = false
+				break
+			}
+		}
+		if isPhrase {
+			phrases[ngram] = count
+		}
+	}
+	return phrases
+}
+
+// logLikelihood returns Dunning's log-likelihood ratio for a phrase seen
+// a times in a total of c phrases and b times in a total of d
+func logLikelihood(a, b, c, d int) float64 {
+	expectedA := float64(c) * float64(a+b) / float64(c+d)
+	expectedB := float64(d) * float64(a+b) / float64(c+d)
+	llr := 0.0
+	if a > 0 {
+		llr += float64(a) * math.Log(float64(a)/expectedA)
+	}
+	if b > 0 {
+		llr += float64(b) * math.Log(float64(b)/expectedB)
+	}
+	return 2 * llr
+}
+
+// topPhrases sorts phrases by score and returns the first n
+func topPhrases(phrases []domain.DistinctivePhrase, n int) []domain.DistinctivePhrase {
+	sort.Slice(phrases, func(i, j int) bool {
+		if phrases[i].Score != phrases[j].Score {
+			return phrases[i].Score > phrases[j].Score
+		}
+		return phrases[i].Phrase < phrases[j].Phrase
+	})
+	return phrases[:util.MinInt(n, len(phrases))]
+}
+
+// GetDistinctivePhrases returns the words and phrases of a stored chain
+// that are most over-represented compared with a background chain. If
+// the background was built from every user of the chain and more, the
+// chain's counts are left out of it.
+func (svc PredictionSvc) GetDistinctivePhrases(chainID, backgroundID, method string,
+	top, minCount int) (domain.DistinctivePhrases, error) {
+
+	dao, err := svc.DB.GetChainByID(chainID)
+	if err != nil {
+		return domain.DistinctivePhrases{}, err
+	}
+	backgroundDao, err := svc.DB.GetChainByID(backgroundID)
+	if err != nil {
+		return domain.DistinctivePhrases{}, err
+	}
+
+	backgroundUsers := make(map[string]bool)
+	for _, user := range backgroundDao.Users {
+		backgroundUsers[user] = true
+	}
+	exclude := len(backgroundDao.Users) > len(dao.Users)
+	for _, user := range dao.Users {
+		exclude = exclude && backgroundUsers[user]
+	}
+
+	chain, err := ChainFromDao(dao)
+	if err != nil {
+		return domain.DistinctivePhrases{}, err
+	}
+	background, err := ChainFromDao(backgroundDao)
+	if err != nil {
+		return domain.DistinctivePhrases{}, err
+	}
+	return DistinctivePhrases(chain, background, DistinctOptions{
+		Top:      top,
+		By:       method,
+		MinCount: minCount,
+		Exclude:  exclude,
+	})
+}
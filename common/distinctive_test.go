@@ -0,0 +1,86 @@
+package common
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+func TestLogLikelihood(t *testing.T) {
+	tests := []struct {
+		name       string
+		a, b, c, d int
+		want       float64
+	}{
+		{"same rate", 5, 5, 100, 100, 0},
+		{"same rate in different totals", 2, 6, 50, 150, 0},
+		{"only in the chain", 10, 0, 100, 100, 20 * math.Ln2},
+		{"over-represented", 1, 1, 1, 3, 2 * math.Log(4.0/3)},
+		{"under-represented", 1, 3, 2, 2, 2 * (math.Log(0.5) + 3*math.Log(1.5))},
+	}
+
+	for _, test := range tests {
+		got := logLikelihood(test.a, test.b, test.c, test.d)
+		if math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%v: logLikelihood() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLogLikelihoodOrder(t *testing.T) {
+	// more evidence of the same over-representation scores higher
+	rare := logLikelihood(2, 1, 100, 100)
+	frequent := logLikelihood(20, 10, 100, 100)
+	if frequent <= rare {
+		t.Errorf("logLikelihood() of a common phrase = %v, want more than %v", frequent, rare)
+	}
+}
+
+func TestDistinctivePhrases(t *testing.T) {
+	chain := NewChain(1)
+	chain.Build(strings.NewReader("The cat sat. The cat sat. The dog ran."))
+	background := NewChain(1)
+	background.Build(strings.NewReader("The dog ran. The dog ran. The cat sat. The bird flew."))
+
+	tests := []struct {
+		name    string
+		opts    DistinctOptions
+		words   []string
+		phrases []string
+	}{
+		{"llr", DistinctOptions{Top: 10}, []string{"cat", "sat"}, []string{"cat sat", "the cat"}},
+		{"tfidf", DistinctOptions{Top: 10, By: DistinctByTFIDF}, []string{"cat", "sat"}, []string{"cat sat", "the cat"}},
+		{"top", DistinctOptions{Top: 1}, []string{"cat"}, []string{"cat sat"}},
+		{"min count", DistinctOptions{Top: 10, MinCount: 3}, []string{}, []string{}},
+		{"exclude", DistinctOptions{Top: 10, Exclude: true}, []string{"cat", "sat", "the"}, []string{"cat sat", "the cat"}},
+	}
+
+	for _, test := range tests {
+		got, err := DistinctivePhrases(chain, background, test.opts)
+		if err != nil {
+			t.Errorf("%v: DistinctivePhrases() error = %v", test.name, err)
+			continue
+		}
+		if words := phraseNames(got.Words); !reflect.DeepEqual(words, test.words) {
+			t.Errorf("%v: words = %v, want %v", test.name, words, test.words)
+		}
+		if phrases := phraseNames(got.Phrases); !reflect.DeepEqual(phrases, test.phrases) {
+			t.Errorf("%v: phrases = %v, want %v", test.name, phrases, test.phrases)
+		}
+	}
+
+	if _, err := DistinctivePhrases(chain, background, DistinctOptions{By: "count"}); err == nil {
+		t.Error("DistinctivePhrases() accepted an unknown way of scoring")
+	}
+}
+
+func phraseNames(phrases []domain.DistinctivePhrase) []string {
+	names := make([]string, len(phrases))
+	for i, phrase := range phrases {
+		names[i] = phrase.Phrase
+	}
+	return names
+}
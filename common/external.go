@@ -0,0 +1,472 @@
+package common
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// maxMergeRuns is the most runs merged at once, which bounds the number
+// of files open while merging
+const maxMergeRuns = 64
+
+// Kinds of run record, in the order they are sorted
+const (
+	recordData byte = iota
+	recordForm
+)
+
+// ExternalBuilder builds a chain too large to hold in memory. Counts are
+// kept in memory until they reach a memory limit, then sorted and
+// spilled to a run file. The runs are merged into the final counts,
+// which are read in order as chain entries.
+type ExternalBuilder struct {
+	chain Chain
+	limit int
+	dir   string
+	runs  []string
+	// merges counts the runs made by merging other runs
+	merges int
+	bytes  int
+	// MinCount drops suffixes counted fewer times from the merged counts
+	MinCount int
+}
+
+// NewExternalBuilder creates a builder for chains built the same way as
+// chain, keeping at most memoryLimit bytes of counts in memory and
+// spilling to a temporary directory in dir. Chains with decayed weights
+// or a reverse chain cannot be built externally.
+func NewExternalBuilder(chain Chain, memoryLimit int, dir string) (*ExternalBuilder, error) {
+	if chain.weights != nil {
+		return nil, errors.New("chains with a half-life cannot be built externally")
+	}
+	if chain.reverse != nil {
+		return nil, errors.New("chains with a reverse chain cannot be built externally")
+	}
+	if memoryLimit <= 0 {
+		return nil, errors.New("memory limit must be greater than 0")
+	}
+
+	tempDir, err := os.MkdirTemp(dir, "predtext-build-")
+	if err != nil {
+		return nil, err
+	}
+	return &ExternalBuilder{chain: chain.Empty(), limit: memoryLimit, dir: tempDir}, nil
+}
+
+// Chain returns an empty chain with the builder's settings
+func (b *ExternalBuilder) Chain() Chain {
+	return b.chain.Empty()
+}
+
+// Build reads text from r the same way as Chain.BuildAt, spilling the
+// counts to disk whenever they reach the memory limit. Text is read at
+// most about maxReadBytes at a time, so the limit is checked often
+// however long its lines are.
+func (b *ExternalBuilder) Build(r io.Reader) error {
+	br := bufio.NewReader(r)
+	p := NewPrefix(b.chain.prefixLen)
+	for {
+		text, err := ReadText(br)
+		for _, token := range SentenceTokens(b.chain.tokenizer, b.chain.segmenter, text) {
+			// words starting a sentence are capitalized because of
+			// their position, so their form is not counted
+			b.add(p.ToString(), token, !p.IsEmpty())
+			p.Shift(token)
+		}
+		if b.bytes >= b.limit {
+			if spillErr := b.spill(); spillErr != nil {
+				return spillErr
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// the end of the text also ends its last sentence
+	if !p.IsEmpty() {
+		b.add(p.ToString(), util.SentenceEnd, false)
+	}
+	return nil
+}
+
+// add is Chain.add, also estimating the memory used by new counts
+func (b *ExternalBuilder) add(key, word string, countForm bool) {
+	value := util.Clean(word)
+	if value == " " {
+		return
+	}
+
+	b.bytes += addEstimate(b.chain.data.(SetMap), key, value)
+	if countForm && util.IsWord(value) {
+		b.bytes += addEstimate(b.chain.forms, value, util.Strip(word))
+	}
+}
+
+// addEstimate adds value to the set of key and returns the bytes
+// the set map grew by
+func addEstimate(sm SetMap, key, value string) int {
+	bytes := 0
+	set, ok := sm[key]
+	if !ok {
+		bytes += prefixOverhead + len(key)
+	}
+	before := len(set)
+	sm.Add(key, value)
+	if len(sm[key]) > before {
+		bytes += suffixOverhead + len(value)
+	}
+	return bytes
+}
+
+// spill writes the counts in memory to a new sorted run and clears them
+func (b *ExternalBuilder) spill() error {
+	if b.bytes == 0 {
+		return nil
+	}
+
+	path := filepath.Join(b.dir, fmt.Sprintf("run-%v", len(b.runs)))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	writeSorted(w, recordData, b.chain.data.(SetMap))
+	writeSorted(w, recordForm, b.chain.forms)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	b.runs = append(b.runs, path)
+	b.chain = b.chain.Empty()
+	b.bytes = 0
+	return nil
+}
+
+// writeSorted writes the counts of sm as records sorted by key and value
+func writeSorted(w *bufio.Writer, kind byte, sm SetMap) {
+	keys := make([]string, 0, len(sm))
+	for key := range sm {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		values := make([]string, 0, len(sm[key]))
+		for value := range sm[key] {
+			values = append(values, value)
+		}
+		sort.Strings(values)
+		for _, value := range values {
+			writeRecord(w, runRecord{kind, key, value, sm[key][value]})
+		}
+	}
+}
+
+// runRecord is the count of one suffix or form in a run
+type runRecord struct {
+	kind  byte
+	key   string
+	value string
+	count int
+}
+
+func (r runRecord) less(other runRecord) bool {
+	if r.kind != other.kind {
+		return r.kind < other.kind
+	}
+	if r.key != other.key {
+		return r.key < other.key
+	}
+	return r.value < other.value
+}
+
+// writeRecord writes a record as its kind, then the length and bytes of
+// its key and value, then its count
+func writeRecord(w *bufio.Writer, r runRecord) {
+	var buf [binary.MaxVarintLen64]byte
+	w.WriteByte(r.kind)
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(r.key)))])
+	w.WriteString(r.key)
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(len(r.value)))])
+	w.WriteString(r.value)
+	w.Write(buf[:binary.PutUvarint(buf[:], uint64(r.count))])
+}
+
+// runReader reads the records of a run in order
+type runReader struct {
+	f       *os.File
+	r       *bufio.Reader
+	current runRecord
+}
+
+func openRun(path string) (*runReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &runReader{f: f, r: bufio.NewReader(f)}, nil
+}
+
+// next reads the next record into current
+func (rr *runReader) next() error {
+	kind, err := rr.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	key, err := rr.readString()
+	if err != nil {
+		return unexpected(err)
+	}
+	value, err := rr.readString()
+	if err != nil {
+		return unexpected(err)
+	}
+	count, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return unexpected(err)
+	}
+	rr.current = runRecord{kind, key, value, int(count)}
+	return nil
+}
+
+func (rr *runReader) readString() (string, error) {
+	n, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(rr.r, buf)
+	return string(buf), err
+}
+
+// unexpected reports a run that ends part way through a record
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// runHeap orders runs by their current record
+type runHeap []*runReader
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Less(i, j int) bool  { return h[i].current.less(h[j].current) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	rr := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return rr
+}
+
+// runMerger reads the records of several runs in order, summing the
+// counts of records with the same kind, key and value
+type runMerger struct {
+	runs runHeap
+}
+
+func newRunMerger(paths []string) (*runMerger, error) {
+	m := &runMerger{runs: make(runHeap, 0, len(paths))}
+	for _, path := range paths {
+		rr, err := openRun(path)
+		if err != nil {
+			m.close()
+			return nil, err
+		}
+		if err := rr.next(); err == io.EOF {
+			rr.f.Close()
+			continue
+		} else if err != nil {
+			rr.f.Close()
+			m.close()
+			return nil, err
+		}
+		m.runs = append(m.runs, rr)
+	}
+	heap.Init(&m.runs)
+	return m, nil
+}
+
+// next returns the next merged record, or io.EOF after the last
+func (m *runMerger) next() (runRecord, error) {
+	if len(m.runs) == 0 {
+		return runRecord{}, io.EOF
+	}
+
+	merged := m.runs[0].current
+	merged.count = 0
+	for len(m.runs) > 0 {
+		rr := m.runs[0]
+		if rr.current.kind != merged.kind || rr.current.key != merged.key ||
+			rr.current.value != merged.value {
+			break
+		}
+		merged.count += rr.current.count
+
+		if err := rr.next(); err == io.EOF {
+			rr.f.Close()
+			heap.Pop(&m.runs)
+		} else if err != nil {
+			return runRecord{}, err
+		} else {
+			heap.Fix(&m.runs, 0)
+		}
+	}
+	return merged, nil
+}
+
+func (m *runMerger) close() {
+	for _, rr := range m.runs {
+		rr.f.Close()
+	}
+	m.runs = nil
+}
+
+// Entries spills the remaining counts and returns a reader of the
+// merged counts of each key, data before forms
+func (b *ExternalBuilder) Entries() (domain.ChainEntryReader, error) {
+	if err := b.spill(); err != nil {
+		return nil, err
+	}
+
+	// merge runs in groups until few enough remain to merge at once
+	for len(b.runs) > maxMergeRuns {
+		merged := make([]string, 0)
+		for i := 0; i < len(b.runs); i += maxMergeRuns {
+			end := util.MinInt(i+maxMergeRuns, len(b.runs))
+			path, err := b.mergeRuns(b.runs[i:end])
+			if err != nil {
+				return nil, err
+			}
+			merged = append(merged, path)
+		}
+		b.runs = merged
+	}
+
+	merger, err := newRunMerger(b.runs)
+	if err != nil {
+		return nil, err
+	}
+	return &entryReader{merger: merger, minCount: b.MinCount}, nil
+}
+
+// mergeRuns merges runs into one new run and removes them
+func (b *ExternalBuilder) mergeRuns(runs []string) (string, error) {
+	merger, err := newRunMerger(runs)
+	if err != nil {
+		return "", err
+	}
+	defer merger.close()
+
+	path := filepath.Join(b.dir, fmt.Sprintf("merged-%v", b.merges))
+	b.merges++
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(f)
+	for {
+		record, err := merger.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			f.Close()
+			return "", err
+		}
+		writeRecord(w, record)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	for _, run := range runs {
+		os.Remove(run)
+	}
+	return path, nil
+}
+
+// Close removes the builder's temporary files
+func (b *ExternalBuilder) Close() error {
+	return os.RemoveAll(b.dir)
+}
+
+// entryReader groups merged records into chain entries
+type entryReader struct {
+	merger   *runMerger
+	minCount int
+	pending  *runRecord
+}
+
+// Next returns the entry of the next key, or io.EOF after the last
+func (r *entryReader) Next() (domain.ChainEntryDao, error) {
+	for {
+		entry, err := r.read()
+		if err != nil || len(entry.Values) > 0 {
+			return entry, err
+		}
+		// every suffix of the key was below the minimum count
+	}
+}
+
+// read reads the records of the next key
+func (r *entryReader) read() (domain.ChainEntryDao, error) {
+	if r.pending == nil {
+		record, err := r.merger.next()
+		if err != nil {
+			return domain.ChainEntryDao{}, err
+		}
+		r.pending = &record
+	}
+
+	first := *r.pending
+	r.pending = nil
+	entry := domain.ChainEntryDao{
+		Kind:   domain.ChainEntryData,
+		Key:    first.key,
+		Values: make(map[string]int),
+	}
+	if first.kind == recordForm {
+		entry.Kind = domain.ChainEntryForms
+	}
+
+	for record := first; ; {
+		if record.kind == recordForm || record.count >= r.minCount {
+			entry.Values[record.value] = record.count
+		}
+
+		next, err := r.merger.next()
+		if err == io.EOF {
+			return entry, nil
+		} else if err != nil {
+			return domain.ChainEntryDao{}, err
+		}
+		if next.kind != first.kind || next.key != first.key {
+			r.pending = &next
+			return entry, nil
+		}
+		record = next
+	}
+}
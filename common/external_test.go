@@ -0,0 +1,70 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+func TestExternalBuilder(t *testing.T) {
+	corpus := benchmarkCorpus(3 * maxReadBytes)
+	// the corpus as a single line, which must still be spilled in pieces
+	line := bytes.ReplaceAll(corpus, []byte("\n"), []byte(" "))
+	tests := []struct {
+		name   string
+		text   []byte
+		limit  int
+		spills bool
+	}{
+		{"in memory", corpus, 1 << 30, false},
+		{"spilled", corpus, 20000, true},
+		{"one long line", line, 20000, true},
+	}
+
+	for _, test := range tests {
+		chain := NewChain(2)
+		chain.Build(bytes.NewReader(test.text))
+
+		builder, err := NewExternalBuilder(NewChain(2), test.limit, t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := builder.Build(bytes.NewReader(test.text)); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if spilled := len(builder.runs) > 0; spilled != test.spills {
+			t.Errorf("%v: spilled is %v, want %v", test.name, spilled, test.spills)
+		}
+		// the limit is checked after every piece of text read
+		if test.spills && len(builder.runs) < len(test.text)/maxReadBytes {
+			t.Errorf("%v: spilled %v runs", test.name, len(builder.runs))
+		}
+
+		entries, err := builder.Entries()
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		data, forms := make(SetMap), make(SetMap)
+		for {
+			entry, err := entries.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%v: %v", test.name, err)
+			}
+			if entry.Kind == domain.ChainEntryForms {
+				forms[entry.Key] = entry.Values
+			} else {
+				data[entry.Key] = entry.Values
+			}
+		}
+		builder.Close()
+
+		if !reflect.DeepEqual(data, chain.data) || !reflect.DeepEqual(forms, chain.forms) {
+			t.Errorf("%v: entries differ from Chain.Build", test.name)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package common
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// FeedbackPolicy limits how much accepted suggestions can change a chain
+type FeedbackPolicy struct {
+	// ClientCap is the most selections one client may record per CapWindow
+	ClientCap int
+	CapWindow time.Duration
+	// MinSources is the number of distinct clients that must accept a
+	// suggestion before it is added to the chain
+	MinSources int
+}
+
+// DefaultFeedbackPolicy is used when a PredictionSvc has no policy set
+var DefaultFeedbackPolicy = FeedbackPolicy{
+	ClientCap:  100,
+	CapWindow:  24 * time.Hour,
+	MinSources: 3,
+}
+
+// promoteLock stops promotions in this process from racing to update
+// the chain. Feedback is claimed in the db by MarkFeedbackPromoted, so
+// other instances never add it twice, and the chain is only written if
+// its version has not changed since it was read.
+var promoteLock sync.Mutex
+
+// RecordSelection records that client accepted suggestion for input.
+// Once enough distinct clients have accepted the same suggestion it is
+// added to the chain and the affected predictions are regenerated
+// in the background.
+func (svc PredictionSvc) RecordSelection(input, suggestion, client string) error {
+	// suggestions may have been capitalized and punctuated for display,
+	// so they are recorded as keys
+	tokenizer, segmenter := svc.splitters()
+	words := make([]string, 0)
+	for _, token := range SentenceTokens(tokenizer, segmenter, suggestion) {
+		words = append(words, util.Clean(token))
+	}
+	if len(words) == 0 {
+		return errors.New("suggestion must not be empty")
+	}
+	suggestion = strings.Join(words, " ")
+	policy := svc.feedbackPolicy()
+
+	since := time.Now().Add(-policy.CapWindow)
+	sent, err := svc.DB.CountFeedbackByClient(client, since)
+	if err != nil {
+		return err
+	}
+	if sent >= int64(policy.ClientCap) {
+		return domain.ErrFeedbackLimit
+	}
+
+	key := svc.makePrefix(input).ToString()
+	feedback := domain.FeedbackDao{
+		Prefix:     key,
+		Suggestion: suggestion,
+		Client:     client,
+		Created:    time.Now(),
+	}
+	if err := svc.DB.InsertFeedback(feedback); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := svc.promote(key, suggestion, policy); err != nil {
+			log.Printf("Could not learn %q after %q: %v", suggestion, key, err)
+		}
+	}()
+
+	return nil
+}
+
+func (svc PredictionSvc) feedbackPolicy() FeedbackPolicy {
+	if svc.Feedback == (FeedbackPolicy{}) {
+		return DefaultFeedbackPolicy
+	}
+	return svc.Feedback
+}
+
+// promote adds all unpromoted feedback for a suggestion to the chain
+// if it has been accepted by enough distinct clients
+func (svc PredictionSvc) promote(key, suggestion string, policy FeedbackPolicy) error {
+	if svc.ChainID == "" {
+		return nil
+	}
+
+	promoteLock.Lock()
+	defer promoteLock.Unlock()
+
+	feedback, err := svc.DB.GetFeedback(key, suggestion)
+	if err != nil {
+		return err
+	}
+
+	clients := make(map[string]bool)
+	pending := make([]domain.FeedbackDao, 0)
+	for _, f := range feedback {
+		clients[f.Client] = true
+		if !f.Promoted {
+			pending = append(pending, f)
+		}
+	}
+	if len(clients) < policy.MinSources || len(pending) == 0 {
+		return nil
+	}
+
+	// never learn suggestions that would be blocked
+	safety, err := svc.safetyPolicy(svc.ChainID)
+	if err != nil {
+		return err
+	}
+	if safety.Blocks(key, suggestion) {
+		return nil
+	}
+
+	// only the feedback read above is claimed, so feedback recorded
+	// since is counted by a later promotion
+	ids := make([]string, len(pending))
+	for i, f := range pending {
+		ids[i] = f.ID
+	}
+	claimed, err := svc.DB.MarkFeedbackPromoted(ids)
+	if err == nil && len(claimed) == 0 {
+		return nil
+	}
+	isClaimed := make(map[string]bool, len(claimed))
+	for _, id := range claimed {
+		isClaimed[id] = true
+	}
+	claimedFeedback := make([]domain.FeedbackDao, 0, len(claimed))
+	for _, f := range pending {
+		if isClaimed[f.ID] {
+			claimedFeedback = append(claimedFeedback, f)
+		}
+	}
+
+	var chain Chain
+	var affected map[string]bool
+	if err == nil {
+		chain, affected, err = svc.learn(key, suggestion, claimedFeedback)
+	}
+	if err != nil {
+		// the claimed feedback was not added, so leave it for a later
+		// promotion
+		if len(claimed) > 0 {
+			if unmarkErr := svc.DB.UnmarkFeedbackPromoted(claimed); unmarkErr != nil {
+				log.Printf("Could not release feedback %v: %v", claimed, unmarkErr)
+			}
+		}
+		return err
+	}
+
+	log.Printf("Learned %q after %q from %v clients", suggestion, key, len(clients))
+	return svc.refreshPredictions(chain, affected)
+}
+
+// learnAttempts is how many times a chain changed by another instance
+// is read again before learning feedback gives up
+const learnAttempts = 5
+
+// learn adds the feedback for suggestion after key to the chain and
+// saves it, reading the chain again if another instance saved it in
+// the meantime. It returns the saved chain and the prefixes it changed.
+func (svc PredictionSvc) learn(key, suggestion string,
+	feedback []domain.FeedbackDao) (Chain, map[string]bool, error) {
+
+	words := strings.Fields(suggestion)
+	err := domain.ErrChainChanged
+	for attempt := 0; attempt < learnAttempts && err == domain.ErrChainChanged; attempt++ {
+		var dao domain.UserChainDao
+		if dao, err = svc.DB.GetChainByID(svc.ChainID); err != nil {
+			break
+		}
+		var chain Chain
+		if chain, err = ChainFromDao(dao); err != nil {
+			break
+		}
+
+		// walk the suggestion through the chain, counting each transition
+		// and the words before it in the reverse chain
+		affected := make(map[string]bool)
+		for _, f := range feedback {
+			prefix := ParsePrefix(key, chain.prefixLen)
+			for _, word := range words {
+				affected[prefix.ToString()] = true
+				chain.add(prefix.ToString(), word, f.Created, false)
+				prefix.Shift(word)
+			}
+			chain.addReverseAfter(ParsePrefix(key, chain.prefixLen), words)
+		}
+
+		if err = svc.DB.UpdateChain(svc.ChainID, dao.Version, dao.Users, chain); err == nil {
+			return chain, affected, nil
+		}
+	}
+	return Chain{}, nil, err
+}
+
+// refreshPredictions regenerates the predictions for the given prefixes
+// and for every prefix whose predictions can reach them. Predictions
+// served from a model are only changed by compiling it again.
+func (svc PredictionSvc) refreshPredictions(chain Chain, prefixes map[string]bool) error {
+	if svc.Model != nil {
+		log.Printf("Compile the model again to predict the %v changed prefixes", len(prefixes))
+		return nil
+	}
+
+	chainData := chain.rankData()
+	policy, err := svc.safetyPolicy(svc.ChainID)
+	if err != nil {
+		return err
+	}
+	refresh := make(map[string]bool)
+	for key := range prefixes {
+		refresh[key] = true
+	}
+
+	// predictions look ahead predictionDepth words, so walk backwards
+	// from the changed prefixes that many times
+	frontier := prefixes
+	for depth := 0; depth < predictionDepth; depth++ {
+		next := make(map[string]bool)
+		for key, suffixes := range chainData {
+			for suffix := range suffixes {
+				prefix := ParsePrefix(key, chain.prefixLen)
+				prefix.Shift(suffix)
+				if frontier[prefix.ToString()] && !refresh[key] {
+					next[key] = true
+					refresh[key] = true
+				}
+			}
+		}
+		frontier = next
+	}
+
+	for key := range refresh {
+		prediction := predictionFromChain(key, chain, chainData, policy)
+		prediction.Source = svc.ChainID
+		if err := svc.DB.UpsertPrediction(prediction); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Refreshed %v predictions", len(refresh))
+	return nil
+}
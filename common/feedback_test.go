@@ -0,0 +1,167 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+func TestRecordSelection(t *testing.T) {
+	policy := FeedbackPolicy{ClientCap: 2, CapWindow: time.Hour, MinSources: 3}
+	tests := []struct {
+		name       string
+		suggestion string
+		sent       int
+		wantErr    error
+	}{
+		{"first selection", "cat", 0, nil},
+		{"under the cap", "cat", 1, nil},
+		{"at the cap", "cat", 2, domain.ErrFeedbackLimit},
+		{"empty suggestion", " ", 0, errors.New("suggestion must not be empty")},
+	}
+
+	for _, test := range tests {
+		db := newFakeDB()
+		// without a chain id nothing is learned
+		svc := PredictionSvc{DB: db, Feedback: policy}
+		for i := 0; i < test.sent; i++ {
+			db.InsertFeedback(domain.FeedbackDao{Client: "client", Created: time.Now()})
+		}
+
+		err := svc.RecordSelection("The", test.suggestion, "client")
+		if fmt.Sprint(err) != fmt.Sprint(test.wantErr) {
+			t.Errorf("%v: RecordSelection() error = %v, want %v", test.name, err, test.wantErr)
+		}
+		if want := test.sent; test.wantErr == nil {
+			want++
+			if len(db.feedback) != want {
+				t.Errorf("%v: recorded %v feedback, want %v", test.name, len(db.feedback), want)
+			}
+		}
+	}
+}
+
+func TestRecordSelectionKeys(t *testing.T) {
+	db := newFakeDB()
+	svc := PredictionSvc{DB: db}
+	if err := svc.RecordSelection("I saw the", "Black Cat", "client"); err != nil {
+		t.Fatal(err)
+	}
+	f := db.feedback[0]
+	if f.Prefix != "saw the" || f.Suggestion != "black cat" {
+		t.Errorf("recorded %q after %q, want %q after %q", f.Suggestion, f.Prefix, "black cat", "saw the")
+	}
+}
+
+func TestPromote(t *testing.T) {
+	policy := FeedbackPolicy{ClientCap: 10, CapWindow: time.Hour, MinSources: 3}
+	saveErr := errors.New("save failed")
+	tests := []struct {
+		name      string
+		clients   []string
+		promoted  bool
+		blocked   bool
+		conflicts int
+		updateErr error
+		// learned is how many times the suggestion is added to the chain
+		learned int
+		wantErr error
+	}{
+		{"too few clients", []string{"a", "b", "b"}, false, false, 0, nil, 0, nil},
+		{"enough clients", []string{"a", "b", "c"}, false, false, 0, nil, 3, nil},
+		{"already promoted", []string{"a", "b", "c"}, true, false, 0, nil, 0, nil},
+		{"blocked", []string{"a", "b", "c"}, false, true, 0, nil, 0, nil},
+		{"chain changed", []string{"a", "b", "c"}, false, false, 2, nil, 3, nil},
+		{"chain keeps changing", []string{"a", "b", "c"}, false, false, learnAttempts, nil, 0, domain.ErrChainChanged},
+		{"save fails", []string{"a", "b", "c"}, false, false, 0, saveErr, 0, saveErr},
+	}
+
+	for i, test := range tests {
+		db := newFakeDB()
+		chainID := fmt.Sprintf("promote %v", i)
+		chain := NewChain(2)
+		chain.Build(strings.NewReader("The cat sat."))
+		db.chains[chainID] = storedChain([]string{"user"}, chain)
+		if test.blocked {
+			db.policies[chainID] = domain.SafetyPolicyDao{ChainID: chainID, Words: []string{"dog"}}
+		}
+		db.conflicts, db.updateErr = test.conflicts, test.updateErr
+		for _, client := range test.clients {
+			db.InsertFeedback(domain.FeedbackDao{
+				Prefix:     "<s> the",
+				Suggestion: "dog",
+				Client:     client,
+				Promoted:   test.promoted,
+			})
+		}
+
+		svc := PredictionSvc{DB: db, ChainID: chainID, Feedback: policy}
+		err := svc.promote("<s> the", "dog", policy)
+		if err != test.wantErr {
+			t.Errorf("%v: promote() error = %v, want %v", test.name, err, test.wantErr)
+		}
+
+		if got := db.chains[chainID].Data["<s> the"]["dog"]; got != test.learned {
+			t.Errorf("%v: learned dog %v times, want %v", test.name, got, test.learned)
+		}
+		if got := db.chains[chainID].Data["<s> the"]["cat"]; got != 1 {
+			t.Errorf("%v: count of cat = %v, want 1", test.name, got)
+		}
+		for _, f := range db.feedback {
+			if want := test.promoted || test.learned > 0; f.Promoted != want {
+				t.Errorf("%v: feedback promoted = %v, want %v", test.name, f.Promoted, want)
+			}
+		}
+
+		_, refreshed := db.predictions[chainID+"|<s> the"]
+		if refreshed != (test.learned > 0) {
+			t.Errorf("%v: prediction refreshed = %v, want %v", test.name, refreshed, test.learned > 0)
+		}
+	}
+}
+
+func TestRefreshPredictions(t *testing.T) {
+	db := newFakeDB()
+	chain := NewChain(2)
+	chain.Build(strings.NewReader("The cat sat. The cat ran. A dog sat."))
+	svc := PredictionSvc{DB: db, ChainID: "refresh"}
+
+	// "sat" was changed, so the prefixes that reach it within
+	// predictionDepth words are refreshed as well
+	if err := svc.refreshPredictions(chain, map[string]bool{"the cat": true}); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		prefix string
+		want   bool
+	}{
+		{"the cat", true},
+		{"<s> the", true},
+		{"<s> <s>", true},
+		{"cat sat", false},
+		{"a dog", false},
+	}
+	for _, test := range tests {
+		prediction, ok := db.predictions["refresh|"+test.prefix]
+		if ok != test.want {
+			t.Errorf("prediction for %q refreshed = %v, want %v", test.prefix, ok, test.want)
+		}
+		if ok && prediction.Source != "refresh" {
+			t.Errorf("prediction for %q has source %q, want refresh", test.prefix, prediction.Source)
+		}
+	}
+
+	// predictions served from a model are not refreshed
+	svc.Model = &Model{}
+	svc.ChainID = "refresh model"
+	if err := svc.refreshPredictions(chain, map[string]bool{"the cat": true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.predictions["refresh model|the cat"]; ok {
+		t.Error("refreshed the predictions of a model")
+	}
+}
@@ -0,0 +1,235 @@
+package common
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// infillBlank matches the blank to fill in, two or more underscores
+var infillBlank = regexp.MustCompile(`_{2,}`)
+
+// readReverse adds token to the sentence being read, counting the
+// sentence in the reverse chain once it ends. It returns the sentence.
+func (c Chain) readReverse(sentence []string, token string) []string {
+	if c.reverse == nil {
+		return sentence
+	}
+	if token != util.SentenceEnd {
+		return append(sentence, token)
+	}
+	c.addReverse(sentence)
+	return sentence[:0]
+}
+
+// addReverse counts each word of a sentence after the words that follow
+// it, and the start of the sentence after its first words
+func (c Chain) addReverse(sentence []string) {
+	if c.reverse == nil || len(sentence) == 0 {
+		return
+	}
+	for i := len(sentence) - 1; i >= 0; i-- {
+		c.reverse.Add(reverseKey(sentence[i+1:], c.prefixLen), util.Clean(sentence[i]))
+	}
+	c.reverse.Add(reverseKey(sentence, c.prefixLen), util.SentenceStart)
+}
+
+// addReverseAfter counts words in the reverse chain as following the
+// words of prefix. Only counts whose keys are known are added: those
+// whose words are all given, or that run past the end of a sentence.
+func (c Chain) addReverseAfter(prefix Prefix, words []string) {
+	if c.reverse == nil {
+		return
+	}
+
+	// the words before the start of the sentence are not counted
+	sentence := make([]string, 0, len(prefix)+len(words))
+	for _, word := range prefix {
+		if word == util.SentenceStart {
+			sentence = sentence[:0]
+		}
+		sentence = append(sentence, word)
+	}
+	start := len(sentence)
+	ended := false
+	for _, word := range words {
+		if word == util.SentenceEnd {
+			ended = true
+			break
+		}
+		sentence = append(sentence, word)
+	}
+	if len(sentence) == start {
+		return
+	}
+
+	// counts of words followed only by words of prefix are unchanged
+	for i := util.MaxInt(start-c.prefixLen, 0); i < len(sentence); i++ {
+		after := sentence[i+1:]
+		if len(after) < c.prefixLen && !ended {
+			break
+		}
+		c.reverse.Add(reverseKey(after, c.prefixLen), util.Clean(sentence[i]))
+	}
+}
+
+// reverseKey returns the reverse chain key of the first prefixLen words
+// of a sentence. Positions after the end of the sentence hold
+// util.SentenceEnd.
+func reverseKey(words []string, prefixLen int) string {
+	key := make(Prefix, prefixLen)
+	for i := range key {
+		key[i] = util.SentenceEnd
+		if i < len(words) {
+			key[i] = util.Clean(words[i])
+		}
+	}
+	return key.ToString()
+}
+
+// Infiller suggests words for a blank in the middle of a sentence from
+// the words on both sides of it
+type Infiller struct {
+	chain    Chain
+	forward  ChainPredictor
+	backward ChainPredictor
+}
+
+// NewInfiller creates an infiller from a chain built WithReverse
+func NewInfiller(chain Chain) (Infiller, error) {
+	if chain.reverse == nil {
+		return Infiller{}, errors.New("chain has no reverse chain")
+	}
+
+	backward := newChainPredictor(chain, chain.reverse)
+	backward.rankData = withPartialKeys(chain.reverse)
+	return Infiller{
+		chain:    chain,
+		forward:  NewChainPredictor(chain),
+		backward: backward,
+	}, nil
+}
+
+// withPartialKeys returns the reverse chain with keys for the first words
+// of each key, counting every word before them. They are used when the
+// input ends before the sentence, so the words after it are not known.
+func withPartialKeys(reverse SetMap) SetMap {
+	result := make(SetMap, len(reverse))
+	for key, set := range reverse {
+		result[key] = set
+	}
+	for key, set := range reverse {
+		words := strings.Fields(key)
+		for k := 1; k < len(words); k++ {
+			partial := strings.Join(words[:k], " ")
+			if _, ok := result[partial]; !ok {
+				result[partial] = make(Set)
+			}
+			for word, count := range set {
+				result[partial][word] += count
+			}
+		}
+	}
+	return result
+}
+
+// Infill returns up to n words for the blank in input, written as two or
+// more underscores, most likely first. A word's probability given both
+// sides is proportional to its probability after the words before the
+// blank times its probability before the words after it, divided by how
+// common it is. Scores are these probabilities, removed or downranked by
+// policy, normalized over the words considered.
+func (inf Infiller) Infill(input string, n int, policy SafetyPolicy) ([]domain.InfillCandidate, error) {
+	blanks := infillBlank.FindAllStringIndex(input, -1)
+	if len(blanks) != 1 {
+		return nil, errors.New("input must contain one blank, written as ___")
+	}
+	left, right := input[:blanks[0][0]], input[blanks[0][1]:]
+
+	chain := inf.chain
+	prefix := MakePrefix(left, chain.prefixLen, chain.tokenizer, chain.segmenter)
+	key := prefix.ToString()
+	// the words after the blank up to the end of their sentence. If the
+	// input ends first, the key is only the words it has, and a blank at
+	// the end of the input is filled in from the words before it.
+	after := SentenceTokens(chain.tokenizer, chain.segmenter, right)
+	ended := false
+	for i, token := range after {
+		if token == util.SentenceEnd {
+			after, ended = after[:i], true
+			break
+		}
+	}
+	backKey := reverseKey(after, chain.prefixLen)
+	if !ended && len(after) < chain.prefixLen {
+		backKey = reverseKey(after, len(after))
+	}
+
+	words := make(map[string]bool)
+	for word := range inf.forward.rankData[key] {
+		words[word] = util.IsWord(word)
+	}
+	for word := range inf.backward.rankData[backKey] {
+		words[word] = util.IsWord(word)
+	}
+
+	candidates := make([]domain.InfillCandidate, 0, len(words))
+	total := 0.0
+	for word, isWord := range words {
+		if !isWord {
+			continue
+		}
+		scale, ok := policy.Scale(key, word)
+		if !ok {
+			continue
+		}
+		candidate := domain.InfillCandidate{
+			Word:     chain.Form(word),
+			Forward:  inf.forward.Probability(key, word),
+			Backward: inf.backward.Probability(backKey, word),
+		}
+		candidate.Score = scale * candidate.Forward * candidate.Backward / inf.forward.unigram(word)
+		total += candidate.Score
+		candidates = append(candidates, candidate)
+	}
+	if len(candidates) == 0 {
+		return nil, domain.ErrNoPrediction
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Word < candidates[j].Word
+	})
+	candidates = candidates[:util.MinInt(n, len(candidates))]
+
+	// match the user's casing if the blank starts a sentence
+	capitalize := prefix.IsEmpty() && util.CapitalizesSentences(input)
+	for i := range candidates {
+		if total > 0 {
+			candidates[i].Score /= total
+		}
+		if capitalize {
+			candidates[i].Word = util.Capitalize(candidates[i].Word)
+		}
+	}
+	return candidates, nil
+}
+
+// Infill suggests up to n words for the blank in input from the
+// infiller's chain, following the chain's safety policy
+func (svc PredictionSvc) Infill(input string, n int) ([]domain.InfillCandidate, error) {
+	if svc.Infiller == nil {
+		return nil, errors.New("no chain to fill in blanks from")
+	}
+	policy, err := svc.safetyPolicy(svc.InfillChainID)
+	if err != nil {
+		return nil, err
+	}
+	return svc.Infiller.Infill(input, n, policy)
+}
@@ -0,0 +1,178 @@
+package common
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+const infillText = `The cat sat on the mat. The dog sat on the rug.
+The cat slept on the mat. The cat ran to the door.
+The dog ran on the grass. A bird sat on the fence.`
+
+func newTestInfiller(t *testing.T) Infiller {
+	chain := NewChain(2).WithReverse()
+	chain.Build(strings.NewReader(infillText))
+	infiller, err := NewInfiller(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return infiller
+}
+
+func TestInfill(t *testing.T) {
+	infiller := newTestInfiller(t)
+	tests := []struct {
+		input string
+		// first is the best candidate
+		first string
+	}{
+		{"The cat ___ on the mat.", "sat"},
+		{"The cat ___ to the door.", "ran"},
+		{"The cat sat on the ___.", "mat"},
+		{"___ cat sat on the mat.", "The"},
+		{"The cat sat on the ___", "mat"},
+		{"The cat ___", "sat"},
+	}
+
+	for _, test := range tests {
+		candidates, err := infiller.Infill(test.input, 3, SafetyPolicy{})
+		if err != nil {
+			t.Errorf("Infill(%q): %v", test.input, err)
+			continue
+		}
+		if candidates[0].Word != test.first {
+			t.Errorf("Infill(%q) starts with %q, want %q", test.input, candidates[0].Word, test.first)
+		}
+		if len(candidates) > 3 {
+			t.Errorf("Infill(%q) returned %v candidates", test.input, len(candidates))
+		}
+		for i := 1; i < len(candidates); i++ {
+			if candidates[i].Score > candidates[i-1].Score {
+				t.Errorf("Infill(%q) is not sorted: %v", test.input, candidates)
+			}
+		}
+	}
+}
+
+func TestInfillScoresSumToOne(t *testing.T) {
+	infiller := newTestInfiller(t)
+	candidates, err := infiller.Infill("The cat ___ on the mat.", 100, SafetyPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0.0
+	for _, candidate := range candidates {
+		total += candidate.Score
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("scores sum to %v, want 1", total)
+	}
+}
+
+func TestInfillBlanks(t *testing.T) {
+	infiller := newTestInfiller(t)
+	for _, input := range []string{"The cat sat.", "The ___ sat on ___.", "The _ sat."} {
+		if _, err := infiller.Infill(input, 3, SafetyPolicy{}); err == nil || err == domain.ErrNoPrediction {
+			t.Errorf("Infill(%q): want an error for the blanks, got %v", input, err)
+		}
+	}
+}
+
+func TestInfillSafetyPolicy(t *testing.T) {
+	infiller := newTestInfiller(t)
+	tests := []struct {
+		name    string
+		dao     domain.SafetyPolicyDao
+		blocked bool
+	}{
+		{"filter", domain.SafetyPolicyDao{Action: SafetyFilter, Words: []string{"sat"}}, true},
+		{"downrank", domain.SafetyPolicyDao{Action: SafetyDownrank, Penalty: 0.001, Words: []string{"sat"}}, false},
+	}
+
+	for _, test := range tests {
+		policy, err := NewSafetyPolicy(test.dao)
+		if err != nil {
+			t.Fatal(err)
+		}
+		candidates, err := infiller.Infill("The cat ___ on the mat.", 100, policy)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		found := false
+		for i, candidate := range candidates {
+			if candidate.Word == "sat" {
+				found = true
+				if i == 0 {
+					t.Errorf("%v: sat is still the best candidate", test.name)
+				}
+			}
+		}
+		if found == test.blocked {
+			t.Errorf("%v: sat found is %v, want %v", test.name, found, !test.blocked)
+		}
+	}
+}
+
+func TestAddReverseAfter(t *testing.T) {
+	start := NewPrefix(2)
+	tests := []struct {
+		name   string
+		prefix Prefix
+		words  []string
+		want   SetMap
+	}{
+		{
+			name:   "whole sentence",
+			prefix: start,
+			words:  []string{"a", "b", util.SentenceEnd},
+			want: SetMap{
+				"a b":       {util.SentenceStart: 1},
+				"b </s>":    {"a": 1},
+				"</s> </s>": {"b": 1},
+			},
+		},
+		{
+			name:   "sentence start",
+			prefix: Prefix{util.SentenceStart, "a"},
+			words:  []string{"b"},
+			want:   SetMap{"a b": {util.SentenceStart: 1}},
+		},
+		{
+			name:   "middle of a sentence",
+			prefix: Prefix{"a", "b"},
+			words:  []string{"c", "d"},
+			want:   SetMap{"b c": {"a": 1}, "c d": {"b": 1}},
+		},
+		{
+			name:   "no words",
+			prefix: Prefix{"a", "b"},
+			words:  []string{util.SentenceEnd},
+			want:   SetMap{},
+		},
+	}
+
+	for _, test := range tests {
+		chain := NewChain(2).WithReverse()
+		chain.addReverseAfter(test.prefix, test.words)
+		if !reflect.DeepEqual(chain.reverse, test.want) {
+			t.Errorf("%v: reverse chain = %v, want %v", test.name, chain.reverse, test.want)
+		}
+	}
+}
+
+func TestAddReverseAfterMatchesBuild(t *testing.T) {
+	built := NewChain(2).WithReverse()
+	built.Build(strings.NewReader("The cat sat on the mat."))
+
+	chain := NewChain(2).WithReverse()
+	words := SentenceTokens(chain.tokenizer, chain.segmenter, "The cat sat on the mat.")
+	chain.addReverseAfter(NewPrefix(2), append(words, util.SentenceEnd))
+	if !reflect.DeepEqual(chain.reverse, built.reverse) {
+		t.Errorf("reverse chain = %v, want %v", chain.reverse, built.reverse)
+	}
+}
@@ -0,0 +1,213 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// Ways of normalizing chains before they are merged
+const (
+	// MergeRaw adds the counts of each chain times its weight
+	MergeRaw = "raw"
+	// MergeProbability averages the n-gram probabilities of the chains by
+	// their weights, so each chain counts the same whatever its size
+	MergeProbability = "probability"
+)
+
+// splitDifference returns what differs in how the chains split text into
+// prefixes, or "" if they split it the same way
+func splitDifference(a, b Chain) string {
+	switch {
+	case a.prefixLen != b.prefixLen:
+		return "prefix lengths"
+	case a.tokenizer.Name() != b.tokenizer.Name() ||
+		!reflect.DeepEqual(a.tokenizer.Config(), b.tokenizer.Config()):
+		return "tokenizers"
+	case a.segmenter.Language() != b.segmenter.Language() ||
+		!reflect.DeepEqual(a.segmenter.Learned(), b.segmenter.Learned()):
+		return "segmenters"
+	}
+	return ""
+}
+
+// MergeChains combines chains into a new chain, giving each the matching
+// weight. The chains must have the same prefix length, tokenizer,
+// segmenter and half-life. Merged counts are rounded, keeping a count of 1 for
+// suffixes too rare to round to one. The merged chain has a reverse
+// chain if every chain does.
+func MergeChains(chains []Chain, weights []float64, normalization string) (Chain, error) {
+	if len(chains) == 0 {
+		return Chain{}, errors.New("no chains to merge")
+	}
+	if len(weights) != len(chains) {
+		return Chain{}, fmt.Errorf("%v weights given for %v chains", len(weights), len(chains))
+	}
+	if normalization != MergeRaw && normalization != MergeProbability {
+		return Chain{}, errors.New(normalization + " is not a way of normalizing chains")
+	}
+
+	first := chains[0]
+	halfLife, epoch := time.Duration(0), time.Time{}
+	totals := make([]int, len(chains))
+	total, weightSum := 0, 0.0
+	reverse := true
+	for i, chain := range chains {
+		if weights[i] <= 0 {
+			return Chain{}, errors.New("weights must be greater than 0")
+		}
+		if differ := splitDifference(chain, first); differ != "" {
+			return Chain{}, fmt.Errorf("chains with different %v cannot be merged", differ)
+		}
+		if chain.weights != nil {
+			if halfLife != 0 && chain.halfLife != halfLife {
+				return Chain{}, errors.New("chains with different half-lives cannot be merged")
+			}
+			halfLife = chain.halfLife
+			if chain.epoch.After(epoch) {
+				epoch = chain.epoch
+			}
+		}
+
+		for _, set := range chain.data.(SetMap) {
+			totals[i] += set.Total()
+		}
+		total += totals[i]
+		weightSum += weights[i]
+		reverse = reverse && chain.reverse != nil
+	}
+
+	merged := NewDecayChain(first.prefixLen, halfLife, epoch).
+		WithTokenizer(first.tokenizer).
+		WithSegmenter(first.segmenter)
+	data, forms, reverseData := make(WeightMap), make(WeightMap), make(WeightMap)
+	for i, chain := range chains {
+		if totals[i] == 0 {
+			continue
+		}
+		scale := weights[i]
+		if normalization == MergeProbability {
+			scale *= float64(total) / (float64(totals[i]) * weightSum)
+		}
+
+		data.addScaled(chain.data.(SetMap), scale)
+		forms.addScaled(chain.forms, scale)
+		if reverse {
+			reverseData.addScaled(chain.reverse, scale)
+		}
+		if merged.weights == nil {
+			continue
+		}
+		if chain.weights != nil {
+			merged.weights.Union(chain.weights, scale*decayFactor(chain.epoch, epoch, halfLife))
+		} else {
+			merged.weights.addScaled(chain.data.(SetMap), scale)
+		}
+	}
+
+	merged.data = data.roundCounts()
+	merged.forms = forms.roundCounts()
+	if reverse {
+		merged.reverse = reverseData.roundCounts()
+	}
+	return merged, nil
+}
+
+// addScaled adds the counts of sm times scale to the weights
+func (wm WeightMap) addScaled(sm SetMap, scale float64) {
+	for key, set := range sm {
+		for value, count := range set {
+			wm.Add(key, value, float64(count)*scale)
+		}
+	}
+}
+
+// roundCounts rounds the weights to counts, keeping a count of 1 for
+// weights that round to 0
+func (wm WeightMap) roundCounts() SetMap {
+	counts := make(SetMap)
+	for key, set := range wm {
+		counts[key] = make(Set, len(set))
+		for value, weight := range set {
+			counts[key][value] = util.MaxInt(int(math.Round(weight)), 1)
+		}
+	}
+	return counts
+}
+
+// MergeChains merges stored chains, each given the weight of its source,
+// and saves the result for users. The merged chain records its sources.
+// If users is empty the chain is saved for every user of the sources. A
+// chain already stored for users is only replaced if overwrite is set.
+func (svc PredictionSvc) MergeChains(users []string, sources []domain.ChainSourceDao,
+	normalization string, overwrite bool) error {
+
+	if len(sources) < 2 {
+		return errors.New("at least two chains are required")
+	}
+
+	chains := make([]Chain, len(sources))
+	weights := make([]float64, len(sources))
+	merge := &domain.MergeDao{
+		Normalization: normalization,
+		Sources:       make([]domain.ChainSourceDao, len(sources)),
+		Merged:        time.Now(),
+	}
+	allUsers := make(map[string]bool)
+	for i, source := range sources {
+		dao, err := svc.DB.GetChainByID(source.ChainID)
+		if err != nil {
+			return err
+		}
+		if chains[i], err = ChainFromDao(dao); err != nil {
+			return err
+		}
+		weights[i] = source.Weight
+		merge.Sources[i] = domain.ChainSourceDao{
+			ChainID: source.ChainID,
+			Users:   dao.Users,
+			Split:   dao.Split,
+			Weight:  source.Weight,
+		}
+		for _, user := range dao.Users {
+			allUsers[user] = true
+		}
+	}
+
+	if len(users) == 0 {
+		for user := range allUsers {
+			users = append(users, user)
+		}
+	}
+	sort.Strings(users)
+	// chains are stored by their users, so saving under the users of a
+	// whole source chain would replace it
+	for _, source := range merge.Sources {
+		if source.Split == "" && strings.Join(source.Users, " ") == strings.Join(users, " ") {
+			return fmt.Errorf("merged chain would replace chain %v; give other users", source.ChainID)
+		}
+	}
+	if !overwrite {
+		exists, err := svc.DB.HasChain(users)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("a chain is already stored for %v; give other users or overwrite it", users)
+		}
+	}
+
+	merged, err := MergeChains(chains, weights, normalization)
+	if err != nil {
+		return err
+	}
+	merged.merge = merge
+	return svc.DB.UpsertChain(users, merged)
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeChains(t *testing.T) {
+	chainWith := func(data SetMap) Chain {
+		chain := NewChain(1)
+		chain.data = data
+		return chain
+	}
+	large := chainWith(SetMap{"a": {"x": 3}})
+	small := chainWith(SetMap{"a": {"y": 1}})
+
+	tests := []struct {
+		name          string
+		weights       []float64
+		normalization string
+		want          SetMap
+	}{
+		{"raw counts", []float64{1, 1}, MergeRaw, SetMap{"a": {"x": 3, "y": 1}}},
+		{"weighted raw counts", []float64{2, 1}, MergeRaw, SetMap{"a": {"x": 6, "y": 1}}},
+		{"rare counts are kept", []float64{1, 0.1}, MergeRaw, SetMap{"a": {"x": 3, "y": 1}}},
+		{"probabilities", []float64{1, 1}, MergeProbability, SetMap{"a": {"x": 2, "y": 2}}},
+		{"weighted probabilities", []float64{3, 1}, MergeProbability, SetMap{"a": {"x": 3, "y": 1}}},
+	}
+
+	for _, test := range tests {
+		merged, err := MergeChains([]Chain{large, small}, test.weights, test.normalization)
+		if err != nil {
+			t.Errorf("%v: MergeChains() error = %v", test.name, err)
+			continue
+		}
+		if got := merged.data.(SetMap); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: MergeChains() data = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMergeChainsMismatch(t *testing.T) {
+	french, err := NewSegmenter("fr")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		other Chain
+	}{
+		{"prefix length", NewChain(2)},
+		{"segmenter language", NewChain(1).WithSegmenter(french)},
+		{"learned abbreviations", NewChain(1).WithLearnedAbbreviations([]string{"approx"})},
+	}
+
+	for _, test := range tests {
+		_, err := MergeChains([]Chain{NewChain(1), test.other}, []float64{1, 1}, MergeRaw)
+		if err == nil {
+			t.Errorf("%v: MergeChains() merged chains with a different %v", test.name, test.name)
+		}
+	}
+}
@@ -0,0 +1,428 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+// A model file is a header followed by its sections. Numbers are little
+// endian and offsets are from the start of the file.
+//
+//	header     modelMagic, then each modelField as a uint64
+//	vocabulary sorted words: len+1 uint32 offsets into a blob of words
+//	n-grams    sorted by word ids: prefixLen uint32 word ids, then the
+//	           uint32 index and count of its suggestions
+//	pairs      suggestions: uint32 text index, int64 weight
+//	texts      rendered suggestions: len+1 uint32 offsets into a blob
+//	meta       JSON chain info with the tokenizer and segmenter
+var modelMagic = [8]byte{'P', 'T', 'M', 'O', 'D', 'E', 'L', '1'}
+
+// modelField is the position of a field in the model header
+type modelField int
+
+const (
+	fieldPrefixLen modelField = iota
+	fieldWords
+	fieldVocabIndex
+	fieldVocabBlob
+	fieldNgrams
+	fieldNgramTable
+	fieldPairs
+	fieldPairTable
+	fieldTexts
+	fieldTextIndex
+	fieldTextBlob
+	fieldMeta
+	fieldMetaLen
+	modelFields
+)
+
+const (
+	modelHeaderLen = len(modelMagic) + int(modelFields)*8
+	modelPairLen   = 12
+)
+
+// Model is a compiled, read-only prediction set. Predictions are read
+// directly from the model file, so opening one takes the same time
+// whatever its size.
+type Model struct {
+	data  []byte
+	unmap func() error
+
+	prefixLen int
+	vocab     stringTable
+	ngrams    []byte
+	ngramLen  int
+	pairs     []byte
+	texts     stringTable
+	info      domain.UserChainDao
+	tokenizer domain.Tokenizer
+	segmenter domain.Segmenter
+}
+
+// stringTable is a list of strings stored as offsets into a blob
+type stringTable struct {
+	index []byte
+	blob  []byte
+	len   int
+}
+
+func (t stringTable) bytes(i int) []byte {
+	start := binary.LittleEndian.Uint32(t.index[i*4:])
+	end := binary.LittleEndian.Uint32(t.index[(i+1)*4:])
+	return t.blob[start:end]
+}
+
+// WriteModel compiles the predictions of every prefix in chain, filtered
+// by policy, and writes them to w as a model file
+func WriteModel(w io.Writer, chain Chain, policy SafetyPolicy) error {
+	rankData := chain.rankData()
+	keys := make([]Prefix, 0, len(rankData))
+	predictions := make(map[string]domain.Prediction, len(rankData))
+	words := make(map[string]uint32)
+	for key := range rankData {
+		prediction := predictionFromChain(key, chain, rankData, policy)
+		prefix := ParsePrefix(prediction.Prefix, chain.prefixLen)
+		for _, word := range prefix {
+			words[word] = 0
+		}
+		keys = append(keys, prefix)
+		predictions[prediction.Prefix] = prediction
+	}
+
+	// ids follow the order of the words, so sorting n-grams by id
+	// also sorts them by key
+	vocab := make([]string, 0, len(words))
+	for word := range words {
+		vocab = append(vocab, word)
+	}
+	sort.Strings(vocab)
+	for i, word := range vocab {
+		words[word] = uint32(i)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		for k := range keys[i] {
+			if keys[i][k] != keys[j][k] {
+				return keys[i][k] < keys[j][k]
+			}
+		}
+		return false
+	})
+
+	ngrams := make([]byte, 0, len(keys)*(chain.prefixLen+2)*4)
+	pairs := make([]byte, 0)
+	textIDs := make(map[string]uint32)
+	texts := make([]string, 0)
+	pairCount := 0
+	for _, prefix := range keys {
+		for _, word := range prefix {
+			ngrams = binary.LittleEndian.AppendUint32(ngrams, words[word])
+		}
+		suffixes := predictions[prefix.ToString()].Suffixes
+		ngrams = binary.LittleEndian.AppendUint32(ngrams, uint32(pairCount))
+		ngrams = binary.LittleEndian.AppendUint32(ngrams, uint32(len(suffixes)))
+
+		for _, suffix := range suffixes {
+			id, ok := textIDs[suffix.Key]
+			if !ok {
+				id = uint32(len(texts))
+				textIDs[suffix.Key] = id
+				texts = append(texts, suffix.Key)
+			}
+			pairs = binary.LittleEndian.AppendUint32(pairs, id)
+			pairs = binary.LittleEndian.AppendUint64(pairs, uint64(suffix.Value))
+			pairCount++
+		}
+	}
+
+	meta, err := json.Marshal(domain.UserChainDao{
+		PrefixLen: chain.prefixLen,
+		Tokenizer: domain.TokenizerDao{
+			Name:   chain.tokenizer.Name(),
+			Config: chain.tokenizer.Config(),
+		},
+		Segmenter: domain.SegmenterDao{
+			Language: chain.segmenter.Language(),
+			Learned:  chain.segmenter.Learned(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	vocabIndex, vocabBlob := encodeStrings(vocab)
+	textIndex, textBlob := encodeStrings(texts)
+	sections := [][]byte{vocabIndex, vocabBlob, ngrams, pairs, textIndex, textBlob, meta}
+	offsets := make([]uint64, len(sections))
+	offset := uint64(modelHeaderLen)
+	for i, section := range sections {
+		offsets[i] = offset
+		offset += uint64(len(section))
+	}
+
+	var header [modelFields]uint64
+	header[fieldPrefixLen] = uint64(chain.prefixLen)
+	header[fieldWords] = uint64(len(vocab))
+	header[fieldVocabIndex] = offsets[0]
+	header[fieldVocabBlob] = offsets[1]
+	header[fieldNgrams] = uint64(len(keys))
+	header[fieldNgramTable] = offsets[2]
+	header[fieldPairs] = uint64(pairCount)
+	header[fieldPairTable] = offsets[3]
+	header[fieldTexts] = uint64(len(texts))
+	header[fieldTextIndex] = offsets[4]
+	header[fieldTextBlob] = offsets[5]
+	header[fieldMeta] = offsets[6]
+	header[fieldMetaLen] = uint64(len(meta))
+
+	bw := bufio.NewWriter(w)
+	bw.Write(modelMagic[:])
+	if err := binary.Write(bw, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	for _, section := range sections {
+		if _, err := bw.Write(section); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// encodeStrings encodes strings as a string table
+func encodeStrings(strs []string) (index, blob []byte) {
+	index = make([]byte, 0, (len(strs)+1)*4)
+	index = binary.LittleEndian.AppendUint32(index, 0)
+	for _, s := range strs {
+		blob = append(blob, s...)
+		index = binary.LittleEndian.AppendUint32(index, uint32(len(blob)))
+	}
+	return index, blob
+}
+
+// OpenModel maps the model file at path into memory. It must be
+// closed when it is no longer used.
+func OpenModel(path string) (*Model, error) {
+	data, unmap, err := mapFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := parseModel(data)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	model.unmap = unmap
+	return model, nil
+}
+
+// parseModel finds the sections of a model file without reading them
+func parseModel(data []byte) (*Model, error) {
+	if len(data) < modelHeaderLen || !bytes.Equal(data[:len(modelMagic)], modelMagic[:]) {
+		return nil, errors.New("not a model file")
+	}
+	var header [modelFields]uint64
+	for i := range header {
+		header[i] = binary.LittleEndian.Uint64(data[len(modelMagic)+i*8:])
+	}
+
+	// counts larger than the file would overflow the section sizes
+	size := uint64(len(data))
+	for _, field := range []modelField{fieldPrefixLen, fieldWords, fieldNgrams, fieldPairs, fieldTexts} {
+		if header[field] > size {
+			return nil, errors.New("model file is corrupt")
+		}
+	}
+	if header[fieldPrefixLen] == 0 {
+		return nil, errors.New("model file is corrupt")
+	}
+
+	// section returns the n bytes at the offset in field, or nil if
+	// they are outside the file
+	section := func(field modelField, n uint64) []byte {
+		offset := header[field]
+		if offset > size || n > size-offset {
+			return nil
+		}
+		return data[offset : offset+n]
+	}
+	table := func(count, index, blob modelField) (stringTable, bool) {
+		t := stringTable{index: section(index, (header[count]+1)*4), len: int(header[count])}
+		if t.index == nil {
+			return t, false
+		}
+		t.blob = section(blob, uint64(binary.LittleEndian.Uint32(t.index[t.len*4:])))
+		return t, t.blob != nil
+	}
+
+	model := &Model{
+		data:      data,
+		prefixLen: int(header[fieldPrefixLen]),
+		ngramLen:  int(header[fieldPrefixLen]+2) * 4,
+	}
+	var vocabOK, textsOK bool
+	model.vocab, vocabOK = table(fieldWords, fieldVocabIndex, fieldVocabBlob)
+	model.texts, textsOK = table(fieldTexts, fieldTextIndex, fieldTextBlob)
+	model.ngrams = section(fieldNgramTable, header[fieldNgrams]*uint64(model.ngramLen))
+	model.pairs = section(fieldPairTable, header[fieldPairs]*modelPairLen)
+	meta := section(fieldMeta, header[fieldMetaLen])
+	if !vocabOK || !textsOK || model.ngrams == nil || model.pairs == nil || meta == nil {
+		return nil, errors.New("model file is truncated")
+	}
+	if err := model.validate(); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(meta, &model.info); err != nil {
+		return nil, err
+	}
+	var err error
+	if model.tokenizer, err = TokenizerFromDao(model.info); err != nil {
+		return nil, err
+	}
+	if model.segmenter, err = SegmenterFromDao(model.info); err != nil {
+		return nil, err
+	}
+
+	return model, nil
+}
+
+// validate checks that every index in the model is within the table
+// it indexes, so reading predictions never goes past the file
+func (m *Model) validate() error {
+	for _, table := range []stringTable{m.vocab, m.texts} {
+		end := uint32(0)
+		for i := 0; i <= table.len; i++ {
+			offset := binary.LittleEndian.Uint32(table.index[i*4:])
+			if offset < end || int(offset) > len(table.blob) {
+				return errors.New("model file has a corrupt string table")
+			}
+			end = offset
+		}
+	}
+
+	pairs := uint64(len(m.pairs) / modelPairLen)
+	for i := 0; i < m.Len(); i++ {
+		record := m.ngrams[i*m.ngramLen:]
+		for j := 0; j < m.prefixLen; j++ {
+			if int(binary.LittleEndian.Uint32(record[j*4:])) >= m.vocab.len {
+				return errors.New("model file has a corrupt word id")
+			}
+		}
+		start := uint64(binary.LittleEndian.Uint32(record[m.prefixLen*4:]))
+		count := uint64(binary.LittleEndian.Uint32(record[m.prefixLen*4+4:]))
+		if start+count > pairs {
+			return errors.New("model file has a corrupt suggestion index")
+		}
+	}
+
+	for i := uint64(0); i < pairs; i++ {
+		if int(binary.LittleEndian.Uint32(m.pairs[i*modelPairLen:])) >= m.texts.len {
+			return errors.New("model file has a corrupt suggestion text index")
+		}
+	}
+	return nil
+}
+
+// Close unmaps the model file
+func (m *Model) Close() error {
+	if m.unmap == nil {
+		return nil
+	}
+	err := m.unmap()
+	m.unmap = nil
+	m.data = nil
+	return err
+}
+
+// GetPrediction returns the prediction compiled for a key made by
+// Prefix.ToString, or domain.ErrNoPrediction if there is none
+func (m *Model) GetPrediction(key string) (domain.Prediction, error) {
+	prefix := ParsePrefix(key, m.prefixLen)
+	ids := make([]uint32, len(prefix))
+	for i, word := range prefix {
+		id, ok := m.wordID(word)
+		if !ok {
+			return domain.Prediction{}, domain.ErrNoPrediction
+		}
+		ids[i] = id
+	}
+
+	n := len(m.ngrams) / m.ngramLen
+	i := sort.Search(n, func(i int) bool {
+		return m.compareNgram(i, ids) >= 0
+	})
+	if i == n || m.compareNgram(i, ids) != 0 {
+		return domain.Prediction{}, domain.ErrNoPrediction
+	}
+
+	record := m.ngrams[i*m.ngramLen+m.prefixLen*4:]
+	start := int(binary.LittleEndian.Uint32(record))
+	count := int(binary.LittleEndian.Uint32(record[4:]))
+	prediction := domain.Prediction{
+		Prefix:   prefix.ToString(),
+		Suffixes: make([]domain.Pair, count),
+	}
+	for j := range prediction.Suffixes {
+		pair := m.pairs[(start+j)*modelPairLen:]
+		prediction.Suffixes[j] = domain.Pair{
+			Key:   string(m.texts.bytes(int(binary.LittleEndian.Uint32(pair)))),
+			Value: int(int64(binary.LittleEndian.Uint64(pair[4:]))),
+		}
+	}
+	return prediction, nil
+}
+
+// wordID finds a word in the sorted vocabulary
+func (m *Model) wordID(word string) (uint32, bool) {
+	target := []byte(word)
+	i := sort.Search(m.vocab.len, func(i int) bool {
+		return bytes.Compare(m.vocab.bytes(i), target) >= 0
+	})
+	if i == m.vocab.len || !bytes.Equal(m.vocab.bytes(i), target) {
+		return 0, false
+	}
+	return uint32(i), true
+}
+
+// compareNgram compares the word ids of the ith n-gram to ids
+func (m *Model) compareNgram(i int, ids []uint32) int {
+	record := m.ngrams[i*m.ngramLen:]
+	for j, id := range ids {
+		other := binary.LittleEndian.Uint32(record[j*4:])
+		if other != id {
+			if other < id {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Len returns the number of prefixes in the model
+func (m *Model) Len() int {
+	return len(m.ngrams) / m.ngramLen
+}
+
+// GetPrefixLen returns the prefix length of the model's chain
+func (m *Model) GetPrefixLen() int {
+	return m.prefixLen
+}
+
+// GetTokenizer returns the tokenizer of the model's chain
+func (m *Model) GetTokenizer() domain.Tokenizer {
+	return m.tokenizer
+}
+
+// GetSegmenter returns the segmenter of the model's chain
+func (m *Model) GetSegmenter() domain.Segmenter {
+	return m.segmenter
+}
@@ -0,0 +1,148 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+func TestModelRoundTrip(t *testing.T) {
+	corpus := benchmarkCorpus(20000)
+	regex, err := MakeTokenizer(RegexTokenizerName, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewSafetyPolicy(domain.SafetyPolicyDao{Words: []string{"cat"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		chain  Chain
+		policy SafetyPolicy
+	}{
+		{"counts", NewChain(2), SafetyPolicy{}},
+		{"prefix length 3", NewChain(3), SafetyPolicy{}},
+		{"decayed", NewDecayChain(2, time.Hour, time.Now()), SafetyPolicy{}},
+		{"regex tokenizer", NewChain(2).WithTokenizer(regex), SafetyPolicy{}},
+		{"safety policy", NewChain(2), policy},
+	}
+
+	for _, test := range tests {
+		chain := test.chain
+		chain.Build(bytes.NewReader(corpus))
+		path := filepath.Join(t.TempDir(), "chain.model")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteModel(file, chain, test.policy); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		file.Close()
+
+		model, err := OpenModel(path)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		rankData := chain.rankData()
+		if model.Len() != len(rankData) {
+			t.Errorf("%v: model has %v prefixes, want %v", test.name, model.Len(), len(rankData))
+		}
+		if model.GetPrefixLen() != chain.prefixLen ||
+			model.GetTokenizer().Name() != chain.tokenizer.Name() {
+			t.Errorf("%v: model does not record how the chain split text", test.name)
+		}
+		for key := range rankData {
+			want := predictionFromChain(key, chain, rankData, test.policy)
+			got, err := model.GetPrediction(key)
+			if err != nil {
+				t.Errorf("%v: GetPrediction(%q): %v", test.name, key, err)
+			} else if !reflect.DeepEqual(got, want) {
+				t.Errorf("%v: GetPrediction(%q) = %v, want %v", test.name, key, got, want)
+			}
+		}
+		if _, err := model.GetPrediction("unknown words"); err != domain.ErrNoPrediction {
+			t.Errorf("%v: GetPrediction of unknown words: %v", test.name, err)
+		}
+		model.Close()
+	}
+}
+
+func TestOpenModelInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chain.model")
+	if err := os.WriteFile(path, []byte("not a model"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenModel(path); err == nil {
+		t.Errorf("opened a file that is not a model")
+	}
+}
+
+func TestOpenModelCorrupt(t *testing.T) {
+	chain := NewChain(2)
+	chain.Build(bytes.NewReader(benchmarkCorpus(2000)))
+	var buf bytes.Buffer
+	if err := WriteModel(&buf, chain, SafetyPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	offset := func(field modelField) int {
+		return int(binary.LittleEndian.Uint64(valid[len(modelMagic)+int(field)*8:]))
+	}
+	ngram := offset(fieldNgramTable)
+
+	tests := []struct {
+		name    string
+		corrupt func(data []byte) []byte
+	}{
+		{"truncated", func(data []byte) []byte { return data[:len(data)/2] }},
+		{"no prefix length", func(data []byte) []byte {
+			binary.LittleEndian.PutUint64(data[len(modelMagic):], 0)
+			return data
+		}},
+		{"huge count", func(data []byte) []byte {
+			binary.LittleEndian.PutUint64(data[len(modelMagic)+int(fieldPairs)*8:], 1<<62)
+			return data
+		}},
+		{"word id", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[ngram:], 1<<31)
+			return data
+		}},
+		{"suggestion index", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[ngram+2*4:], 1<<31)
+			return data
+		}},
+		{"suggestion count", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[ngram+3*4:], 1<<31)
+			return data
+		}},
+		{"text index", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[offset(fieldPairTable):], 1<<31)
+			return data
+		}},
+		{"string offset", func(data []byte) []byte {
+			binary.LittleEndian.PutUint32(data[offset(fieldVocabIndex)+4:], 1<<31)
+			return data
+		}},
+	}
+
+	for _, test := range tests {
+		data := test.corrupt(append([]byte(nil), valid...))
+		path := filepath.Join(t.TempDir(), "chain.model")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		model, err := OpenModel(path)
+		if err == nil {
+			model.Close()
+			t.Errorf("%v: opened a corrupt model", test.name)
+		}
+	}
+}
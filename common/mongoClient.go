@@ -3,6 +3,7 @@ package common
 import (
 	"context"
 	"errors"
+	"io"
 	"log"
 	"sort"
 	"time"
@@ -59,9 +60,66 @@ func (m *MongoClient) GetChainByID(id string) (domain.UserChainDao, error) {
 		return domain.UserChainDao{}, err
 	}
 
+	if result.External {
+		if err := m.getChainEntries(id, result); err != nil {
+			return domain.UserChainDao{}, err
+		}
+	}
+
+	return *result, nil
+}
+
+// GetChainInfoByID gets the chain associated with a specified id
+// without its data, for reading how it was built
+func (m *MongoClient) GetChainInfoByID(id string) (domain.UserChainDao, error) {
+	if m.client == nil {
+		return domain.UserChainDao{}, errors.New("No connection to MongoDB")
+	}
+
+	chains := m.client.Database("predtext").Collection("chain")
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return domain.UserChainDao{}, err
+	}
+	filter := bson.D{{Key: "_id", Value: objectID}}
+	options := &options.FindOneOptions{
+		Projection: bson.D{
+			{Key: "data", Value: 0},
+			{Key: "forms", Value: 0},
+			{Key: "weights", Value: 0},
+			{Key: "holdout", Value: 0},
+			{Key: "reverse", Value: 0},
+		},
+	}
+	result := &domain.UserChainDao{}
+
+	findResult := chains.FindOne(context.TODO(), filter, options)
+	if err := findResult.Err(); err != nil {
+		return domain.UserChainDao{}, err
+	}
+
+	if err := findResult.Decode(result); err != nil {
+		return domain.UserChainDao{}, err
+	}
+
 	return *result, nil
 }
 
+// HasChain reports whether a chain without a split is stored for users
+func (m MongoClient) HasChain(users []string) (bool, error) {
+	if m.client == nil {
+		return false, errors.New("No connection to MongoDB")
+	}
+
+	users = append([]string(nil), users...)
+	sort.Strings(users)
+
+	chains := m.client.Database("predtext").Collection("chain")
+	count, err := chains.CountDocuments(context.TODO(), chainFilter(domain.UserChainDao{Users: users}))
+	return count > 0, err
+}
+
 // UpsertChain upserts the chain for a set of users
 func (m MongoClient) UpsertChain(users []string, chain domain.Chain) error {
 	if m.client == nil {
@@ -72,31 +130,220 @@ func (m MongoClient) UpsertChain(users []string, chain domain.Chain) error {
 
 	log.Printf("Saving data for %v\n", users)
 
-	data := chain.GetData().ToPrimitive()
+	userChain := chainDao(users, chain)
+	userChain.Data = chain.GetData().ToPrimitive()
+	userChain.Forms = chain.GetForms()
+	userChain.Reverse = chain.GetReverse()
+
+	result, err := m.upsertChainDao(userChain)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("ID: %v", result.UpsertedID)
+
+	return nil
+}
+
+// UpdateChain replaces the chain with id by chain, saved for users, if it
+// is still at version. It returns domain.ErrChainChanged if it is not.
+func (m MongoClient) UpdateChain(id string, version int64, users []string, chain domain.Chain) error {
+	if m.client == nil {
+		return errors.New("No connection to MongoDB")
+	}
 
-	// Get chain collection from redditSim db
 	chains := m.client.Database("predtext").Collection("chain")
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	userChain := chainDao(users, chain)
+	userChain.Data = chain.GetData().ToPrimitive()
+	userChain.Forms = chain.GetForms()
+	userChain.Reverse = chain.GetReverse()
+	userChain.Version = version + 1
+
+	// chains written before versions were added have none
+	var current interface{} = version
+	if version == 0 {
+		current = bson.D{{Key: "$in", Value: bson.A{0, nil}}}
+	}
+	filter := bson.D{
+		{Key: "_id", Value: objectID},
+		{Key: "version", Value: current},
+	}
+	update := bson.D{{Key: "$set", Value: userChain}}
+	result, err := chains.UpdateOne(context.TODO(), filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return domain.ErrChainChanged
+	}
+	return nil
+}
+
+// UpsertExternalChain upserts the settings of chain for a set of users,
+// storing the data and forms read from entries as separate documents
+func (m MongoClient) UpsertExternalChain(users []string, chain domain.Chain,
+	entries domain.ChainEntryReader) error {
+
+	if m.client == nil {
+		return errors.New("No connection to MongoDB")
+	}
+
+	sort.Strings(users)
+
+	log.Printf("Saving external data for %v\n", users)
+
+	userChain := chainDao(users, chain)
+	userChain.External = true
+	if _, err := m.upsertChainDao(userChain); err != nil {
+		return err
+	}
+
+	// find the chain's id whether it was inserted or updated
+	chains := m.client.Database("predtext").Collection("chain")
+	options := &options.FindOneOptions{Projection: bson.D{{Key: "_id", Value: 1}}}
+	findResult := chains.FindOne(context.TODO(), chainFilter(userChain), options)
+	var saved struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := findResult.Decode(&saved); err != nil {
+		return err
+	}
+	id := saved.ID.Hex()
+
+	collection := m.client.Database("predtext").Collection("chainentries")
+	// entries are always found by their chain, so index them by it.
+	// Creating an index that exists does nothing.
+	index := mongo.IndexModel{Keys: bson.D{{Key: "chainid", Value: 1}}}
+	if _, err := collection.Indexes().CreateOne(context.TODO(), index); err != nil {
+		return err
+	}
+	filter := bson.D{{Key: "chainid", Value: id}}
+	if _, err := collection.DeleteMany(context.TODO(), filter); err != nil {
+		return err
+	}
+
+	batch := make([]interface{}, 0, chainEntryBatch)
+	count := 0
+	for {
+		entry, err := entries.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		entry.ChainID = id
+		batch = append(batch, entry)
+		if len(batch) == chainEntryBatch {
+			if _, err := collection.InsertMany(context.TODO(), batch); err != nil {
+				return err
+			}
+			count += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if _, err := collection.InsertMany(context.TODO(), batch); err != nil {
+			return err
+		}
+		count += len(batch)
+	}
+
+	log.Printf("ID: %v (%v entries)", id, count)
+
+	return nil
+}
+
+// chainEntryBatch is the number of chain entries inserted at once
+const chainEntryBatch = 1000
+
+// chainDao returns the stored representation of chain without its
+// data or forms
+func chainDao(users []string, chain domain.Chain) domain.UserChainDao {
 	userChain := domain.UserChainDao{
 		Users:        users,
-		Data:         data,
 		LastModified: time.Now(),
 		PrefixLen:    chain.GetPrefixLen(),
+		Tokenizer: domain.TokenizerDao{
+			Name:   chain.GetTokenizer().Name(),
+			Config: chain.GetTokenizer().Config(),
+		},
+		Segmenter: domain.SegmenterDao{
+			Language: chain.GetSegmenter().Language(),
+			Learned:  chain.GetSegmenter().Learned(),
+		},
+		Weights:  chain.GetWeights(),
+		HalfLife: chain.GetHalfLife(),
+		Epoch:    chain.GetEpoch(),
+		Holdout:  chain.GetHoldout(),
+		Merge:    chain.GetMerge(),
+	}
+	if userChain.Holdout != nil {
+		userChain.Split = userChain.Holdout.Split
 	}
+	return userChain
+}
 
-	// Insert chain as new document
-	filter := bson.D{{Key: "users", Value: users}}
-	update := bson.D{{Key: "$set", Value: userChain}}
+// chainFilter matches the stored chain with the users and split of dao.
+// Each split of the users' text is a separate chain, and chains saved
+// before splits had no split.
+func chainFilter(dao domain.UserChainDao) bson.D {
+	var split interface{} = dao.Split
+	if dao.Split == "" {
+		split = bson.D{{Key: "$in", Value: bson.A{"", nil}}}
+	}
+	return bson.D{
+		{Key: "users", Value: dao.Users},
+		{Key: "split", Value: split},
+	}
+}
+
+// upsertChainDao inserts the chain or replaces the stored chain's fields,
+// incrementing its version
+func (m MongoClient) upsertChainDao(dao domain.UserChainDao) (*mongo.UpdateResult, error) {
+	chains := m.client.Database("predtext").Collection("chain")
+	dao.Version = 0
+	update := bson.D{
+		{Key: "$set", Value: dao},
+		{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+	}
 	isUpsert := true
 	options := &options.UpdateOptions{Upsert: &isUpsert}
 
-	result, err := chains.UpdateOne(context.TODO(), filter, update, options)
+	return chains.UpdateOne(context.TODO(), chainFilter(dao), update, options)
+}
+
+// getChainEntries reads the data and forms of an external chain into dao
+func (m *MongoClient) getChainEntries(id string, dao *domain.UserChainDao) error {
+	collection := m.client.Database("predtext").Collection("chainentries")
+	filter := bson.D{{Key: "chainid", Value: id}}
+
+	cursor, err := collection.Find(context.TODO(), filter)
 	if err != nil {
 		return err
 	}
+	defer cursor.Close(context.TODO())
+
+	dao.Data = make(map[string]map[string]int)
+	dao.Forms = make(map[string]map[string]int)
+	for cursor.Next(context.TODO()) {
+		var entry domain.ChainEntryDao
+		if err := cursor.Decode(&entry); err != nil {
+			return err
+		}
+		if entry.Kind == domain.ChainEntryForms {
+			dao.Forms[entry.Key] = entry.Values
+		} else {
+			dao.Data[entry.Key] = entry.Values
+		}
+	}
 
-	log.Printf("ID: %v", result.UpsertedID)
-
-	return nil
+	return cursor.Err()
 }
 
 // GetPrediction returns a prediction for the given prefix and source
@@ -126,6 +373,7 @@ func (m MongoClient) GetPrediction(prefix, source string) (domain.Prediction, er
 
 	predictionResult := domain.Prediction{}
 	if findResult != nil {
+		predictionResult.Source = result.Source
 		predictionResult.Prefix = result.Prefix
 		predictionResult.Suffixes = result.Suffixes
 	}
@@ -134,7 +382,7 @@ func (m MongoClient) GetPrediction(prefix, source string) (domain.Prediction, er
 }
 
 // UpsertPrediction upserts a prediction in the prediction collection
-// using the prefix as a key
+// using the source and prefix as a key
 func (m MongoClient) UpsertPrediction(prediction domain.Prediction) error {
 	if m.client == nil {
 		return errors.New("No connection to MongoDB")
@@ -143,13 +391,16 @@ func (m MongoClient) UpsertPrediction(prediction domain.Prediction) error {
 	// Get chain collection from redditSim db
 	predictions := m.client.Database("predtext").Collection("predictions")
 	document := domain.PredictionDao{
-		Source:   "",
+		Source:   prediction.Source,
 		Prefix:   prediction.Prefix,
 		Suffixes: prediction.Suffixes,
 	}
 
 	// Insert chain as new document
-	filter := bson.D{{Key: "prefix", Value: prediction.Prefix}}
+	filter := bson.D{
+		{Key: "prefix", Value: prediction.Prefix},
+		{Key: "source", Value: prediction.Source},
+	}
 	update := bson.D{{Key: "$set", Value: document}}
 	isUpsert := true
 	options := &options.UpdateOptions{Upsert: &isUpsert}
@@ -161,3 +412,158 @@ func (m MongoClient) UpsertPrediction(prediction domain.Prediction) error {
 
 	return nil
 }
+
+// InsertFeedback saves an accepted suggestion in the feedback collection
+func (m MongoClient) InsertFeedback(feedback domain.FeedbackDao) error {
+	if m.client == nil {
+		return errors.New("No connection to MongoDB")
+	}
+
+	collection := m.client.Database("predtext").Collection("feedback")
+	_, err := collection.InsertOne(context.TODO(), feedback)
+	return err
+}
+
+// CountFeedbackByClient counts the feedback sent by client since a given time
+func (m MongoClient) CountFeedbackByClient(client string, since time.Time) (int64, error) {
+	if m.client == nil {
+		return 0, errors.New("No connection to MongoDB")
+	}
+
+	collection := m.client.Database("predtext").Collection("feedback")
+	filter := bson.D{
+		{Key: "client", Value: client},
+		{Key: "created", Value: bson.D{{Key: "$gte", Value: since}}},
+	}
+
+	return collection.CountDocuments(context.TODO(), filter)
+}
+
+// GetFeedback returns all feedback recorded for a suggestion following prefix
+func (m MongoClient) GetFeedback(prefix, suggestion string) ([]domain.FeedbackDao, error) {
+	if m.client == nil {
+		return nil, errors.New("No connection to MongoDB")
+	}
+
+	collection := m.client.Database("predtext").Collection("feedback")
+	filter := bson.D{
+		{Key: "prefix", Value: prefix},
+		{Key: "suggestion", Value: suggestion},
+	}
+
+	cursor, err := collection.Find(context.TODO(), filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.TODO())
+
+	results := make([]domain.FeedbackDao, 0)
+	for cursor.Next(context.TODO()) {
+		var feedback struct {
+			ID                 primitive.ObjectID `bson:"_id"`
+			domain.FeedbackDao `bson:",inline"`
+		}
+		if err := cursor.Decode(&feedback); err != nil {
+			return nil, err
+		}
+		feedback.FeedbackDao.ID = feedback.ID.Hex()
+		results = append(results, feedback.FeedbackDao)
+	}
+
+	return results, cursor.Err()
+}
+
+// MarkFeedbackPromoted flags the feedback with the given ids as added to
+// the chain and returns the ids it flagged. Feedback already flagged,
+// such as by another instance, is not returned.
+func (m MongoClient) MarkFeedbackPromoted(ids []string) ([]string, error) {
+	if m.client == nil {
+		return nil, errors.New("No connection to MongoDB")
+	}
+
+	collection := m.client.Database("predtext").Collection("feedback")
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "promoted", Value: true}}}}
+	marked := make([]string, 0, len(ids))
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return marked, err
+		}
+		filter := bson.D{
+			{Key: "_id", Value: objectID},
+			{Key: "promoted", Value: bson.D{{Key: "$ne", Value: true}}},
+		}
+		result, err := collection.UpdateOne(context.TODO(), filter, update)
+		if err != nil {
+			return marked, err
+		}
+		if result.ModifiedCount == 1 {
+			marked = append(marked, id)
+		}
+	}
+	return marked, nil
+}
+
+// UnmarkFeedbackPromoted clears the promoted flag of the feedback with
+// the given ids, so feedback that could not be added is tried again
+func (m MongoClient) UnmarkFeedbackPromoted(ids []string) error {
+	if m.client == nil {
+		return errors.New("No connection to MongoDB")
+	}
+
+	objectIDs := make(bson.A, len(ids))
+	for i, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return err
+		}
+		objectIDs[i] = objectID
+	}
+
+	collection := m.client.Database("predtext").Collection("feedback")
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: objectIDs}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "promoted", Value: false}}}}
+	_, err := collection.UpdateMany(context.TODO(), filter, update)
+	return err
+}
+
+// GetSafetyPolicy returns the safety policy for a chain, and false
+// if it does not have one
+func (m MongoClient) GetSafetyPolicy(chainID string) (domain.SafetyPolicyDao, bool, error) {
+	if m.client == nil {
+		return domain.SafetyPolicyDao{}, false, errors.New("No connection to MongoDB")
+	}
+
+	collection := m.client.Database("predtext").Collection("safety")
+	filter := bson.D{{Key: "chainid", Value: chainID}}
+
+	findResult := collection.FindOne(context.TODO(), filter)
+	if err := findResult.Err(); err == mongo.ErrNoDocuments {
+		return domain.SafetyPolicyDao{}, false, nil
+	} else if err != nil {
+		return domain.SafetyPolicyDao{}, false, err
+	}
+
+	var result domain.SafetyPolicyDao
+	if err := findResult.Decode(&result); err != nil {
+		return domain.SafetyPolicyDao{}, false, err
+	}
+
+	return result, true, nil
+}
+
+// UpsertSafetyPolicy upserts a safety policy using its chain id as a key
+func (m MongoClient) UpsertSafetyPolicy(policy domain.SafetyPolicyDao) error {
+	if m.client == nil {
+		return errors.New("No connection to MongoDB")
+	}
+
+	collection := m.client.Database("predtext").Collection("safety")
+	filter := bson.D{{Key: "chainid", Value: policy.ChainID}}
+	update := bson.D{{Key: "$set", Value: policy}}
+	isUpsert := true
+	options := &options.UpdateOptions{Upsert: &isUpsert}
+
+	_, err := collection.UpdateOne(context.TODO(), filter, update, options)
+	return err
+}
@@ -0,0 +1,97 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// roundTrip stores chain the way UpsertChain does and reads it back
+func roundTrip(t *testing.T, chain Chain) (Chain, bson.Raw) {
+	t.Helper()
+	raw, err := bson.Marshal(storedChain([]string{"user"}, chain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dao domain.UserChainDao
+	if err := bson.Unmarshal(raw, &dao); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ChainFromDao(dao)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return read, raw
+}
+
+func TestChainDaoRoundTrip(t *testing.T) {
+	epoch := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		chain Chain
+	}{
+		{"plain chain", NewChain(2)},
+		{"decay chain", NewDecayChain(2, 24*time.Hour, epoch)},
+		{"reverse chain", NewChain(1).WithReverse()},
+	}
+
+	for _, test := range tests {
+		test.chain.BuildAt(strings.NewReader("The cat sat. The Cat ran!"), epoch.Add(-time.Hour))
+		read, _ := roundTrip(t, test.chain)
+
+		if !reflect.DeepEqual(read.data, test.chain.data) {
+			t.Errorf("%v: counts = %v, want %v", test.name, read.data, test.chain.data)
+		}
+		if !reflect.DeepEqual(read.forms, test.chain.forms) {
+			t.Errorf("%v: forms = %v, want %v", test.name, read.forms, test.chain.forms)
+		}
+		if !reflect.DeepEqual(read.weights, test.chain.weights) {
+			t.Errorf("%v: weights = %v, want %v", test.name, read.weights, test.chain.weights)
+		}
+		if read.halfLife != test.chain.halfLife || !read.epoch.Equal(test.chain.epoch) {
+			t.Errorf("%v: decays by %v from %v, want %v from %v", test.name,
+				read.halfLife, read.epoch, test.chain.halfLife, test.chain.epoch)
+		}
+		if !reflect.DeepEqual(read.reverse, test.chain.reverse) {
+			t.Errorf("%v: reverse counts = %v, want %v", test.name, read.reverse, test.chain.reverse)
+		}
+	}
+}
+
+func TestChainDaoHoldoutRoundTrip(t *testing.T) {
+	split := Split{By: SplitByDocument, Seed: 1, Fraction: 0.5}
+	docs := []Document{{
+		ID:      "doc",
+		User:    "user",
+		Text:    "Held out text.",
+		Created: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	}}
+	chain := NewChain(2).WithHoldout(split, docs)
+
+	read, raw := roundTrip(t, chain)
+	// times are read back in the local time zone
+	got, want := read.holdout, chain.holdout
+	if got == nil || got.Split != want.Split || len(got.Documents) != 1 ||
+		got.Documents[0].Text != want.Documents[0].Text ||
+		!got.Documents[0].Created.Equal(want.Documents[0].Created) {
+		t.Errorf("holdout = %+v, want %+v", got, want)
+	}
+	if got := raw.Lookup("split").StringValue(); got != split.String() {
+		t.Errorf("split = %q, want %q", got, split.String())
+	}
+}
+
+// Stored chains are updated with $set, so a field left out of the
+// document would keep the value of the chain it replaces
+func TestChainDaoClearsFields(t *testing.T) {
+	_, raw := roundTrip(t, NewChain(2))
+	for _, field := range []string{"weights", "halflife", "holdout"} {
+		if _, err := raw.LookupErr(field); err != nil {
+			t.Errorf("a chain without %v does not clear it: %v", field, err)
+		}
+	}
+}
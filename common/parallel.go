@@ -0,0 +1,237 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// parallelChunkSize is the amount of text given to a worker at a time.
+// It is a variable so tests can cross chunk boundaries with less text.
+var parallelChunkSize = 1 << 20
+
+// chunk is a run of the pieces of text ReadText reads, given to a worker
+type chunk struct {
+	index int
+	text  []byte
+}
+
+// chunkResult is what a worker could not count in a chunk because it
+// depends on the text before it
+type chunkResult struct {
+	// head holds the tokens up to and including the first sentence
+	// end, or every token if the chunk has no sentence end
+	head  []string
+	ended bool
+	// tail is the prefix after the chunk if it ended a sentence
+	tail Prefix
+	// open holds the tokens of the last sentence if it did not end in
+	// the chunk, for the reverse chain
+	open []string
+}
+
+// BuildParallel is BuildAt using up to workers goroutines. The text is
+// split into chunks of the pieces BuildAt reads, so long lines are split
+// too, and each worker counts its chunks into a chain of its own from
+// the first sentence end in each chunk, since the prefix there does not
+// depend on earlier text. The tokens before it are counted in order once
+// every chunk is read, so the chain is identical to one made by BuildAt.
+func (c Chain) BuildParallel(r io.Reader, t time.Time, workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	chunks := make(chan chunk, workers)
+	results := make(chan struct {
+		index int
+		chunkResult
+	}, workers)
+	parts := make([]Chain, workers)
+	var wg sync.WaitGroup
+	for i := range parts {
+		parts[i] = c.Empty()
+		// all text has the same time, so weights are added from the
+		// merged counts
+		parts[i].weights = nil
+		wg.Add(1)
+		go func(part Chain) {
+			defer wg.Done()
+			for ch := range chunks {
+				results <- struct {
+					index int
+					chunkResult
+				}{ch.index, part.buildChunk(ch.text)}
+			}
+		}(parts[i])
+	}
+
+	// read chunks while workers count them
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		br := bufio.NewReader(r)
+		for i := 0; ; {
+			text, err := readChunk(br)
+			if len(text) > 0 {
+				chunks <- chunk{index: i, text: text}
+				i++
+			}
+			if err == io.EOF {
+				readErr <- nil
+				return
+			} else if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	heads := make(map[int]chunkResult)
+	for result := range results {
+		heads[result.index] = result.chunkResult
+	}
+	if err := <-readErr; err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		c.mergeCounts(part, t)
+	}
+
+	// count the start of each chunk after the prefix it follows
+	p := NewPrefix(c.prefixLen)
+	var sentence []string
+	for i := 0; i < len(heads); i++ {
+		head := heads[i]
+		for _, token := range head.head {
+			c.add(p.ToString(), token, t, !p.IsEmpty())
+			p.Shift(token)
+			sentence = c.readReverse(sentence, token)
+		}
+		if head.ended {
+			p = head.tail
+			sentence = append(sentence[:0], head.open...)
+		}
+	}
+
+	// the end of the text also ends its last sentence
+	if !p.IsEmpty() {
+		c.add(p.ToString(), util.SentenceEnd, t, false)
+		c.addReverse(sentence)
+	}
+	return nil
+}
+
+// readChunk reads about parallelChunkSize bytes of text, ending where
+// ReadText ends a piece
+func readChunk(br *bufio.Reader) ([]byte, error) {
+	text := make([]byte, 0, parallelChunkSize)
+	for len(text) < parallelChunkSize {
+		piece, err := ReadText(br)
+		text = append(text, piece...)
+		if err != nil {
+			return text, err
+		}
+	}
+	return text, nil
+}
+
+// buildChunk counts the tokens of text after its first sentence end and
+// returns the tokens before it
+func (c Chain) buildChunk(text []byte) chunkResult {
+	var result chunkResult
+	br := bufio.NewReader(bytes.NewReader(text))
+	p := NewPrefix(c.prefixLen)
+	var sentence []string
+	for {
+		// the chunk starts where a piece does, so ReadText splits it
+		// into the same pieces as BuildAt
+		piece, err := ReadText(br)
+		for _, token := range SentenceTokens(c.tokenizer, c.segmenter, piece) {
+			if !result.ended {
+				result.head = append(result.head, token)
+				result.ended = token == util.SentenceEnd
+				continue
+			}
+			c.add(p.ToString(), token, time.Time{}, !p.IsEmpty())
+			p.Shift(token)
+			sentence = c.readReverse(sentence, token)
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	result.tail = p
+	result.open = sentence
+	return result
+}
+
+// mergeCounts adds the counts, forms and reverse counts of other, which
+// has no weights, to the chain as if they were observed at t
+func (c Chain) mergeCounts(other Chain, t time.Time) {
+	if c.weights != nil {
+		// adding the weight once per count sums it the same way as
+		// counting each observation
+		weight := decayFactor(t, c.epoch, c.halfLife)
+		for key, set := range other.data.(SetMap) {
+			for value, count := range set {
+				if _, ok := c.weights[key]; !ok {
+					c.weights[key] = make(WeightSet)
+				}
+				sum := c.weights[key][value]
+				for i := 0; i < count; i++ {
+					sum += weight
+				}
+				c.weights[key][value] = sum
+			}
+		}
+	}
+	c.data.Union(other.data)
+	c.forms.Union(other.forms)
+	if c.reverse != nil {
+		c.reverse.Union(other.reverse)
+	}
+}
+
+// BuildDocuments is BuildAt for each document using up to workers
+// goroutines. Each worker builds a chain from a run of documents and
+// the chains are merged in order. Counts and forms are identical to
+// building the documents in order; decayed weights are summed in a
+// different order, so may differ in their last digits.
+func (c Chain) BuildDocuments(docs []Document, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(docs) {
+		workers = util.MaxInt(len(docs), 1)
+	}
+
+	parts := make([]Chain, workers)
+	var wg sync.WaitGroup
+	for i := range parts {
+		parts[i] = c.Empty()
+		start, end := i*len(docs)/workers, (i+1)*len(docs)/workers
+		wg.Add(1)
+		go func(part Chain, docs []Document) {
+			defer wg.Done()
+			for _, doc := range docs {
+				part.BuildAt(strings.NewReader(doc.Text), doc.Created)
+			}
+		}(parts[i], docs[start:end])
+	}
+	wg.Wait()
+
+	for _, part := range parts {
+		c.Union(part)
+	}
+}
@@ -0,0 +1,147 @@
+package common
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildParallel(t *testing.T) {
+	// small chunks, so many sentences cross the chunk boundaries
+	defer func(size int) { parallelChunkSize = size }(parallelChunkSize)
+	parallelChunkSize = 4096
+	corpus := benchmarkCorpus(1 << 18)
+	// a line longer than maxReadBytes among shorter ones
+	longLine := append(append([]byte(nil), corpus[:1<<16]...),
+		bytes.ReplaceAll(corpus[1<<16:3<<16], []byte("\n"), []byte(" "))...)
+	longLine = append(longLine, corpus[3<<16:]...)
+	noNewlines := bytes.ReplaceAll(corpus, []byte("\n"), []byte(" "))
+	epoch := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	written := epoch.Add(-48 * time.Hour)
+	tests := []struct {
+		name    string
+		chain   Chain
+		corpus  []byte
+		workers int
+	}{
+		{"one worker", NewChain(2), corpus, 1},
+		{"several workers", NewChain(2), corpus, 3},
+		{"reverse chain", NewChain(2).WithReverse(), corpus, 4},
+		{"decay chain", NewDecayChain(3, 24*time.Hour, epoch), corpus, 2},
+		{"long line", NewChain(2).WithReverse(), longLine, 3},
+		{"no newlines", NewChain(2).WithReverse(), noNewlines, 3},
+	}
+
+	for _, test := range tests {
+		want := test.chain.Empty()
+		want.BuildAt(bytes.NewReader(test.corpus), written)
+		got := test.chain.Empty()
+		if err := got.BuildParallel(bytes.NewReader(test.corpus), written, test.workers); err != nil {
+			t.Errorf("%v: BuildParallel() error = %v", test.name, err)
+			continue
+		}
+		compareChains(t, test.name, got, want)
+	}
+}
+
+func TestReadChunk(t *testing.T) {
+	defer func(size int) { parallelChunkSize = size }(parallelChunkSize)
+	parallelChunkSize = 4096
+	corpus := bytes.ReplaceAll(benchmarkCorpus(1<<18), []byte("\n"), []byte(" "))
+	tests := []struct {
+		name   string
+		corpus []byte
+	}{
+		{"no newlines", corpus},
+		{"one word", bytes.Repeat([]byte("a"), 1<<18)},
+	}
+
+	for _, test := range tests {
+		// text without newlines is still read a piece at a time
+		br := bufio.NewReader(bytes.NewReader(test.corpus))
+		var read []byte
+		chunks := 0
+		for {
+			text, err := readChunk(br)
+			if len(text) > parallelChunkSize+2*maxReadBytes {
+				t.Errorf("%v: read a chunk of %v bytes", test.name, len(text))
+			}
+			read = append(read, text...)
+			chunks++
+			if err != nil {
+				break
+			}
+		}
+		if !bytes.Equal(read, test.corpus) {
+			t.Errorf("%v: chunks do not add up to the text", test.name)
+		}
+		if chunks < 2 {
+			t.Errorf("%v: read %v chunks, want several", test.name, chunks)
+		}
+	}
+}
+
+func TestBuildDocuments(t *testing.T) {
+	epoch := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	sentences := strings.Split(string(benchmarkCorpus(1<<16)), ". ")
+	docs := make([]Document, len(sentences))
+	for i, sentence := range sentences {
+		docs[i] = Document{
+			ID:      fmt.Sprint(i),
+			Text:    sentence + ".",
+			Created: epoch.Add(-time.Duration(i) * time.Hour),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		chain   Chain
+		workers int
+	}{
+		{"one worker", NewChain(2), 1},
+		{"several workers", NewChain(2).WithReverse(), 3},
+		{"more workers than documents", NewChain(1), len(docs) + 1},
+		{"decay chain", NewDecayChain(2, 24*time.Hour, epoch), 4},
+	}
+
+	for _, test := range tests {
+		want := test.chain.Empty()
+		for _, doc := range docs {
+			want.BuildAt(strings.NewReader(doc.Text), doc.Created)
+		}
+		got := test.chain.Empty()
+		got.BuildDocuments(docs, test.workers)
+		compareChains(t, test.name, got, want)
+	}
+}
+
+// compareChains reports differences between the counts, forms, reverse
+// counts and weights of two chains
+func compareChains(t *testing.T, name string, got, want Chain) {
+	t.Helper()
+	if !reflect.DeepEqual(got.data, want.data) {
+		t.Errorf("%v: counts differ from a sequential build", name)
+	}
+	if !reflect.DeepEqual(got.forms, want.forms) {
+		t.Errorf("%v: forms differ from a sequential build", name)
+	}
+	if !reflect.DeepEqual(got.reverse, want.reverse) {
+		t.Errorf("%v: reverse counts differ from a sequential build", name)
+	}
+	if len(got.weights) != len(want.weights) {
+		t.Errorf("%v: weights have %v prefixes, want %v", name, len(got.weights), len(want.weights))
+	}
+	for key, set := range want.weights {
+		for value, weight := range set {
+			if math.Abs(got.weights[key][value]-weight) > 1e-9*weight {
+				t.Errorf("%v: weight of %q after %q = %v, want %v",
+					name, value, key, got.weights[key][value], weight)
+			}
+		}
+	}
+}
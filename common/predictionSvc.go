@@ -1,6 +1,8 @@
 package common
 
 import (
+	"errors"
+	"io"
 	"log"
 	"sort"
 
@@ -8,50 +10,126 @@ import (
 	"github.com/zacwhalley/predictivetext/util"
 )
 
+const (
+	predictionDepth   = 2 // arbitrary
+	predictionBreadth = 3
+)
+
 // PredictionSvc is an implementation of the PredictionSvc interface
 type PredictionSvc struct {
 	DB domain.DBClient
+
+	// ChainID is the chain that accepted suggestions are learned into
+	ChainID  string
+	Feedback FeedbackPolicy
+
+	// Tokenizer and Segmenter must match those of the chain the predictions
+	// were generated from. The defaults are used if they are nil.
+	Tokenizer domain.Tokenizer
+	Segmenter domain.Segmenter
+
+	// Model is read instead of the db's prediction set if it is set
+	Model *Model
+
+	// Infiller fills in blanks, which are not filled in if it is nil.
+	// Its suggestions follow the safety policy of InfillChainID.
+	Infiller      *Infiller
+	InfillChainID string
+}
+
+// splitters returns the tokenizer and segmenter used to split input
+func (svc PredictionSvc) splitters() (domain.Tokenizer, domain.Segmenter) {
+	tokenizer, segmenter := svc.Tokenizer, svc.Segmenter
+	if tokenizer == nil {
+		tokenizer = DefaultTokenizer
+	}
+	if segmenter == nil {
+		segmenter = DefaultSegmenter
+	}
+	return tokenizer, segmenter
+}
+
+// makePrefix creates the key for input the same way as the chain did
+func (svc PredictionSvc) makePrefix(input string) Prefix {
+	tokenizer, segmenter := svc.splitters()
+	return MakePrefix(input, 2, tokenizer, segmenter)
 }
 
 // GetPrediction predicts the most likely next words for an input
 func (svc PredictionSvc) GetPrediction(input string) ([]string, error) {
-	key := MakePrefix(input, 2)
-	prediction, err := svc.DB.GetPrediction(key.ToString(), "")
+	key := svc.makePrefix(input)
+	prediction, err := svc.storedPrediction(key.ToString())
 	if err != nil {
 		return nil, err
 	}
 
+	// the policy may have changed since the prediction was generated
+	policy, err := svc.safetyPolicy(svc.ChainID)
+	if err != nil {
+		return nil, err
+	}
+	pairs := policy.Apply(input, prediction.Suffixes)
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return pairs[i].Value > pairs[j].Value
+	})
+
+	// match the user's casing if they are starting a new sentence
+	capitalize := key.IsEmpty() && util.CapitalizesSentences(input)
+
 	suffixes := make([]string, 0)
-	for _, suffix := range prediction.Suffixes {
+	for _, suffix := range pairs {
+		if suffix.Key == "" {
+			// suggestion was the end of a sentence
+			continue
+		}
+		if capitalize {
+			suffix.Key = util.Capitalize(suffix.Key)
+		}
 		suffixes = append(suffixes, suffix.Key)
 	}
 
 	return suffixes, nil
 }
 
+// storedPrediction reads the prediction for key from the model if there
+// is one, or from the db's prediction set for the chain
+func (svc PredictionSvc) storedPrediction(key string) (domain.Prediction, error) {
+	if svc.Model != nil {
+		return svc.Model.GetPrediction(key)
+	}
+	if svc.ChainID == "" {
+		return domain.Prediction{}, errors.New("no chain id to read predictions for")
+	}
+	return svc.DB.GetPrediction(key, svc.ChainID)
+}
+
 // SavePrediction saves a prediction to the db
 func (svc PredictionSvc) SavePrediction(prediction domain.Prediction) error {
 	err := svc.DB.UpsertPrediction(prediction)
 	return err
 }
 
-// GeneratePredictionSet builds the prediction set for a markov chain
+// GeneratePredictionSet builds the prediction set for a markov chain,
+// saved with the chain's id as its source
 func (svc PredictionSvc) GeneratePredictionSet(id string) error {
 	chaindao, err := svc.DB.GetChainByID(id)
 	if err != nil {
 		return err
 	}
-	chainData := MakeSetMap(chaindao.Data)
-	chain := Chain{
-		data:      chainData,
-		prefixLen: chaindao.PrefixLen,
+	chain, err := ChainFromDao(chaindao)
+	if err != nil {
+		return err
+	}
+	chainData := chain.rankData()
+	policy, err := svc.safetyPolicy(id)
+	if err != nil {
+		return err
 	}
 
-	const depth = 2 // arbitrary
-	const breadth = 3
 	count := 0
 	for prefix := range chainData {
-		prediction := predictionFromChain(prefix, chain, depth, breadth)
+		prediction := predictionFromChain(prefix, chain, chainData, policy)
+		prediction.Source = id
 		if err := svc.DB.UpsertPrediction(prediction); err != nil {
 			return err
 		}
@@ -63,19 +141,48 @@ func (svc PredictionSvc) GeneratePredictionSet(id string) error {
 	return nil
 }
 
-func predictionFromChain(key string, chain domain.Chain, depth, breadth int) domain.Prediction {
-	prefixLen := chain.GetPrefixLen()
-	prefix := MakePrefix(key, prefixLen)
-	suffixes := getFollowSet(prefix, chain.GetData(), depth, breadth).ToPairs()
+// CompileModel compiles the predictions of a chain to a model file
+func (svc PredictionSvc) CompileModel(id string, w io.Writer) error {
+	chaindao, err := svc.DB.GetChainByID(id)
+	if err != nil {
+		return err
+	}
+	policy, err := svc.safetyPolicy(id)
+	if err != nil {
+		return err
+	}
 
-	// sort in descending order + return top 3
+	chain, err := ChainFromDao(chaindao)
+	if err != nil {
+		return err
+	}
+	return WriteModel(w, chain, policy)
+}
+
+// predictionFromChain predicts the suffixes of key ranked by rankData
+// and filtered by policy, written in the chain's most common forms
+func predictionFromChain(key string, chain Chain, rankData SetMap, policy SafetyPolicy) domain.Prediction {
+	prefix := ParsePrefix(key, chain.prefixLen)
+	suffixes := getFollowSet(prefix, rankData, predictionDepth, predictionBreadth).ToPairs()
+	suffixes = policy.Apply(prefix.ToString(), suffixes)
+
+	// sort in descending order + return top 3. Ties are broken
+	// alphabetically so the same chain always predicts the same.
 	sort.Slice(suffixes, func(i, j int) bool {
-		return suffixes[i].Value > suffixes[j].Value
+		if suffixes[i].Value != suffixes[j].Value {
+			return suffixes[i].Value > suffixes[j].Value
+		}
+		return suffixes[i].Key < suffixes[j].Key
 	})
 
+	suffixes = suffixes[:util.MinInt(3, len(suffixes))]
+	for i := range suffixes {
+		suffixes[i].Key = chain.Render(suffixes[i].Key)
+	}
+
 	prediction := domain.Prediction{
 		Prefix:   prefix.ToString(),
-		Suffixes: suffixes[:util.MinInt(3, len(suffixes))],
+		Suffixes: suffixes,
 	}
 
 	return prediction
@@ -91,6 +198,12 @@ func getFollowSet(prefix domain.Prefix, chain domain.SetMap, depth, breadth int)
 
 	suffixMap, _ := suffixSet.(Set)
 	for suffix, weight := range suffixMap {
+		if suffix == util.SentenceEnd {
+			// phrases stop at the end of a sentence
+			results[suffix] += weight
+			continue
+		}
+
 		newPrefix := prefix.Copy()
 		newPrefix.Shift(suffix)
 
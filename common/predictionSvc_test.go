@@ -0,0 +1,69 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+func TestGetPrediction(t *testing.T) {
+	db := newFakeDB()
+	db.UpsertPrediction(domain.Prediction{
+		Source:   "chain",
+		Prefix:   "<s> <s>",
+		Suffixes: []domain.Pair{{Key: "a", Value: 1}, {Key: "the", Value: 3}},
+	})
+	db.UpsertPrediction(domain.Prediction{
+		Source:   "other",
+		Prefix:   "<s> <s>",
+		Suffixes: []domain.Pair{{Key: "other", Value: 1}},
+	})
+
+	tests := []struct {
+		name    string
+		chainID string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"start of text", "chain", "", []string{"The", "A"}, false},
+		{"after a capitalized sentence", "chain", "I saw. ", []string{"The", "A"}, false},
+		{"after a lower case sentence", "chain", "i saw. ", []string{"the", "a"}, false},
+		{"another chain", "other", "", []string{"Other"}, false},
+		{"unknown chain", "missing", "", nil, true},
+		{"no chain", "", "", nil, true},
+	}
+
+	for _, test := range tests {
+		svc := PredictionSvc{DB: db, ChainID: test.chainID}
+		got, err := svc.GetPrediction(test.input)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%v: GetPrediction() error = %v, want error %v", test.name, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: GetPrediction() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGeneratePredictionSet(t *testing.T) {
+	db := newFakeDB()
+	chain := NewChain(2)
+	chain.Build(strings.NewReader("The cat sat."))
+	db.chains["set"] = storedChain([]string{"user"}, chain)
+
+	svc := PredictionSvc{DB: db, ChainID: "set"}
+	if err := svc.GeneratePredictionSet("set"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := svc.GetPrediction("The")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"cat sat."}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetPrediction() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// unigramWeight is how much of a word's probability comes from how
+// common it is overall rather than how often it follows the prefix
+const unigramWeight = 0.1
+
+// ChainPredictor predicts directly from a chain, without a prediction set
+type ChainPredictor struct {
+	chain    Chain
+	rankData SetMap
+	unigrams Set
+	total    int
+}
+
+// NewChainPredictor creates a predictor ranking suggestions the same
+// way as the chain's prediction set
+func NewChainPredictor(chain Chain) ChainPredictor {
+	return newChainPredictor(chain, chain.rankData())
+}
+
+// newChainPredictor creates a predictor ranking suggestions by rankData
+func newChainPredictor(chain Chain, rankData SetMap) ChainPredictor {
+	unigrams := make(Set)
+	for _, set := range rankData {
+		unigrams.Union(set)
+	}
+
+	return ChainPredictor{
+		chain:    chain,
+		rankData: rankData,
+		unigrams: unigrams,
+		total:    unigrams.Total(),
+	}
+}
+
+// Predict returns the suggestions that would be saved for key
+func (p ChainPredictor) Predict(key string) ([]string, error) {
+	prediction := predictionFromChain(key, p.chain, p.rankData, SafetyPolicy{})
+	return suggestions(prediction), nil
+}
+
+// Probability estimates the probability of word following key. The
+// chain's estimate is interpolated with an add-one smoothed unigram
+// estimate so words never seen after key are not impossible.
+func (p ChainPredictor) Probability(key, word string) float64 {
+	word = util.Clean(word)
+	unigram := p.unigram(word)
+
+	set, ok := p.rankData[key]
+	if !ok {
+		return unigram
+	}
+	conditional := float64(set[word]) / float64(set.Total())
+	return (1-unigramWeight)*conditional + unigramWeight*unigram
+}
+
+// unigram returns the add-one smoothed probability of a cleaned word
+func (p ChainPredictor) unigram(word string) float64 {
+	vocabulary := len(p.unigrams) + 1 // + 1 for unknown words
+	return float64(p.unigrams[word]+1) / float64(p.total+vocabulary)
+}
+
+// PredictionSetPredictor predicts from the prediction set saved from
+// the chain with the id Source
+type PredictionSetPredictor struct {
+	DB     domain.DBClient
+	Source string
+}
+
+// Predict returns the suggestions saved for key
+func (p PredictionSetPredictor) Predict(key string) ([]string, error) {
+	prediction, err := p.DB.GetPrediction(key, p.Source)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return suggestions(prediction), nil
+}
+
+// suggestions returns the suffixes of a prediction that can be shown
+func suggestions(prediction domain.Prediction) []string {
+	result := make([]string, 0, len(prediction.Suffixes))
+	for _, suffix := range prediction.Suffixes {
+		if suffix.Key != "" {
+			result = append(result, suffix.Key)
+		}
+	}
+	return result
+}
@@ -7,28 +7,78 @@ import (
 	"github.com/zacwhalley/predictivetext/util"
 )
 
-// Prefix is a markov chain prefix of one of more words
+// Prefix is a markov chain prefix of one of more words.
+// Positions before the start of a sentence hold util.SentenceStart.
 type Prefix []string
 
-// MakePrefix creates a prefix of size prefixLen from input
-func MakePrefix(input string, prefixLen int) Prefix {
+// NewPrefix creates a prefix of size prefixLen at the start of a sentence
+func NewPrefix(prefixLen int) Prefix {
 	newPrefix := make(Prefix, prefixLen)
-	words := strings.Split(strings.TrimSpace(input), " ")
+	newPrefix.Clear()
+	return newPrefix
+}
+
+// MakePrefix creates a prefix of size prefixLen from the tokens of the
+// last sentence in input
+func MakePrefix(input string, prefixLen int, tokenizer domain.Tokenizer,
+	segmenter domain.Segmenter) Prefix {
+
+	words := SentenceTokens(tokenizer, segmenter, input)
+	for i := len(words) - 1; i >= 0; i-- {
+		if words[i] == util.SentenceEnd {
+			words = words[i+1:]
+			break
+		}
+	}
+
+	return makePrefix(words, prefixLen)
+}
+
+// ParsePrefix creates a prefix of size prefixLen from a key made by ToString
+func ParsePrefix(key string, prefixLen int) Prefix {
+	return makePrefix(strings.Fields(key), prefixLen)
+}
+
+// makePrefix creates a prefix from the last prefixLen tokens
+func makePrefix(words []string, prefixLen int) Prefix {
+	newPrefix := NewPrefix(prefixLen)
 	limit := util.MaxInt(len(words)-prefixLen, 0)
-	copy(newPrefix, words[limit:])
+	offset := prefixLen - len(words[limit:])
+	for i, word := range words[limit:] {
+		newPrefix[offset+i] = util.Clean(word)
+	}
 
 	return newPrefix
 }
 
-// ToString returns the Prefix as a string (for use as a map key)
+// ToString returns the Prefix as a string (for use as a map key).
+// Words are cleaned as they are added, so they are not cleaned again.
 func (p Prefix) ToString() string {
-	s := strings.Join(p, " ")
-	return strings.ToLower(util.Clean(s))
+	var b strings.Builder
+	for _, word := range p {
+		if word == " " || word == "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(word)
+	}
+	if b.Len() == 0 {
+		return " "
+	}
+	return b.String()
 }
 
-// IsEmpty returns true if ToString returns an empty value
+// IsEmpty returns true if the Prefix has no words since the
+// start of the sentence
 func (p Prefix) IsEmpty() bool {
-	return p.ToString() == " "
+	for _, word := range p {
+		if word != util.SentenceStart && strings.TrimSpace(word) != "" {
+			return false
+		}
+	}
+	return true
 }
 
 // Copy returns a value copy of a prefix
@@ -38,27 +88,31 @@ func (p Prefix) Copy() domain.Prefix {
 	return newPrefix
 }
 
-// Clear removes all words from the Prefix
+// Clear removes all words from the Prefix, leaving it at the
+// start of a sentence
 func (p Prefix) Clear() {
-	p = make([]string, len(p))
+	for i := range p {
+		p[i] = util.SentenceStart
+	}
 }
 
-// Shift removes the first word from the Prefix and appends the given word
+// Shift removes the first word from the Prefix and appends the given word.
+// Shifting util.SentenceEnd clears the Prefix for the next sentence.
 func (p Prefix) Shift(word string) {
-	if util.EndsSentence(p.Last()) {
-		// word ends with one of ?.! -> end of sentence
+	if word == util.SentenceEnd {
 		p.Clear()
-	} else {
-		copy(p, p[1:])
+		return
 	}
+	copy(p, p[1:])
 	p[len(p)-1] = util.Clean(word)
 }
 
-// Reduce removes the last non-empty word from the Prefix with ""
+// Reduce removes the first word from the Prefix, as if it were
+// closer to the start of the sentence
 func (p Prefix) Reduce() {
 	for i := 0; i < len(p); i++ {
-		if p[i] != "" {
-			p[i] = ""
+		if p[i] != util.SentenceStart {
+			p[i] = util.SentenceStart
 			break
 		}
 	}
@@ -0,0 +1,80 @@
+package common
+
+import "testing"
+
+func TestMakePrefix(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", "<s> <s>"},
+		{"The", "<s> the"},
+		{"The cat ran", "cat ran"},
+		{"The cat ran. A", "<s> a"},
+		{"The cat ran. A dog", "a dog"},
+		{"The cat ran.", "<s> <s>"},
+		{"Hello, world", ", world"},
+	}
+
+	for _, test := range tests {
+		got := MakePrefix(test.input, 2, DefaultTokenizer, DefaultSegmenter).ToString()
+		if got != test.want {
+			t.Errorf("MakePrefix(%q) = %q, want %q", test.input, got, test.want)
+		}
+	}
+}
+
+func TestPrefixShift(t *testing.T) {
+	tests := []struct {
+		name      string
+		words     []string
+		want      string
+		wantEmpty bool
+	}{
+		{"start of a sentence", nil, "<s> <s>", true},
+		{"first word", []string{"The"}, "<s> the", false},
+		{"two words", []string{"The", "cat"}, "the cat", false},
+		{"past the length", []string{"The", "cat", "sat"}, "cat sat", false},
+		{"end of a sentence", []string{"The", "cat", "</s>"}, "<s> <s>", true},
+		{"next sentence", []string{"The", "cat", "</s>", "A"}, "<s> a", false},
+	}
+
+	for _, test := range tests {
+		p := NewPrefix(2)
+		for _, word := range test.words {
+			p.Shift(word)
+		}
+		if got := p.ToString(); got != test.want {
+			t.Errorf("%v: prefix = %q, want %q", test.name, got, test.want)
+		}
+		if p.IsEmpty() != test.wantEmpty {
+			t.Errorf("%v: IsEmpty() = %v, want %v", test.name, p.IsEmpty(), test.wantEmpty)
+		}
+		if parsed := ParsePrefix(p.ToString(), 2).ToString(); parsed != test.want {
+			t.Errorf("%v: ParsePrefix() = %q, want %q", test.name, parsed, test.want)
+		}
+	}
+}
+
+func TestPrefixReduce(t *testing.T) {
+	tests := []struct {
+		key  string
+		want string
+		last string
+	}{
+		{"the cat", "<s> cat", "cat"},
+		{"<s> cat", "<s> <s>", ""},
+		{"<s> <s>", "<s> <s>", ""},
+	}
+
+	for _, test := range tests {
+		p := ParsePrefix(test.key, 2)
+		p.Reduce()
+		if got := p.ToString(); got != test.want {
+			t.Errorf("Reduce(%q) = %q, want %q", test.key, got, test.want)
+		}
+		if got := p.Last(); got != test.last {
+			t.Errorf("Last() of %q = %q, want %q", test.want, got, test.last)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// Rough in-memory sizes used to estimate how large a chain is
+const (
+	prefixOverhead = 48 // map entry + Set header for each prefix
+	suffixOverhead = 16 // map entry + count for each suffix
+)
+
+// PruneOptions configures which suffixes are removed from a chain.
+// A zero value for any option disables it.
+type PruneOptions struct {
+	// MinCount removes suffixes seen fewer than MinCount times, or with
+	// a decayed weight below MinCount if the chain has weights
+	MinCount int
+	// TopN keeps only the TopN most common suffixes of each prefix
+	TopN int
+	// MinEntropy removes suffixes whose contribution to the relative
+	// entropy between the chain and a unigram model, in bits, is less
+	// than MinEntropy. These are suffixes the prefix tells us little about.
+	MinEntropy float64
+	// MaxBytes removes the least common suffixes until the chain's
+	// estimated size is at most MaxBytes
+	MaxBytes int
+}
+
+// IsZero returns true if no pruning is configured
+func (opts PruneOptions) IsZero() bool {
+	return opts == PruneOptions{}
+}
+
+// PruneReport describes what was removed by pruning and estimates
+// how much it changes predictions
+type PruneReport struct {
+	PrefixesBefore int
+	PrefixesAfter  int
+	SuffixesBefore int
+	SuffixesAfter  int
+	BytesBefore    int
+	BytesAfter     int
+	// MassRemoved is the fraction of all counts that were removed
+	MassRemoved float64
+	// TopChanged is the fraction of remaining prefixes whose top
+	// predictionBreadth suffixes are no longer the same
+	TopChanged float64
+}
+
+func (r PruneReport) String() string {
+	return fmt.Sprintf("Prefixes: %v -> %v\n"+
+		"Suffixes: %v -> %v\n"+
+		"Estimated size: %v -> %v bytes\n"+
+		"Counts removed: %.2f%%\n"+
+		"Prefixes with changed top %v: %.2f%%",
+		r.PrefixesBefore, r.PrefixesAfter,
+		r.SuffixesBefore, r.SuffixesAfter,
+		r.BytesBefore, r.BytesAfter,
+		r.MassRemoved*100,
+		predictionBreadth, r.TopChanged*100)
+}
+
+// entry is a single prefix/suffix pair in a chain
+type entry struct {
+	key    string
+	suffix string
+	count  int
+}
+
+// Prune removes suffixes from the chain according to opts, applying
+// each configured strategy in the order they are declared in PruneOptions.
+// Suffixes are judged by the counts used to rank them, which are the
+// decayed weights if the chain has them. A reverse chain is pruned by
+// the same options on its own counts; the report covers only the
+// forward chain. Forms of words that are no longer suffixes are dropped.
+func (c Chain) Prune(opts PruneOptions) PruneReport {
+	data := c.data.(SetMap)
+	report := PruneReport{
+		PrefixesBefore: len(data),
+		SuffixesBefore: data.Len(),
+		BytesBefore:    data.EstimateBytes(),
+	}
+	rank, minCount := data, opts.MinCount
+	if c.weights != nil {
+		scale := c.weights.rankScale()
+		rank = c.weights.ToSetMap()
+		minCount = int(math.Ceil(float64(opts.MinCount) * scale))
+	}
+	total := rank.Total()
+	before := rank.topSuffixes(predictionBreadth)
+
+	remove := func(entries []entry) {
+		c.removeAll(entries)
+		if c.weights != nil {
+			for _, e := range entries {
+				rank.Remove(e.key, e.suffix)
+			}
+		}
+	}
+	if opts.MinCount > 0 {
+		remove(rank.belowCount(minCount))
+	}
+	if opts.TopN > 0 {
+		remove(rank.outsideTopN(opts.TopN))
+	}
+	if opts.MinEntropy > 0 {
+		remove(rank.belowEntropy(opts.MinEntropy))
+	}
+	if opts.MaxBytes > 0 {
+		remove(rank.overBudget(opts.MaxBytes))
+	}
+	if c.reverse != nil {
+		c.reverse.prune(opts)
+	}
+	c.dropOrphanedForms()
+
+	report.PrefixesAfter = len(data)
+	report.SuffixesAfter = data.Len()
+	report.BytesAfter = data.EstimateBytes()
+	if total > 0 {
+		report.MassRemoved = 1 - float64(rank.Total())/float64(total)
+	}
+
+	after := rank.topSuffixes(predictionBreadth)
+	changed := 0
+	for key, top := range after {
+		if fmt.Sprint(top) != fmt.Sprint(before[key]) {
+			changed++
+		}
+	}
+	if len(after) > 0 {
+		report.TopChanged = float64(changed) / float64(len(after))
+	}
+
+	return report
+}
+
+// removeAll removes entries from the chain's counts and weights
+func (c Chain) removeAll(entries []entry) {
+	data := c.data.(SetMap)
+	for _, e := range entries {
+		data.Remove(e.key, e.suffix)
+		if c.weights != nil {
+			if set, ok := c.weights[e.key]; ok {
+				delete(set, e.suffix)
+				if len(set) == 0 {
+					delete(c.weights, e.key)
+				}
+			}
+		}
+	}
+}
+
+// dropOrphanedForms removes the forms of words that are no longer a
+// suffix in the chain or its reverse chain
+func (c Chain) dropOrphanedForms() {
+	suffixes := make(map[string]bool)
+	for _, sm := range []SetMap{c.data.(SetMap), c.reverse} {
+		for _, set := range sm {
+			for suffix := range set {
+				suffixes[suffix] = true
+			}
+		}
+	}
+	for word := range c.forms {
+		if !suffixes[word] {
+			delete(c.forms, word)
+		}
+	}
+}
+
+// prune removes suffixes from the SetMap according to opts
+func (sm SetMap) prune(opts PruneOptions) {
+	remove := func(entries []entry) {
+		for _, e := range entries {
+			sm.Remove(e.key, e.suffix)
+		}
+	}
+	if opts.MinCount > 0 {
+		remove(sm.belowCount(opts.MinCount))
+	}
+	if opts.TopN > 0 {
+		remove(sm.outsideTopN(opts.TopN))
+	}
+	if opts.MinEntropy > 0 {
+		remove(sm.belowEntropy(opts.MinEntropy))
+	}
+	if opts.MaxBytes > 0 {
+		remove(sm.overBudget(opts.MaxBytes))
+	}
+}
+
+// Remove removes value from the set associated with key, removing
+// the set if it is left empty
+func (sm SetMap) Remove(key, value string) {
+	set, ok := sm[key]
+	if !ok {
+		return
+	}
+	delete(set, value)
+	if set.IsEmpty() {
+		delete(sm, key)
+	}
+}
+
+// Len returns the number of suffixes in all sets
+func (sm SetMap) Len() int {
+	n := 0
+	for _, set := range sm {
+		n += len(set)
+	}
+	return n
+}
+
+// Total returns the sum of all counts in all sets
+func (sm SetMap) Total() int {
+	total := 0
+	for _, set := range sm {
+		total += set.Total()
+	}
+	return total
+}
+
+// EstimateBytes estimates the memory used by the SetMap
+func (sm SetMap) EstimateBytes() int {
+	bytes := 0
+	for key, set := range sm {
+		bytes += prefixOverhead + len(key)
+		for suffix := range set {
+			bytes += suffixOverhead + len(suffix)
+		}
+	}
+	return bytes
+}
+
+// Total returns the sum of all counts in the set
+func (s Set) Total() int {
+	total := 0
+	for _, count := range s {
+		total += count
+	}
+	return total
+}
+
+// sorted returns the set's pairs in descending order of count,
+// breaking ties alphabetically so the order is stable
+func (s Set) sorted() []entry {
+	entries := make([]entry, 0, len(s))
+	for suffix, count := range s {
+		entries = append(entries, entry{suffix: suffix, count: count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		return entries[i].suffix < entries[j].suffix
+	})
+	return entries
+}
+
+// top returns the n most common keys of the set
+func (s Set) top(n int) []string {
+	sorted := s.sorted()
+	top := make([]string, 0, util.MinInt(n, len(sorted)))
+	for i := 0; i < len(sorted) && i < n; i++ {
+		top = append(top, sorted[i].suffix)
+	}
+	return top
+}
+
+// topSuffixes returns the n most common suffixes of each prefix
+func (sm SetMap) topSuffixes(n int) map[string][]string {
+	top := make(map[string][]string)
+	for key, set := range sm {
+		top[key] = set.top(n)
+	}
+	return top
+}
+
+func (sm SetMap) belowCount(minCount int) []entry {
+	entries := make([]entry, 0)
+	for key, set := range sm {
+		for suffix, count := range set {
+			if count < minCount {
+				entries = append(entries, entry{key, suffix, count})
+			}
+		}
+	}
+	return entries
+}
+
+func (sm SetMap) outsideTopN(n int) []entry {
+	entries := make([]entry, 0)
+	for key, set := range sm {
+		sorted := set.sorted()
+		for i := n; i < len(sorted); i++ {
+			entries = append(entries, entry{key, sorted[i].suffix, sorted[i].count})
+		}
+	}
+	return entries
+}
+
+// belowEntropy finds suffixes whose weighted relative entropy against
+// the unigram distribution of suffixes, p(k,s) * log2(p(s|k) / p(s)),
+// is below minEntropy
+func (sm SetMap) belowEntropy(minEntropy float64) []entry {
+	unigrams := make(Set)
+	for _, set := range sm {
+		unigrams.Union(set)
+	}
+	total := float64(unigrams.Total())
+
+	entries := make([]entry, 0)
+	for key, set := range sm {
+		keyTotal := float64(set.Total())
+		for suffix, count := range set {
+			joint := float64(count) / total
+			conditional := float64(count) / keyTotal
+			unigram := float64(unigrams[suffix]) / total
+			if joint*math.Log2(conditional/unigram) < minEntropy {
+				entries = append(entries, entry{key, suffix, count})
+			}
+		}
+	}
+	return entries
+}
+
+// overBudget finds the least common suffixes that must be removed
+// for the SetMap to fit in maxBytes
+func (sm SetMap) overBudget(maxBytes int) []entry {
+	excess := sm.EstimateBytes() - maxBytes
+	if excess <= 0 {
+		return nil
+	}
+
+	all := make([]entry, 0, sm.Len())
+	for key, set := range sm {
+		for suffix, count := range set {
+			all = append(all, entry{key, suffix, count})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].count != all[j].count {
+			return all[i].count < all[j].count
+		}
+		if all[i].key != all[j].key {
+			return all[i].key < all[j].key
+		}
+		return all[i].suffix < all[j].suffix
+	})
+
+	// removing the last suffix of a prefix also frees the prefix
+	remaining := make(map[string]int)
+	for key, set := range sm {
+		remaining[key] = len(set)
+	}
+
+	entries := make([]entry, 0)
+	for _, e := range all {
+		if excess <= 0 {
+			break
+		}
+		entries = append(entries, e)
+		excess -= suffixOverhead + len(e.suffix)
+		remaining[e.key]--
+		if remaining[e.key] == 0 {
+			excess -= prefixOverhead + len(e.key)
+		}
+	}
+	return entries
+}
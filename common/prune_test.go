@@ -0,0 +1,154 @@
+package common
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPruneDecayChain(t *testing.T) {
+	epoch := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	old := epoch.Add(-10 * 24 * time.Hour)
+	tests := []struct {
+		name string
+		opts PruneOptions
+		want []string
+	}{
+		// "old" was seen most often, but so long ago that it weighs less
+		// than one recent observation
+		{"min count", PruneOptions{MinCount: 1}, []string{"new", "newer"}},
+		{"top n", PruneOptions{TopN: 1}, []string{"newer"}},
+	}
+
+	for _, test := range tests {
+		chain := NewDecayChain(1, 24*time.Hour, epoch)
+		for i := 0; i < 5; i++ {
+			chain.AddAt("a", "old", old)
+		}
+		chain.AddAt("a", "new", epoch)
+		chain.AddAt("a", "newer", epoch)
+		chain.AddAt("a", "newer", epoch)
+
+		chain.Prune(test.opts)
+		set := chain.data.(SetMap)["a"]
+		if len(set) != len(test.want) {
+			t.Errorf("%v: kept %v, want %v", test.name, set, test.want)
+		}
+		for _, suffix := range test.want {
+			if _, ok := set[suffix]; !ok {
+				t.Errorf("%v: %v was pruned", test.name, suffix)
+			}
+			if _, ok := chain.weights["a"][suffix]; !ok {
+				t.Errorf("%v: weight of %v was pruned", test.name, suffix)
+			}
+		}
+	}
+}
+
+func TestPruneReverseChain(t *testing.T) {
+	tests := []struct {
+		name string
+		opts PruneOptions
+		want Set
+	}{
+		{"min count", PruneOptions{MinCount: 2}, Set{"b": 3}},
+		{"top n", PruneOptions{TopN: 2}, Set{"b": 3, "c": 1}},
+	}
+
+	for _, test := range tests {
+		chain := NewChain(1).WithReverse()
+		chain.reverse = SetMap{"a": {"b": 3, "c": 1, "d": 1}}
+
+		chain.Prune(test.opts)
+		if got := chain.reverse["a"]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: reverse chain kept %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name string
+		opts PruneOptions
+		want SetMap
+	}{
+		{"nothing", PruneOptions{}, SetMap{"a": {"x": 5, "y": 1}, "b": {"x": 2, "z": 1}}},
+		{"min count", PruneOptions{MinCount: 2}, SetMap{"a": {"x": 5}, "b": {"x": 2}}},
+		{"top n", PruneOptions{TopN: 1}, SetMap{"a": {"x": 5}, "b": {"x": 2}}},
+		// x is common after both prefixes, so says little about them
+		{"min entropy", PruneOptions{MinEntropy: 0.06}, SetMap{"a": {"y": 1}, "b": {"z": 1}}},
+		{"max bytes", PruneOptions{MaxBytes: 140}, SetMap{"a": {"x": 5}, "b": {"x": 2}}},
+		{"max bytes frees prefixes", PruneOptions{MaxBytes: 100}, SetMap{"a": {"x": 5}}},
+		{"combined", PruneOptions{MinCount: 2, MaxBytes: 100}, SetMap{"a": {"x": 5}}},
+	}
+
+	for _, test := range tests {
+		chain := NewChain(1)
+		chain.data = SetMap{"a": {"x": 5, "y": 1}, "b": {"x": 2, "z": 1}}
+		chain.forms = SetMap{"x": {"X": 7}, "y": {"y": 1}, "z": {"Z": 1}}
+
+		chain.Prune(test.opts)
+		if got := chain.data.(SetMap); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: kept %v, want %v", test.name, got, test.want)
+		}
+		for word := range chain.forms {
+			found := false
+			for _, set := range test.want {
+				_, ok := set[word]
+				found = found || ok
+			}
+			if !found {
+				t.Errorf("%v: kept the form of pruned word %v", test.name, word)
+			}
+		}
+		for _, set := range test.want {
+			for word := range set {
+				if _, ok := chain.forms[word]; !ok {
+					t.Errorf("%v: dropped the form of %v", test.name, word)
+				}
+			}
+		}
+	}
+}
+
+func TestPruneReport(t *testing.T) {
+	chain := NewChain(1)
+	chain.data = SetMap{"a": {"x": 5, "y": 1}, "b": {"x": 2, "z": 1}}
+
+	got := chain.Prune(PruneOptions{MinCount: 2})
+	want := PruneReport{
+		PrefixesBefore: 2,
+		PrefixesAfter:  2,
+		SuffixesBefore: 4,
+		SuffixesAfter:  2,
+		// each prefix is 48 + 1 bytes and each suffix 16 + 1 bytes
+		BytesBefore: 166,
+		BytesAfter:  132,
+		MassRemoved: 2.0 / 9,
+		// the top suffixes of both prefixes lost their second suffix
+		TopChanged: 1,
+	}
+	if math.Abs(got.MassRemoved-want.MassRemoved) > 1e-12 {
+		t.Errorf("MassRemoved = %v, want %v", got.MassRemoved, want.MassRemoved)
+	}
+	got.MassRemoved = want.MassRemoved
+	if got != want {
+		t.Errorf("Prune() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPruneKeepsReverseForms(t *testing.T) {
+	chain := NewChain(1).WithReverse()
+	chain.data = SetMap{"a": {"x": 1}}
+	chain.reverse = SetMap{"x": {"w": 3}}
+	chain.forms = SetMap{"x": {"X": 1}, "w": {"W": 3}}
+
+	chain.Prune(PruneOptions{MinCount: 2})
+	if _, ok := chain.forms["w"]; !ok {
+		t.Error("dropped the form of a word in the reverse chain")
+	}
+	if _, ok := chain.forms["x"]; ok {
+		t.Error("kept the form of a pruned word")
+	}
+}
@@ -0,0 +1,252 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// Actions a safety policy takes on blocked suggestions
+const (
+	SafetyFilter   = "filter"
+	SafetyDownrank = "downrank"
+)
+
+// DefaultSafetyPolicyID is the chain id of the policy used for chains
+// without a policy of their own
+const DefaultSafetyPolicyID = "default"
+
+// defaultSafetyPenalty scales blocked suggestions when a downranking
+// policy does not set a penalty
+const defaultSafetyPenalty = 0.01
+
+// safetyCacheTTL is how long a policy is used before it is read again,
+// so changes made through other instances are picked up
+const safetyCacheTTL = time.Minute
+
+// SafetyPolicy blocks suggestions that contain listed words or phrases
+// or that match listed patterns
+type SafetyPolicy struct {
+	action   string
+	penalty  float64
+	words    map[string]bool
+	phrases  [][]string
+	patterns []*regexp.Regexp
+}
+
+// NewSafetyPolicy compiles the lists of a stored policy. Words and
+// phrases are matched the way chain keys are, ignoring case and
+// punctuation. Patterns are case insensitive regular expressions.
+func NewSafetyPolicy(dao domain.SafetyPolicyDao) (SafetyPolicy, error) {
+	policy := SafetyPolicy{
+		action:  dao.Action,
+		penalty: dao.Penalty,
+		words:   make(map[string]bool),
+	}
+
+	switch policy.action {
+	case "":
+		policy.action = SafetyFilter
+	case SafetyFilter, SafetyDownrank:
+	default:
+		return SafetyPolicy{}, fmt.Errorf("%s is not a valid action", dao.Action)
+	}
+	if policy.penalty < 0 || policy.penalty >= 1 {
+		return SafetyPolicy{}, fmt.Errorf("penalty must be at least 0 and less than 1")
+	}
+	if policy.penalty == 0 {
+		policy.penalty = defaultSafetyPenalty
+	}
+
+	for _, word := range dao.Words {
+		for _, key := range safetyKeys(word) {
+			policy.words[key] = true
+		}
+	}
+	for _, phrase := range dao.Phrases {
+		if keys := safetyKeys(phrase); len(keys) > 0 {
+			policy.phrases = append(policy.phrases, keys)
+		}
+	}
+	for _, pattern := range dao.Patterns {
+		regex, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return SafetyPolicy{}, err
+		}
+		policy.patterns = append(policy.patterns, regex)
+	}
+
+	return policy, nil
+}
+
+// safetyKeys splits text into the keys it is matched by
+func safetyKeys(text string) []string {
+	keys := make([]string, 0)
+	for _, field := range strings.Fields(text) {
+		if key := util.Clean(field); key != " " {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// IsZero returns true if the policy blocks nothing
+func (p SafetyPolicy) IsZero() bool {
+	return len(p.words) == 0 && len(p.phrases) == 0 && len(p.patterns) == 0
+}
+
+// Blocks returns true if suggestion should not follow context. Phrases
+// are blocked if they would be completed by the suggestion.
+func (p SafetyPolicy) Blocks(context, suggestion string) bool {
+	keys := safetyKeys(suggestion)
+	for _, key := range keys {
+		if p.words[key] {
+			return true
+		}
+	}
+
+	all := append(safetyKeys(context), keys...)
+	start := len(all) - len(keys)
+	for _, phrase := range p.phrases {
+		// only look at phrases that end inside the suggestion
+		for end := util.MaxInt(start+1, len(phrase)); end <= len(all); end++ {
+			if strings.Join(all[end-len(phrase):end], " ") == strings.Join(phrase, " ") {
+				return true
+			}
+		}
+	}
+
+	for _, pattern := range p.patterns {
+		if pattern.MatchString(suggestion) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply removes or downranks the blocked suffixes following context.
+// The suffixes must be sorted again after downranking.
+func (p SafetyPolicy) Apply(context string, suffixes []domain.Pair) []domain.Pair {
+	if p.IsZero() {
+		return suffixes
+	}
+
+	result := make([]domain.Pair, 0, len(suffixes))
+	for _, suffix := range suffixes {
+		scale, ok := p.Scale(context, suffix.Key)
+		if !ok {
+			continue
+		}
+		if scale != 1 {
+			suffix.Value = int(float64(suffix.Value) * scale)
+		}
+		result = append(result, suffix)
+	}
+	return result
+}
+
+// Scale returns what the score of suggestion after context is multiplied
+// by, which is the penalty if it is downranked. ok is false if it is
+// removed.
+func (p SafetyPolicy) Scale(context, suggestion string) (scale float64, ok bool) {
+	if !p.Blocks(context, suggestion) {
+		return 1, true
+	}
+	if p.action == SafetyFilter {
+		return 0, false
+	}
+	return p.penalty, true
+}
+
+// cachedSafetyPolicy is a policy and when it was read
+type cachedSafetyPolicy struct {
+	policy SafetyPolicy
+	loaded time.Time
+}
+
+// safetyCache holds the policies read for each chain id
+var safetyCache = struct {
+	sync.Mutex
+	policies map[string]cachedSafetyPolicy
+}{policies: make(map[string]cachedSafetyPolicy)}
+
+// safetyPolicy returns the policy for a chain, falling back to the
+// default policy and then to a policy that blocks nothing
+func (svc PredictionSvc) safetyPolicy(chainID string) (SafetyPolicy, error) {
+	if svc.DB == nil {
+		// serving from a model without a db
+		return SafetyPolicy{}, nil
+	}
+
+	safetyCache.Lock()
+	cached, ok := safetyCache.policies[chainID]
+	safetyCache.Unlock()
+	if ok && time.Since(cached.loaded) < safetyCacheTTL {
+		return cached.policy, nil
+	}
+
+	dao, found, err := svc.DB.GetSafetyPolicy(chainID)
+	if err != nil {
+		return SafetyPolicy{}, err
+	}
+	if !found && chainID != DefaultSafetyPolicyID {
+		if dao, _, err = svc.DB.GetSafetyPolicy(DefaultSafetyPolicyID); err != nil {
+			return SafetyPolicy{}, err
+		}
+	}
+
+	policy, err := NewSafetyPolicy(dao)
+	if err != nil {
+		return SafetyPolicy{}, err
+	}
+
+	safetyCache.Lock()
+	safetyCache.policies[chainID] = cachedSafetyPolicy{policy, time.Now()}
+	safetyCache.Unlock()
+	return policy, nil
+}
+
+// GetSafetyPolicy returns the policy stored for a chain, which is
+// empty if it has none
+func (svc PredictionSvc) GetSafetyPolicy(chainID string) (domain.SafetyPolicyDao, error) {
+	dao, found, err := svc.DB.GetSafetyPolicy(chainID)
+	if err != nil {
+		return domain.SafetyPolicyDao{}, err
+	}
+	if !found {
+		dao = domain.SafetyPolicyDao{ChainID: chainID, Action: SafetyFilter}
+	}
+	return dao, nil
+}
+
+// SetSafetyPolicy validates and stores the policy for a chain. Live
+// predictions use it immediately; precomputed predictions only fill
+// the places of removed suggestions once they are generated again.
+func (svc PredictionSvc) SetSafetyPolicy(dao domain.SafetyPolicyDao) error {
+	if strings.TrimSpace(dao.ChainID) == "" {
+		return fmt.Errorf("%w: chain id must be set", domain.ErrInvalidSafetyPolicy)
+	}
+	if _, err := NewSafetyPolicy(dao); err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrInvalidSafetyPolicy, err)
+	}
+
+	dao.LastModified = time.Now()
+	if err := svc.DB.UpsertSafetyPolicy(dao); err != nil {
+		return err
+	}
+
+	safetyCache.Lock()
+	if dao.ChainID == DefaultSafetyPolicyID {
+		// every chain without its own policy uses the default
+		safetyCache.policies = make(map[string]cachedSafetyPolicy)
+	} else {
+		delete(safetyCache.policies, dao.ChainID)
+	}
+	safetyCache.Unlock()
+	return nil
+}
@@ -0,0 +1,287 @@
+package common
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// abbreviationFiles holds a list of abbreviations for each language,
+// named by its language code
+//
+//go:embed abbreviations/*.txt
+var abbreviationFiles embed.FS
+
+// DefaultLanguage is the language of chains that do not record one
+const DefaultLanguage = "en"
+
+// DefaultSegmenter is used by chains that do not record a segmenter
+var DefaultSegmenter domain.Segmenter = mustSegmenter(DefaultLanguage)
+
+// punktThreshold is the score above which a word is learned as an
+// abbreviation, as suggested by Kiss & Strunk
+const punktThreshold = 0.3
+
+// Segmenter decides which punctuation tokens end sentences using a
+// language's abbreviations and abbreviations learned from text
+type Segmenter struct {
+	language      string
+	abbreviations map[string]bool
+	// ambiguous are abbreviations that are also ordinary words
+	ambiguous map[string]bool
+	learned   []string
+	// ordinals is true if a number followed by a full stop is an ordinal
+	ordinals bool
+}
+
+// NewSegmenter creates the segmenter for a language code, such as "en"
+func NewSegmenter(language string) (Segmenter, error) {
+	file, err := abbreviationFiles.Open("abbreviations/" + language + ".txt")
+	if err != nil {
+		return Segmenter{}, fmt.Errorf("%s is not a supported language", language)
+	}
+	defer file.Close()
+
+	return ReadSegmenter(language, file)
+}
+
+// ReadSegmenter creates a segmenter from a list of abbreviations, one
+// per line. Lines starting with # are comments, lines starting with !
+// are options and lines starting with ~ are abbreviations that are also
+// ordinary words.
+func ReadSegmenter(language string, r io.Reader) (Segmenter, error) {
+	segmenter := Segmenter{
+		language:      language,
+		abbreviations: make(map[string]bool),
+		ambiguous:     make(map[string]bool),
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case line == "!ordinal-numbers":
+			segmenter.ordinals = true
+		case strings.HasPrefix(line, "!"):
+			return Segmenter{}, fmt.Errorf("unknown option %s", line)
+		case strings.HasPrefix(line, "~"):
+			segmenter.ambiguous[abbreviationKey(line[1:])] = true
+		default:
+			segmenter.abbreviations[abbreviationKey(line)] = true
+		}
+	}
+
+	return segmenter, scanner.Err()
+}
+
+func mustSegmenter(language string) Segmenter {
+	segmenter, err := NewSegmenter(language)
+	if err != nil {
+		panic(err)
+	}
+	return segmenter
+}
+
+// SegmenterFromDao creates the segmenter recorded with a chain, or
+// DefaultSegmenter if none is recorded
+func SegmenterFromDao(dao domain.UserChainDao) (domain.Segmenter, error) {
+	if dao.Segmenter.Language == "" {
+		return DefaultSegmenter, nil
+	}
+
+	segmenter, err := NewSegmenter(dao.Segmenter.Language)
+	if err != nil {
+		return nil, err
+	}
+	return segmenter.WithAbbreviations(dao.Segmenter.Learned), nil
+}
+
+// WithAbbreviations returns a copy of the segmenter that also treats
+// words in learned as abbreviations
+func (s Segmenter) WithAbbreviations(learned []string) Segmenter {
+	abbreviations := make(map[string]bool)
+	for word := range s.abbreviations {
+		abbreviations[word] = true
+	}
+	for _, word := range learned {
+		abbreviations[abbreviationKey(word)] = true
+	}
+
+	s.abbreviations = abbreviations
+	s.learned = append(append([]string{}, s.learned...), learned...)
+	return s
+}
+
+// Language returns the language code of the segmenter's abbreviations
+func (s Segmenter) Language() string {
+	return s.language
+}
+
+// Learned returns the abbreviations learned from text
+func (s Segmenter) Learned() []string {
+	return s.learned
+}
+
+// EndsSentence returns true if token ends a sentence, given the tokens
+// before it and the next word after it. next is empty if it is unknown.
+func (s Segmenter) EndsSentence(prev, token, next string) bool {
+	if !util.IsPunctuation(token) || strings.Trim(token, ".!?。！？…") != "" {
+		return false
+	}
+	if strings.ContainsAny(token, "!?。！？") {
+		return true
+	}
+	if strings.HasSuffix(token, "..") || token == "…" {
+		// an ellipsis only ends a sentence if the next word starts one
+		return next == "" || startsUpper(next)
+	}
+
+	// token is a full stop
+	switch {
+	case s.abbreviations[abbreviationKey(prev)]:
+		return false
+	case s.ambiguous[abbreviationKey(prev)]:
+		// as in "Fig. 2" or "Sat. morning", but not "The cat sat."
+		return next == "" || !(startsLower(next) || isNumber(next))
+	case isInitial(prev):
+		// as in J. R. R. Tolkien
+		return false
+	case isNumber(prev):
+		if s.ordinals {
+			return false
+		}
+		// list items like 1. and numbers at the end of a sentence
+		return next == "" || startsUpper(next)
+	}
+	return true
+}
+
+// abbreviationKey is the key an abbreviation is looked up by
+func abbreviationKey(word string) string {
+	return strings.ToLower(strings.TrimSuffix(word, "."))
+}
+
+// isInitial returns true if word is a single upper case letter
+func isInitial(word string) bool {
+	r, size := utf8.DecodeRuneInString(word)
+	return size == len(word) && unicode.IsUpper(r)
+}
+
+// isNumber returns true if word is made of digits
+func isNumber(word string) bool {
+	if word == "" {
+		return false
+	}
+	for _, r := range word {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func startsUpper(word string) bool {
+	r, _ := utf8.DecodeRuneInString(word)
+	return unicode.IsUpper(r)
+}
+
+func startsLower(word string) bool {
+	r, _ := utf8.DecodeRuneInString(word)
+	return unicode.IsLower(r)
+}
+
+// PunktTrainer learns abbreviations from text without supervision,
+// using the collocation of words and full stops as described in Kiss &
+// Strunk's "Unsupervised Multilingual Sentence Boundary Detection"
+type PunktTrainer struct {
+	words   Set // times each word was seen
+	stopped Set // times each word was followed by a full stop
+	tokens  int
+	stops   int
+}
+
+// NewPunktTrainer creates a trainer that has seen no text
+func NewPunktTrainer() *PunktTrainer {
+	return &PunktTrainer{words: make(Set), stopped: make(Set)}
+}
+
+// Train counts the words and full stops in tokens
+func (pt *PunktTrainer) Train(tokens []string) {
+	for i, token := range tokens {
+		pt.tokens++
+		if token == "." {
+			pt.stops++
+		}
+		if !util.IsWord(token) || strings.IndexFunc(token, unicode.IsDigit) >= 0 {
+			continue
+		}
+
+		word := abbreviationKey(token)
+		pt.words[word]++
+		if i+1 < len(tokens) && tokens[i+1] == "." {
+			pt.stopped[word]++
+		}
+	}
+}
+
+// TrainText counts the words and full stops in the text read from r
+func (pt *PunktTrainer) TrainText(tokenizer domain.Tokenizer, r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		text, err := ReadText(br)
+		pt.Train(tokenizer.Tokenize(text))
+		if err != nil {
+			break
+		}
+	}
+}
+
+// Abbreviations returns the words that are most likely abbreviations
+func (pt *PunktTrainer) Abbreviations() []string {
+	if pt.tokens == 0 || pt.stops == 0 {
+		return nil
+	}
+	p := float64(pt.stops) / float64(pt.tokens)
+
+	abbreviations := make([]string, 0)
+	for word, k := range pt.stopped {
+		n := pt.words[word]
+		if float64(k)/float64(n) <= p {
+			// a full stop is no more likely after word than anywhere else
+			continue
+		}
+
+		// log likelihood ratio of a full stop following word at the
+		// usual rate vs. almost always following it
+		logLambda := -2 * (logBinomial(k, n, p) - logBinomial(k, n, 0.99))
+
+		length := float64(utf8.RuneCountInString(strings.Replace(word, ".", "", -1)))
+		lengthFactor := math.Exp(-length)
+		periodFactor := float64(strings.Count(word, ".") + 1)
+		penalty := math.Pow(length, -float64(n-k))
+
+		if logLambda*lengthFactor*periodFactor*penalty >= punktThreshold {
+			abbreviations = append(abbreviations, word)
+		}
+	}
+
+	sort.Strings(abbreviations)
+	return abbreviations
+}
+
+// logBinomial is the log likelihood of k successes in n trials
+// each with probability p
+func logBinomial(k, n int, p float64) float64 {
+	return float64(k)*math.Log(p) + float64(n-k)*math.Log(1-p)
+}
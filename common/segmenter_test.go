@@ -0,0 +1,53 @@
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSentenceTokensEnglish(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"The cat sat. It was happy.", "The cat sat . </s> It was happy . </s>"},
+		{"We met on the sun. Then we left.", "We met on the sun . </s> Then we left . </s>"},
+		{"See Fig. 2 for details.", "See Fig . 2 for details . </s>"},
+		{"Come on Sat. morning.", "Come on Sat . morning . </s>"},
+		{"Ask Dr. Smith.", "Ask Dr . Smith . </s>"},
+		{"He left (at noon.) Then I did.", "He left ( at noon . ) </s> Then I did . </s>"},
+		{"She said \"hi.\" Then she left.", "She said \" hi . \" </s> Then she left . </s>"},
+	}
+
+	for _, test := range tests {
+		tokens := SentenceTokens(WhitespaceTokenizer{}, DefaultSegmenter, test.text)
+		if got := strings.Join(tokens, " "); got != test.want {
+			t.Errorf("SentenceTokens(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestReadSegmenterAmbiguous(t *testing.T) {
+	segmenter, err := ReadSegmenter("test", strings.NewReader("# comment\nabbr\n~word\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		prev, next string
+		want       bool
+	}{
+		{"abbr", "Next", false},
+		{"abbr", "", false},
+		{"word", "Next", true},
+		{"word", "", true},
+		{"word", "next", false},
+		{"word", "2", false},
+		{"other", "next", true},
+	}
+
+	for _, test := range tests {
+		if got := segmenter.EndsSentence(test.prev, ".", test.next); got != test.want {
+			t.Errorf("EndsSentence(%q, \".\", %q) = %v, want %v", test.prev, test.next, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package common
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+// Units a Split holds out text by
+const (
+	SplitByDocument = "document"
+	SplitByUser     = "user"
+)
+
+// Document is one piece of text used to build a chain, such as a file
+// or a comment, and the user who wrote it
+type Document struct {
+	ID      string
+	User    string
+	Text    string
+	Created time.Time
+}
+
+// DocumentsFromDao converts stored documents
+func DocumentsFromDao(daos []domain.DocumentDao) []Document {
+	docs := make([]Document, len(daos))
+	for i, dao := range daos {
+		docs[i] = Document(dao)
+	}
+	return docs
+}
+
+// Split deterministically divides documents into training and held-out
+// text. Each document or user is hashed with Seed, so the same text is
+// always held out regardless of the order it is read in.
+//
+// Either Fraction of the text is held out, or the text is divided into
+// Folds parts and part Fold (from 1) is held out for cross-validation.
+type Split struct {
+	By       string
+	Seed     int64
+	Fraction float64
+	Folds    int
+	Fold     int
+}
+
+// IsZero returns true if no text is held out
+func (s Split) IsZero() bool {
+	return s.Fraction == 0 && s.Folds == 0
+}
+
+// Validate returns an error if the split cannot be used
+func (s Split) Validate() error {
+	switch {
+	case s.IsZero():
+		return nil
+	case s.By != SplitByDocument && s.By != SplitByUser:
+		return fmt.Errorf("cannot split by %s", s.By)
+	case s.Fraction != 0 && s.Folds != 0:
+		return errors.New("only one of a holdout fraction or folds may be set")
+	case s.Fraction < 0 || s.Fraction >= 1:
+		return errors.New("holdout fraction must be between 0 and 1")
+	case s.Folds == 1 || s.Folds < 0:
+		return errors.New("folds must be at least 2")
+	case s.Folds > 0 && (s.Fold < 1 || s.Fold > s.Folds):
+		return fmt.Errorf("fold must be between 1 and %v", s.Folds)
+	}
+	return nil
+}
+
+// String identifies the split, and is empty if no text is held out
+func (s Split) String() string {
+	switch {
+	case s.IsZero():
+		return ""
+	case s.Folds > 0:
+		return fmt.Sprintf("fold %v/%v by %s, seed %v", s.Fold, s.Folds, s.By, s.Seed)
+	default:
+		return fmt.Sprintf("holdout %g by %s, seed %v", s.Fraction, s.By, s.Seed)
+	}
+}
+
+// WithFold returns a copy of the split holding out fold
+func (s Split) WithFold(fold int) Split {
+	s.Fold = fold
+	return s
+}
+
+// HoldsOut returns true if doc belongs to the held-out text
+func (s Split) HoldsOut(doc Document) bool {
+	if s.IsZero() {
+		return false
+	}
+
+	unit := doc.ID
+	if s.By == SplitByUser {
+		unit = doc.User
+	}
+	hash := fnv.New64a()
+	binary.Write(hash, binary.LittleEndian, s.Seed)
+	hash.Write([]byte(unit))
+	sum := mix(hash.Sum64())
+
+	if s.Folds > 0 {
+		return int(sum%uint64(s.Folds)) == s.Fold-1
+	}
+	// the top 53 bits are a uniform float in [0, 1)
+	return float64(sum>>11)/(1<<53) < s.Fraction
+}
+
+// mix spreads the bits of an FNV hash, whose high bits barely change
+// between short inputs, using the finalizer of SplitMix64
+func mix(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// Divide returns the documents to train on and the documents held out
+func (s Split) Divide(docs []Document) (train, test []Document) {
+	train = make([]Document, 0, len(docs))
+	test = make([]Document, 0)
+	for _, doc := range docs {
+		if s.HoldsOut(doc) {
+			test = append(test, doc)
+		} else {
+			train = append(train, doc)
+		}
+	}
+	return train, test
+}
@@ -0,0 +1,124 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func splitDocuments(n int) []Document {
+	docs := make([]Document, n)
+	for i := range docs {
+		docs[i] = Document{
+			ID:   fmt.Sprintf("doc%v", i),
+			User: fmt.Sprintf("user%v", i%20),
+			Text: "text",
+		}
+	}
+	return docs
+}
+
+func TestSplitDeterminism(t *testing.T) {
+	docs := splitDocuments(1000)
+	reversed := make([]Document, len(docs))
+	for i, doc := range docs {
+		reversed[len(docs)-1-i] = doc
+	}
+
+	tests := []struct {
+		name  string
+		split Split
+	}{
+		{"fraction by document", Split{By: SplitByDocument, Seed: 1, Fraction: 0.2}},
+		{"fraction by user", Split{By: SplitByUser, Seed: 1, Fraction: 0.2}},
+		{"fold by document", Split{By: SplitByDocument, Seed: 7, Folds: 5, Fold: 3}},
+	}
+
+	for _, test := range tests {
+		_, test1 := test.split.Divide(docs)
+		_, test2 := test.split.Divide(docs)
+		if !reflect.DeepEqual(test1, test2) {
+			t.Errorf("%v: the same split held out different documents", test.name)
+		}
+
+		_, reversedTest := test.split.Divide(reversed)
+		heldOut := make(map[string]bool)
+		for _, doc := range test1 {
+			heldOut[doc.ID] = true
+		}
+		if len(reversedTest) != len(test1) {
+			t.Errorf("%v: held out %v documents in reverse order, want %v",
+				test.name, len(reversedTest), len(test1))
+		}
+		for _, doc := range reversedTest {
+			if !heldOut[doc.ID] {
+				t.Errorf("%v: %v is only held out in reverse order", test.name, doc.ID)
+			}
+		}
+
+		other := test.split
+		other.Seed++
+		if _, otherTest := other.Divide(docs); reflect.DeepEqual(otherTest, test1) {
+			t.Errorf("%v: a different seed held out the same documents", test.name)
+		}
+	}
+}
+
+func TestSplitFraction(t *testing.T) {
+	docs := splitDocuments(10000)
+	tests := []struct {
+		name     string
+		fraction float64
+	}{
+		{"tenth", 0.1},
+		{"quarter", 0.25},
+		{"half", 0.5},
+	}
+
+	for _, test := range tests {
+		split := Split{By: SplitByDocument, Seed: 42, Fraction: test.fraction}
+		train, held := split.Divide(docs)
+		if len(train)+len(held) != len(docs) {
+			t.Errorf("%v: divided %v documents into %v", test.name, len(docs), len(train)+len(held))
+		}
+		got := float64(len(held)) / float64(len(docs))
+		if math.Abs(got-test.fraction) > 0.02 {
+			t.Errorf("%v: held out %v of the documents, want about %v", test.name, got, test.fraction)
+		}
+	}
+}
+
+func TestSplitFolds(t *testing.T) {
+	docs := splitDocuments(1000)
+	tests := []struct {
+		name string
+		by   string
+	}{
+		{"by document", SplitByDocument},
+		{"by user", SplitByUser},
+	}
+
+	for _, test := range tests {
+		split := Split{By: test.by, Seed: 3, Folds: 4}
+		folds := make(map[string]int)
+		userFolds := make(map[string]int)
+		for fold := 1; fold <= split.Folds; fold++ {
+			_, held := split.WithFold(fold).Divide(docs)
+			for _, doc := range held {
+				folds[doc.ID]++
+				if test.by == SplitByUser {
+					if other, ok := userFolds[doc.User]; ok && other != fold {
+						t.Errorf("%v: %v is in folds %v and %v", test.name, doc.User, other, fold)
+					}
+					userFolds[doc.User] = fold
+				}
+			}
+		}
+		for _, doc := range docs {
+			if folds[doc.ID] != 1 {
+				t.Errorf("%v: %v is held out by %v folds, want 1", test.name, doc.ID, folds[doc.ID])
+			}
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package common
+
+import (
+	"math"
+	"strings"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// countOfCountsMax is the highest count in the count-of-counts histogram
+const countOfCountsMax = 10
+
+// MaxTop is the most entries that statistics, diffs and rankings list
+const MaxTop = 1000
+
+// Stats summarizes what the chain contains, listing the top most
+// common prefixes and suffixes
+func (c Chain) Stats(top int) domain.ChainStats {
+	data := c.data.(SetMap)
+	stats := domain.ChainStats{
+		PrefixLen: c.prefixLen,
+		Prefixes:  len(data),
+	}
+
+	prefixes := make(Set, len(data))
+	suffixes := make(Set)
+	histogram := make([]int, countOfCountsMax+1)
+	var entropy float64
+	for key, set := range data {
+		total := set.Total()
+		prefixes[key] = total
+		stats.Tokens += total
+		entropy += float64(total) * set.entropy()
+
+		for suffix, count := range set {
+			suffixes[suffix] += count
+			if count > countOfCountsMax {
+				stats.CountOfCountsOver++
+			} else {
+				histogram[count]++
+			}
+		}
+	}
+
+	for i, ngrams := range c.ngrams() {
+		order := domain.NGramStats{Order: i + 1, Distinct: len(ngrams)}
+		for _, count := range ngrams {
+			if count == 1 {
+				order.Singletons++
+			}
+		}
+		stats.NGrams = append(stats.NGrams, order)
+	}
+	for count := 1; count <= countOfCountsMax; count++ {
+		stats.CountOfCounts = append(stats.CountOfCounts,
+			domain.CountOfCount{Count: count, NGrams: histogram[count]})
+	}
+
+	stats.Vocabulary = len(suffixes)
+	if _, ok := suffixes[util.SentenceEnd]; ok {
+		stats.Vocabulary--
+	}
+	if highest := stats.NGrams[len(stats.NGrams)-1]; highest.Distinct > 0 {
+		stats.BranchingFactor = float64(highest.Distinct) / float64(len(data))
+		stats.SingletonRatio = float64(highest.Singletons) / float64(highest.Distinct)
+	}
+	if stats.Tokens > 0 {
+		stats.ConditionalEntropy = entropy / float64(stats.Tokens)
+	}
+	stats.TopPrefixes = topCounts(prefixes, top)
+	stats.TopSuffixes = topCounts(suffixes, top)
+	return stats
+}
+
+// ngrams counts the n-grams of each order up to the prefix length plus
+// one, the n-grams of order n at index n-1. The n-grams of each order are
+// the suffixes after the last words of each prefix, so every token ends
+// one n-gram of each order.
+func (c Chain) ngrams() []Set {
+	orders := make([]Set, c.prefixLen+1)
+	for i := range orders {
+		orders[i] = make(Set)
+	}
+	for key, set := range c.data.(SetMap) {
+		words := strings.Fields(key)
+		for suffix, count := range set {
+			for order := range orders {
+				context := words[util.MaxInt(len(words)-order, 0):]
+				ngram := strings.Join(append(context[:len(context):len(context)], suffix), " ")
+				orders[order][ngram] += count
+			}
+		}
+	}
+	return orders
+}
+
+// entropy returns the entropy of the set's distribution in bits
+func (s Set) entropy() float64 {
+	total := float64(s.Total())
+	var entropy float64
+	for _, count := range s {
+		p := float64(count) / total
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// topCounts returns the n most common keys of a set
+func topCounts(s Set, n int) []domain.CountStat {
+	sorted := s.sorted()
+	top := make([]domain.CountStat, 0, util.MinInt(n, len(sorted)))
+	for i := 0; i < len(sorted) && i < n; i++ {
+		top = append(top, domain.CountStat{Key: sorted[i].suffix, Count: sorted[i].count})
+	}
+	return top
+}
+
+// GetChainStats returns the statistics of a stored chain
+func (svc PredictionSvc) GetChainStats(chainID string, top int) (domain.ChainStats, error) {
+	dao, err := svc.DB.GetChainByID(chainID)
+	if err != nil {
+		return domain.ChainStats{}, err
+	}
+	chain, err := ChainFromDao(dao)
+	if err != nil {
+		return domain.ChainStats{}, err
+	}
+	return chain.Stats(top), nil
+}
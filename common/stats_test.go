@@ -0,0 +1,72 @@
+package common
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+func TestStats(t *testing.T) {
+	chain := NewChain(2)
+	chain.data = SetMap{
+		"a b": {"c": 2, "d": 1},
+		"x b": {"c": 1},
+		"b c": {"a": 1, util.SentenceEnd: 1},
+	}
+
+	// "a b" has entropy log2(3) - 2/3 over 3 tokens and "b c" has
+	// entropy 1 over 2, so the mean is 3 log2(3) / 6. Suffixes counted
+	// the same number of times are listed in order.
+	got := chain.Stats(2)
+	want := domain.ChainStats{
+		PrefixLen:  2,
+		Tokens:     6,
+		Vocabulary: 3,
+		Prefixes:   3,
+		NGrams: []domain.NGramStats{
+			// c:3 d:1 a:1 </s>:1
+			{Order: 1, Distinct: 4, Singletons: 3},
+			// b c:3, b d:1, c a:1, c </s>:1
+			{Order: 2, Distinct: 4, Singletons: 3},
+			// a b c:2, a b d:1, x b c:1, b c a:1, b c </s>:1
+			{Order: 3, Distinct: 5, Singletons: 4},
+		},
+		BranchingFactor:    5.0 / 3,
+		ConditionalEntropy: math.Log2(3) / 2,
+		SingletonRatio:     4.0 / 5,
+		TopPrefixes:        []domain.CountStat{{Key: "a b", Count: 3}, {Key: "b c", Count: 2}},
+		TopSuffixes:        []domain.CountStat{{Key: "c", Count: 3}, {Key: util.SentenceEnd, Count: 1}},
+	}
+	// four trigrams were seen once and one twice
+	for count := 1; count <= countOfCountsMax; count++ {
+		want.CountOfCounts = append(want.CountOfCounts, domain.CountOfCount{Count: count})
+	}
+	want.CountOfCounts[0].NGrams, want.CountOfCounts[1].NGrams = 4, 1
+
+	if math.Abs(got.ConditionalEntropy-want.ConditionalEntropy) > 1e-9 ||
+		math.Abs(got.BranchingFactor-want.BranchingFactor) > 1e-9 {
+		t.Errorf("Stats() entropy %v and branching factor %v, want %v and %v",
+			got.ConditionalEntropy, got.BranchingFactor, want.ConditionalEntropy, want.BranchingFactor)
+	}
+	got.ConditionalEntropy, got.BranchingFactor = want.ConditionalEntropy, want.BranchingFactor
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestStatsCountOfCountsOver(t *testing.T) {
+	chain := NewChain(1)
+	chain.data = SetMap{"a": {"b": countOfCountsMax + 1, "c": countOfCountsMax}}
+
+	got := chain.Stats(0)
+	if got.CountOfCountsOver != 1 || got.CountOfCounts[countOfCountsMax-1].NGrams != 1 {
+		t.Errorf("Stats() counts of counts = %v, over %v", got.CountOfCounts, got.CountOfCountsOver)
+	}
+	if got.SingletonRatio != 0 || len(got.TopPrefixes) != 0 {
+		t.Errorf("Stats() singleton ratio %v, top prefixes %v, want none",
+			got.SingletonRatio, got.TopPrefixes)
+	}
+}
@@ -0,0 +1,323 @@
+package common
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// DefaultSyncStripes is the number of stripes used if none are given
+const DefaultSyncStripes = 64
+
+// SyncChain is a Chain that can be read and updated at the same time,
+// such as by request handlers while new text is added. Keys are divided
+// between stripes, each with its own lock, so readers and writers of
+// different keys rarely wait for each other. Keys of the reverse chain,
+// if there is one, are divided between the same stripes.
+type SyncChain struct {
+	prefixLen int
+	tokenizer domain.Tokenizer
+	segmenter domain.Segmenter
+	halfLife  time.Duration
+	epoch     time.Time
+	stripes   []syncStripe
+}
+
+// syncStripe holds the keys and forms of words hashed to one stripe
+type syncStripe struct {
+	sync.RWMutex
+	data    SetMap
+	forms   SetMap
+	weights WeightMap
+	reverse SetMap
+}
+
+// NewSyncChain creates a SyncChain holding a copy of chain, with its
+// keys divided between the given number of stripes
+func NewSyncChain(chain Chain, stripes int) *SyncChain {
+	if stripes < 1 {
+		stripes = DefaultSyncStripes
+	}
+
+	c := &SyncChain{
+		prefixLen: chain.prefixLen,
+		tokenizer: chain.tokenizer,
+		segmenter: chain.segmenter,
+		halfLife:  chain.halfLife,
+		epoch:     chain.epoch,
+		stripes:   make([]syncStripe, stripes),
+	}
+	for i := range c.stripes {
+		c.stripes[i].data = make(SetMap)
+		c.stripes[i].forms = make(SetMap)
+		if chain.weights != nil {
+			c.stripes[i].weights = make(WeightMap)
+		}
+		if chain.reverse != nil {
+			c.stripes[i].reverse = make(SetMap)
+		}
+	}
+	c.Union(chain)
+	return c
+}
+
+// stripeIndex returns the index of the stripe holding key, found by
+// its FNV-1a hash
+func (c *SyncChain) stripeIndex(key string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return int(hash % uint32(len(c.stripes)))
+}
+
+// stripe returns the stripe holding key
+func (c *SyncChain) stripe(key string) *syncStripe {
+	return &c.stripes[c.stripeIndex(key)]
+}
+
+// Get returns a copy of the suffixes of key
+func (c *SyncChain) Get(key string) (domain.Set, bool) {
+	s := c.stripe(key)
+	s.RLock()
+	defer s.RUnlock()
+
+	set, ok := s.data[key]
+	if !ok {
+		return nil, false
+	}
+	return copySet(set), true
+}
+
+// AddAt counts word as a suffix of key, observed at time t. Like
+// Chain.AddAt, it does not count the word in the reverse chain.
+func (c *SyncChain) AddAt(key, word string, t time.Time) {
+	value := util.Clean(word)
+	if value == " " {
+		return
+	}
+
+	// lock the stripes of the key and the word's forms together, in
+	// order, so snapshots see both counts or neither
+	isWord := util.IsWord(value)
+	i, j := c.stripeIndex(key), c.stripeIndex(value)
+	first, second := i, j
+	if !isWord || j == i {
+		second = -1
+	} else if j < i {
+		first, second = j, i
+	}
+	c.stripes[first].Lock()
+	defer c.stripes[first].Unlock()
+	if second >= 0 {
+		c.stripes[second].Lock()
+		defer c.stripes[second].Unlock()
+	}
+
+	s := &c.stripes[i]
+	s.data.Add(key, value)
+	if s.weights != nil {
+		s.weights.Add(key, value, decayFactor(t, c.epoch, c.halfLife))
+	}
+	if isWord {
+		c.stripes[j].forms.Add(value, util.Strip(word))
+	}
+}
+
+// Union merges other into the chain the same way as Chain.Union,
+// locking each stripe once. Snapshots taken while it runs may hold
+// only part of other.
+func (c *SyncChain) Union(other Chain) {
+	scale := 1.0
+	if other.weights != nil {
+		scale = decayFactor(other.epoch, c.epoch, c.halfLife)
+	}
+
+	data := c.group(other.data.(SetMap))
+	forms := c.group(other.forms)
+	reverse := make([][]string, len(c.stripes))
+	if c.stripes[0].reverse != nil && other.reverse != nil {
+		reverse = c.group(other.reverse)
+	}
+	for i := range c.stripes {
+		if len(data[i]) == 0 && len(forms[i]) == 0 && len(reverse[i]) == 0 {
+			continue
+		}
+
+		s := &c.stripes[i]
+		s.Lock()
+		for _, key := range data[i] {
+			set := other.data.(SetMap)[key]
+			if s.weights != nil {
+				if other.weights != nil {
+					for value, weight := range other.weights[key] {
+						s.weights.Add(key, value, weight*scale)
+					}
+				} else {
+					for value, count := range set {
+						s.weights.Add(key, value, float64(count))
+					}
+				}
+			}
+			unionSet(s.data, key, set)
+		}
+		for _, word := range forms[i] {
+			unionSet(s.forms, word, other.forms[word])
+		}
+		for _, key := range reverse[i] {
+			unionSet(s.reverse, key, other.reverse[key])
+		}
+		s.Unlock()
+	}
+}
+
+// group divides the keys of sm by the stripe holding them
+func (c *SyncChain) group(sm SetMap) [][]string {
+	groups := make([][]string, len(c.stripes))
+	for key := range sm {
+		i := c.stripeIndex(key)
+		groups[i] = append(groups[i], key)
+	}
+	return groups
+}
+
+// Build reads text from r into the chain
+func (c *SyncChain) Build(r io.Reader) {
+	c.BuildAt(r, c.epoch)
+}
+
+// BuildAt is Build for text written at time t. The text is built into
+// a chain of its own first, so readers only wait while it is merged.
+func (c *SyncChain) BuildAt(r io.Reader, t time.Time) {
+	built := c.empty()
+	built.BuildAt(r, t)
+	c.Union(built)
+}
+
+// empty returns an empty Chain built the same way as the chain
+func (c *SyncChain) empty() Chain {
+	chain := NewDecayChain(c.prefixLen, c.halfLife, c.epoch).
+		WithTokenizer(c.tokenizer).
+		WithSegmenter(c.segmenter)
+	if c.stripes[0].reverse != nil {
+		chain = chain.WithReverse()
+	}
+	return chain
+}
+
+// Snapshot returns a Chain holding a copy of the chain's data at one
+// point in time. Every stripe is locked while it is copied, so writers
+// wait for the whole copy.
+func (c *SyncChain) Snapshot() Chain {
+	chain := c.empty()
+	// stripes are locked in order, as AddAt locks them
+	for i := range c.stripes {
+		c.stripes[i].RLock()
+	}
+	defer func() {
+		for i := range c.stripes {
+			c.stripes[i].RUnlock()
+		}
+	}()
+
+	for i := range c.stripes {
+		s := &c.stripes[i]
+		for key, set := range s.data {
+			chain.data.(SetMap)[key] = copySet(set)
+		}
+		for word, set := range s.forms {
+			chain.forms[word] = copySet(set)
+		}
+		for key, set := range s.weights {
+			weights := make(WeightSet, len(set))
+			for value, weight := range set {
+				weights[value] = weight
+			}
+			chain.weights[key] = weights
+		}
+		for key, set := range s.reverse {
+			chain.reverse[key] = copySet(set)
+		}
+	}
+	return chain
+}
+
+// GetData returns a copy of the chain's counts
+func (c *SyncChain) GetData() domain.SetMap {
+	return c.Snapshot().data
+}
+
+// GetPrefixLen returns the chain's prefix length
+func (c *SyncChain) GetPrefixLen() int {
+	return c.prefixLen
+}
+
+// GetForms returns a copy of the number of times each word was
+// written in each case
+func (c *SyncChain) GetForms() map[string]map[string]int {
+	return c.Snapshot().GetForms()
+}
+
+// GetTokenizer returns the tokenizer the chain was built with
+func (c *SyncChain) GetTokenizer() domain.Tokenizer {
+	return c.tokenizer
+}
+
+// GetSegmenter returns the segmenter the chain was built with
+func (c *SyncChain) GetSegmenter() domain.Segmenter {
+	return c.segmenter
+}
+
+// GetWeights returns a copy of the decayed suffix weights, or nil if
+// the chain does not decay
+func (c *SyncChain) GetWeights() map[string]map[string]float64 {
+	return c.Snapshot().GetWeights()
+}
+
+// GetHalfLife returns the time it takes a suffix weight to halve
+func (c *SyncChain) GetHalfLife() time.Duration {
+	return c.halfLife
+}
+
+// GetEpoch returns the time the weights were measured at
+func (c *SyncChain) GetEpoch() time.Time {
+	return c.epoch
+}
+
+// GetHoldout returns nil since live chains do not record held-out text
+func (c *SyncChain) GetHoldout() *domain.HoldoutDao {
+	return nil
+}
+
+// GetMerge returns nil since live chains do not record their sources
+func (c *SyncChain) GetMerge() *domain.MergeDao {
+	return nil
+}
+
+// GetReverse returns a copy of the reverse chain, or nil if there is none
+func (c *SyncChain) GetReverse() map[string]map[string]int {
+	return c.Snapshot().GetReverse()
+}
+
+// copySet returns a copy of set
+func copySet(set Set) Set {
+	copied := make(Set, len(set))
+	for key, count := range set {
+		copied[key] = count
+	}
+	return copied
+}
+
+// unionSet adds the counts of set to the set of key in sm, copying it
+// so sm never shares a set with another chain
+func unionSet(sm SetMap, key string, set Set) {
+	if existing, ok := sm[key]; ok {
+		existing.Union(set)
+		return
+	}
+	sm[key] = copySet(set)
+}
@@ -0,0 +1,206 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncWords are the suffixes added in the concurrency tests
+var syncWords = []string{"Alpha", "beta", "Gamma", "delta", "epsilon", "."}
+
+func TestSyncChainConcurrent(t *testing.T) {
+	const writers, adds = 4, 500
+	c := NewSyncChain(NewChain(2), 8)
+	other := NewChain(2)
+	other.Build(strings.NewReader("The cat sat. The dog ran."))
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				key := fmt.Sprintf("k%v k%v", w, i%10)
+				c.AddAt(key, syncWords[i%len(syncWords)], time.Time{})
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			c.Union(other)
+		}
+	}()
+	// readers run until the writers are done
+	done := make(chan bool)
+	var readers sync.WaitGroup
+	for r := 0; r < 2; r++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				c.Get("k0 k1")
+				c.Snapshot()
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	readers.Wait()
+
+	snapshot := c.Snapshot()
+	data := snapshot.data.(SetMap)
+	total := 0
+	for key, set := range data {
+		if strings.HasPrefix(key, "k") {
+			total += set.Total()
+		}
+	}
+	if total != writers*adds {
+		t.Errorf("counted %v additions, want %v", total, writers*adds)
+	}
+	if got := data["the cat"]["sat"]; got != 10 {
+		t.Errorf("count of sat after the cat = %v, want 10", got)
+	}
+	if set, ok := c.Get("k0 k0"); !ok || !reflect.DeepEqual(set, data["k0 k0"]) {
+		t.Errorf("Get(k0 k0) = %v, want %v", set, data["k0 k0"])
+	}
+}
+
+func TestSyncChainSnapshotIsConsistent(t *testing.T) {
+	c := NewSyncChain(NewChain(1), 16)
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				c.AddAt(fmt.Sprintf("k%v", i%50), syncWords[(i+w)%len(syncWords)], time.Time{})
+			}
+		}(w)
+	}
+
+	// every word added is counted as a suffix and as a form together,
+	// so a snapshot taken at one point in time has as many of each
+	for i := 0; i < 50; i++ {
+		snapshot := c.Snapshot()
+		suffixes := make(Set)
+		for _, set := range snapshot.data.(SetMap) {
+			suffixes.Union(set)
+		}
+		for word, forms := range snapshot.forms {
+			if forms.Total() != suffixes[word] {
+				t.Errorf("snapshot has %v forms of %v but %v suffixes", forms.Total(), word, suffixes[word])
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestSyncChainMatchesChain(t *testing.T) {
+	corpus := benchmarkCorpus(20000)
+	epoch := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		chain Chain
+	}{
+		{"counts", NewChain(2)},
+		{"decayed", NewDecayChain(2, 24*time.Hour, epoch)},
+		{"reverse", NewChain(2).WithReverse()},
+	}
+
+	for _, test := range tests {
+		chain := test.chain
+		chain.BuildAt(bytes.NewReader(corpus), epoch.Add(-time.Hour))
+		synced := NewSyncChain(test.chain.Empty(), 8)
+		synced.BuildAt(bytes.NewReader(corpus), epoch.Add(-time.Hour))
+		snapshot := synced.Snapshot()
+
+		if !reflect.DeepEqual(snapshot.data, chain.data) || !reflect.DeepEqual(snapshot.forms, chain.forms) {
+			t.Errorf("%v: counts differ from Chain.BuildAt", test.name)
+		}
+		if !reflect.DeepEqual(snapshot.reverse, chain.reverse) {
+			t.Errorf("%v: reverse chain differs from Chain.BuildAt", test.name)
+		}
+		if (snapshot.weights == nil) != (chain.weights == nil) {
+			t.Errorf("%v: snapshot weights are %v", test.name, snapshot.weights)
+		}
+		for key, set := range chain.weights {
+			for value, weight := range set {
+				if got := snapshot.weights[key][value]; got-weight > 1e-9 || weight-got > 1e-9 {
+					t.Errorf("%v: weight of %v after %v = %v, want %v", test.name, value, key, got, weight)
+				}
+			}
+		}
+
+		// a copy of a built chain keeps its reverse chain
+		if copied := NewSyncChain(chain, 4).Snapshot(); !reflect.DeepEqual(copied.reverse, chain.reverse) {
+			t.Errorf("%v: NewSyncChain did not copy the reverse chain", test.name)
+		}
+	}
+}
+
+func BenchmarkSyncChainGet(b *testing.B) {
+	chain := NewChain(2)
+	chain.Build(bytes.NewReader(benchmarkCorpus(1 << 18)))
+	keys := make([]string, 0)
+	for key := range chain.data.(SetMap) {
+		keys = append(keys, key)
+	}
+	c := NewSyncChain(chain, DefaultSyncStripes)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			c.Get(keys[i%len(keys)])
+		}
+	})
+}
+
+func BenchmarkSyncChainMixed(b *testing.B) {
+	chain := NewChain(2)
+	chain.Build(bytes.NewReader(benchmarkCorpus(1 << 18)))
+	keys := make([]string, 0)
+	for key := range chain.data.(SetMap) {
+		keys = append(keys, key)
+	}
+
+	// one in every writeEvery operations is a write
+	for _, writeEvery := range []int{100, 10, 2} {
+		for _, stripes := range []int{1, DefaultSyncStripes} {
+			name := fmt.Sprintf("writes=1/%v/stripes=%v", writeEvery, stripes)
+			b.Run(name, func(b *testing.B) {
+				c := NewSyncChain(chain, stripes)
+				b.ReportAllocs()
+				b.RunParallel(func(pb *testing.PB) {
+					for i := 0; pb.Next(); i++ {
+						key := keys[i%len(keys)]
+						if i%writeEvery == 0 {
+							c.AddAt(key, syncWords[i%len(syncWords)], time.Time{})
+						} else {
+							c.Get(key)
+						}
+					}
+				})
+			})
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package common
+
+import (
+	"bufio"
+	"unicode"
+)
+
+// maxReadBytes is about the most text read from a line at once, so that
+// text without line breaks is not held in memory all together
+const maxReadBytes = 64 * 1024
+
+// ReadText reads the next line from br. Lines longer than maxReadBytes
+// are read a piece at a time, each ending at a space so that words are
+// not split unless they are themselves longer than maxReadBytes. The
+// error is io.EOF with the last of the text.
+func ReadText(br *bufio.Reader) (string, error) {
+	text := make([]byte, 0)
+	for len(text) < maxReadBytes {
+		line, err := br.ReadSlice('\n')
+		text = append(text, line...)
+		if err != bufio.ErrBufferFull {
+			return string(text), err
+		}
+	}
+
+	// finish the word the limit fell in
+	for n := 0; n < maxReadBytes; {
+		r, size, err := br.ReadRune()
+		if err != nil {
+			return string(text), err
+		}
+		text = append(text, string(r)...)
+		n += size
+		if unicode.IsSpace(r) {
+			break
+		}
+	}
+	return string(text), nil
+}
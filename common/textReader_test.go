@@ -0,0 +1,67 @@
+package common
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestReadText(t *testing.T) {
+	longLine := strings.Repeat("lorem ipsum dolor ", maxReadBytes/6)
+	longWord := strings.Repeat("é", maxReadBytes*2)
+	tests := []struct {
+		name string
+		text string
+		// whole is true if every piece but the last must end in a space
+		whole bool
+	}{
+		{"lines", "one line\nanother line\n", false},
+		{"no final line break", "one line\nlast line", false},
+		{"long line", longLine + "\nend", true},
+		{"long line without line breaks", longLine, true},
+		{"long word", longWord + " end", false},
+	}
+
+	for _, test := range tests {
+		br := bufio.NewReader(strings.NewReader(test.text))
+		pieces := make([]string, 0)
+		for {
+			text, err := ReadText(br)
+			if len(text) > 2*maxReadBytes {
+				t.Errorf("%v: read %v bytes at once", test.name, len(text))
+			}
+			pieces = append(pieces, text)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%v: %v", test.name, err)
+			}
+		}
+
+		if got := strings.Join(pieces, ""); got != test.text {
+			t.Errorf("%v: read %v bytes, want %v", test.name, len(got), len(test.text))
+		}
+		for i, piece := range pieces[:len(pieces)-1] {
+			last := rune(piece[len(piece)-1])
+			if test.whole && !unicode.IsSpace(last) {
+				t.Errorf("%v: piece %v ends in %q", test.name, i, last)
+			}
+		}
+	}
+}
+
+func TestBuildLongLine(t *testing.T) {
+	sentence := "the cat sat on the mat . "
+	text := strings.Repeat(sentence, 3*maxReadBytes/len(sentence))
+
+	long := NewChain(2)
+	long.Build(strings.NewReader(text))
+	lines := NewChain(2)
+	lines.Build(strings.NewReader(strings.ReplaceAll(text, ". ", ".\n")))
+
+	if got, want := long.data.(SetMap).Total(), lines.data.(SetMap).Total(); got != want {
+		t.Errorf("counted %v transitions in one line, want %v", got, want)
+	}
+}
@@ -0,0 +1,179 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// Tokenizer names recorded with chains
+const (
+	WhitespaceTokenizerName   = "whitespace"
+	RegexTokenizerName        = "regex"
+	WordBoundaryTokenizerName = "uax29"
+)
+
+// DefaultTokenPattern matches words with inner apostrophes or full stops,
+// runs of sentence ending punctuation and any other single mark
+const DefaultTokenPattern = `[\p{L}\p{M}\p{N}]+(?:['’.][\p{L}\p{M}\p{N}]+)*|[.!?。！？…]+|[^\s\p{L}\p{M}\p{N}]`
+
+// placeholderPattern matches any placeholder token
+var placeholderPattern = func() *regexp.Regexp {
+	quoted := make([]string, len(util.Placeholders))
+	for i, placeholder := range util.Placeholders {
+		quoted[i] = regexp.QuoteMeta(placeholder)
+	}
+	return regexp.MustCompile(strings.Join(quoted, "|"))
+}()
+
+// DefaultTokenizer is used by chains that do not record a tokenizer
+var DefaultTokenizer domain.Tokenizer = WhitespaceTokenizer{}
+
+// MakeTokenizer creates the tokenizer with the given name and config
+func MakeTokenizer(name string, config map[string]string) (domain.Tokenizer, error) {
+	switch name {
+	case "", WhitespaceTokenizerName:
+		return WhitespaceTokenizer{}, nil
+	case RegexTokenizerName:
+		pattern, ok := config["pattern"]
+		if !ok {
+			pattern = DefaultTokenPattern
+		}
+		return NewRegexTokenizer(pattern)
+	case WordBoundaryTokenizerName:
+		return WordBoundaryTokenizer{}, nil
+	default:
+		return nil, fmt.Errorf("%s is not a valid tokenizer", name)
+	}
+}
+
+// TokenizerFromDao creates the tokenizer recorded with a chain, or
+// DefaultTokenizer if none is recorded
+func TokenizerFromDao(dao domain.UserChainDao) (domain.Tokenizer, error) {
+	return MakeTokenizer(dao.Tokenizer.Name, dao.Tokenizer.Config)
+}
+
+// WhitespaceTokenizer splits text on whitespace, then splits punctuation
+// from the start and end of each word
+type WhitespaceTokenizer struct{}
+
+// Tokenize splits text into tokens
+func (t WhitespaceTokenizer) Tokenize(text string) []string {
+	return tokenizeAround(text, func(text string) []string {
+		tokens := make([]string, 0)
+		for _, word := range strings.Fields(text) {
+			word = util.Filter(word)
+			if word != "" {
+				tokens = append(tokens, util.SplitPunctuation(word)...)
+			}
+		}
+		return tokens
+	})
+}
+
+// Name returns the name the tokenizer is recorded with
+func (t WhitespaceTokenizer) Name() string {
+	return WhitespaceTokenizerName
+}
+
+// Config returns the tokenizer's settings
+func (t WhitespaceTokenizer) Config() map[string]string {
+	return map[string]string{}
+}
+
+// RegexTokenizer returns every match of a pattern as a token
+type RegexTokenizer struct {
+	pattern *regexp.Regexp
+}
+
+// NewRegexTokenizer creates a tokenizer matching tokens with pattern
+func NewRegexTokenizer(pattern string) (RegexTokenizer, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return RegexTokenizer{}, err
+	}
+	return RegexTokenizer{regex}, nil
+}
+
+// Tokenize splits text into tokens
+func (t RegexTokenizer) Tokenize(text string) []string {
+	return tokenizeAround(text, func(text string) []string {
+		return t.pattern.FindAllString(util.Filter(text), -1)
+	})
+}
+
+// Name returns the name the tokenizer is recorded with
+func (t RegexTokenizer) Name() string {
+	return RegexTokenizerName
+}
+
+// Config returns the tokenizer's settings
+func (t RegexTokenizer) Config() map[string]string {
+	return map[string]string{"pattern": t.pattern.String()}
+}
+
+// tokenizeAround tokenizes the text between placeholders with tokenize,
+// keeping each placeholder as a token of its own
+func tokenizeAround(text string, tokenize func(string) []string) []string {
+	tokens := make([]string, 0)
+	last := 0
+	for _, match := range placeholderPattern.FindAllStringIndex(text, -1) {
+		tokens = append(tokens, tokenize(text[last:match[0]])...)
+		tokens = append(tokens, text[match[0]:match[1]])
+		last = match[1]
+	}
+	return append(tokens, tokenize(text[last:])...)
+}
+
+// SentenceTokens tokenizes text, adding util.SentenceEnd at the end of
+// each sentence. Tokens with no text left once cleaned are dropped.
+func SentenceTokens(tokenizer domain.Tokenizer, segmenter domain.Segmenter, text string) []string {
+	tokens := make([]string, 0)
+	for _, token := range tokenizer.Tokenize(text) {
+		if util.Clean(token) != " " {
+			tokens = append(tokens, token)
+		}
+	}
+
+	result := make([]string, 0, len(tokens))
+	ended := false
+	inQuote := false
+	for i, token := range tokens {
+		closing := util.IsClosingQuote(token) || (token == `"` && inQuote)
+		if ended && !closing {
+			result = append(result, util.SentenceEnd)
+			ended = false
+		}
+
+		result = append(result, token)
+		if token == `"` {
+			inQuote = !inQuote
+		}
+
+		prev := ""
+		if i > 0 {
+			prev = tokens[i-1]
+		}
+		if !ended && segmenter.EndsSentence(prev, token, nextWord(tokens, i)) {
+			ended = true
+		}
+	}
+	if ended {
+		result = append(result, util.SentenceEnd)
+	}
+
+	return result
+}
+
+// nextWord returns the first word after tokens[i], or "" if there is none
+func nextWord(tokens []string, i int) string {
+	for _, token := range tokens[i+1:] {
+		if util.IsWord(token) {
+			return token
+		}
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zacwhalley/predictivetext/domain"
+)
+
+func TestTokenizerFromDao(t *testing.T) {
+	tests := []struct {
+		name    string
+		dao     domain.TokenizerDao
+		want    string
+		wantErr bool
+	}{
+		{"not recorded", domain.TokenizerDao{}, WhitespaceTokenizerName, false},
+		{"whitespace", domain.TokenizerDao{Name: WhitespaceTokenizerName}, WhitespaceTokenizerName, false},
+		{"regex", domain.TokenizerDao{Name: RegexTokenizerName}, RegexTokenizerName, false},
+		{"unknown", domain.TokenizerDao{Name: "nonsense"}, "", true},
+		{"invalid pattern", domain.TokenizerDao{
+			Name:   RegexTokenizerName,
+			Config: map[string]string{"pattern": "("},
+		}, "", true},
+	}
+
+	for _, test := range tests {
+		tokenizer, err := TokenizerFromDao(domain.UserChainDao{Tokenizer: test.dao})
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%v: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+		} else if tokenizer.Name() != test.want {
+			t.Errorf("%v: tokenizer is %v, want %v", test.name, tokenizer.Name(), test.want)
+		}
+	}
+}
+
+func TestSentenceTokens(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"Hello, world", []string{"Hello", ",", "world"}},
+		{"It ended. Then", []string{"It", "ended", ".", "</s>", "Then"}},
+		{"Really?! Yes", []string{"Really", "?!", "</s>", "Yes"}},
+		{"(It ended.) Then", []string{"(", "It", "ended", ".", ")", "</s>", "Then"}},
+		{`He said "hi." Then`, []string{"He", "said", `"`, "hi", ".", `"`, "</s>", "Then"}},
+		{"Wait... ok", []string{"Wait", "...", "ok"}},
+	}
+
+	for _, test := range tests {
+		got := SentenceTokens(DefaultTokenizer, DefaultSegmenter, test.in)
+		if strings.Join(got, " ") != strings.Join(test.want, " ") {
+			t.Errorf("SentenceTokens(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package common
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// WordBoundaryTokenizer splits text at the word boundaries defined by
+// Unicode Standard Annex #29, so words in scripts without spaces and
+// words joined by apostrophes or decimal points are split correctly
+type WordBoundaryTokenizer struct{}
+
+// wordBreak is a simplified Word_Break property from UAX #29
+type wordBreak int
+
+const (
+	wbOther wordBreak = iota
+	wbNewline
+	wbSpace
+	wbExtend
+	wbALetter
+	wbNumeric
+	wbKatakana
+	wbExtendNumLet
+	wbMidLetter
+	wbMidNum
+	wbMidNumLet
+)
+
+func wordBreakOf(r rune) wordBreak {
+	switch {
+	case r == '\r' || r == '\n' || r == '\v' || r == '\f' ||
+		r == 0x85 || r == 0x2028 || r == 0x2029:
+		return wbNewline
+	case r == '\t' || unicode.Is(unicode.Zs, r):
+		return wbSpace
+	case r == 0x200D || unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc, unicode.Cf):
+		return wbExtend
+	case unicode.Is(unicode.Katakana, r):
+		return wbKatakana
+	case unicode.In(r, unicode.Han, unicode.Hiragana):
+		// ideographs are each a word of their own
+		return wbOther
+	case unicode.IsLetter(r):
+		return wbALetter
+	case unicode.Is(unicode.Nd, r):
+		return wbNumeric
+	case unicode.Is(unicode.Pc, r):
+		return wbExtendNumLet
+	case strings.ContainsRune(":··״‧︓﹕：", r):
+		return wbMidLetter
+	case strings.ContainsRune(",;;։،؍٬߸⁄︐︔﹐﹔，；", r):
+		return wbMidNum
+	case strings.ContainsRune(".'‘’․﹒＇．", r):
+		return wbMidNumLet
+	}
+	return wbOther
+}
+
+// Tokenize splits text into tokens
+func (t WordBoundaryTokenizer) Tokenize(text string) []string {
+	return tokenizeAround(text, t.tokenize)
+}
+
+func (t WordBoundaryTokenizer) tokenize(text string) []string {
+	runes := []rune(util.Filter(text))
+	breaks := make([]wordBreak, len(runes))
+	for i, r := range runes {
+		breaks[i] = wordBreakOf(r)
+	}
+
+	tokens := make([]string, 0)
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		if i < len(runes) && !isWordBoundary(breaks, i) {
+			continue
+		}
+
+		token := string(runes[start:i])
+		start = i
+		if strings.TrimSpace(token) == "" {
+			continue
+		}
+
+		// keep runs like ... and ?! together
+		last := len(tokens) - 1
+		if last >= 0 && util.IsPunctuation(tokens[last]+token) &&
+			util.IsPunctuation(tokens[last]) && util.IsPunctuation(token) {
+			tokens[last] += token
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens
+}
+
+// Name returns the name the tokenizer is recorded with
+func (t WordBoundaryTokenizer) Name() string {
+	return WordBoundaryTokenizerName
+}
+
+// Config returns the tokenizer's settings
+func (t WordBoundaryTokenizer) Config() map[string]string {
+	return map[string]string{}
+}
+
+// isWordBoundary returns true if there is a word boundary between
+// the runes at i-1 and i
+func isWordBoundary(breaks []wordBreak, i int) bool {
+	next := breaks[i]
+	prev := breaks[i-1]
+
+	// WB3 - WB3d: line breaks and runs of spaces
+	if prev == wbNewline || next == wbNewline {
+		return !(prev == wbNewline && next == wbNewline)
+	}
+	if prev == wbSpace && next == wbSpace {
+		return false
+	}
+
+	// WB4: extending characters belong to the character before them
+	if next == wbExtend {
+		return prev == wbSpace
+	}
+	p := skipExtend(breaks, i-1)
+	if p < 0 {
+		return true
+	}
+	prev = breaks[p]
+
+	after := wbOther
+	if n := skipExtendForward(breaks, i+1); n < len(breaks) {
+		after = breaks[n]
+	}
+	before := wbOther
+	if pp := skipExtend(breaks, p-1); pp >= 0 {
+		before = breaks[pp]
+	}
+
+	isMid := func(b wordBreak) bool { return b == wbMidLetter || b == wbMidNumLet }
+	isMidNum := func(b wordBreak) bool { return b == wbMidNum || b == wbMidNumLet }
+	isWordChar := func(b wordBreak) bool {
+		return b == wbALetter || b == wbNumeric || b == wbKatakana
+	}
+
+	switch {
+	case prev == wbALetter && next == wbALetter: // WB5
+		return false
+	case prev == wbALetter && isMid(next) && after == wbALetter: // WB6
+		return false
+	case before == wbALetter && isMid(prev) && next == wbALetter: // WB7
+		return false
+	case prev == wbNumeric && next == wbNumeric: // WB8
+		return false
+	case prev == wbALetter && next == wbNumeric: // WB9
+		return false
+	case prev == wbNumeric && next == wbALetter: // WB10
+		return false
+	case before == wbNumeric && isMidNum(prev) && next == wbNumeric: // WB11
+		return false
+	case prev == wbNumeric && isMidNum(next) && after == wbNumeric: // WB12
+		return false
+	case prev == wbKatakana && next == wbKatakana: // WB13
+		return false
+	case (isWordChar(prev) || prev == wbExtendNumLet) && next == wbExtendNumLet: // WB13a
+		return false
+	case prev == wbExtendNumLet && isWordChar(next): // WB13b
+		return false
+	}
+
+	return true // WB999
+}
+
+// skipExtend returns the index of the last character at or before i
+// that is not an extending character
+func skipExtend(breaks []wordBreak, i int) int {
+	for i >= 0 && breaks[i] == wbExtend {
+		i--
+	}
+	return i
+}
+
+// skipExtendForward returns the index of the first character at or
+// after i that is not an extending character
+func skipExtendForward(breaks []wordBreak, i int) int {
+	for i < len(breaks) && breaks[i] == wbExtend {
+		i++
+	}
+	return i
+}
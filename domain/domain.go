@@ -1,15 +1,36 @@
 package domain
 
 import (
+	"errors"
 	"io"
 	"time"
 )
 
+// ErrFeedbackLimit is returned when a client has sent too much feedback
+var ErrFeedbackLimit = errors.New("feedback limit reached for client")
+
+// ErrInvalidSafetyPolicy is returned when a safety policy cannot be used
+var ErrInvalidSafetyPolicy = errors.New("invalid safety policy")
+
+// ErrChainChanged is returned when a chain is updated by someone else
+// between being read and being written back
+var ErrChainChanged = errors.New("chain changed since it was read")
+
+// ErrNoPrediction is returned when a model has no prediction for a prefix
+var ErrNoPrediction = errors.New("no prediction for prefix")
+
 // PredictionSvc is a service for generating predictions
 type PredictionSvc interface {
 	GetPrediction(input string) ([]string, error)
 	SavePrediction(Prediction) error
 	GeneratePredictionSet(input string) error
+	RecordSelection(input, suggestion, client string) error
+	GetSafetyPolicy(chainID string) (SafetyPolicyDao, error)
+	SetSafetyPolicy(policy SafetyPolicyDao) error
+	GetChainStats(chainID string, top int) (ChainStats, error)
+	MergeChains(users []string, sources []ChainSourceDao, normalization string, overwrite bool) error
+	GetDistinctivePhrases(chainID, backgroundID, method string, top, minCount int) (DistinctivePhrases, error)
+	Infill(input string, n int) ([]InfillCandidate, error)
 }
 
 // Set counts occurrences of strings
@@ -35,10 +56,38 @@ type SetMap interface {
 type Chain interface {
 	GetData() SetMap
 	GetPrefixLen() int
+	GetForms() map[string]map[string]int
+	GetTokenizer() Tokenizer
+	GetSegmenter() Segmenter
+	GetWeights() map[string]map[string]float64
+	GetHalfLife() time.Duration
+	GetEpoch() time.Time
+	GetHoldout() *HoldoutDao
+	GetMerge() *MergeDao
+	GetReverse() map[string]map[string]int
 	Get(key string) (Set, bool)
 	Build(r io.Reader)
 }
 
+// Tokenizer splits text into the words and punctuation a chain is built from
+type Tokenizer interface {
+	Tokenize(text string) []string
+	Name() string
+	Config() map[string]string
+}
+
+// Segmenter decides which punctuation tokens end sentences
+type Segmenter interface {
+	EndsSentence(prev, token, next string) bool
+	Language() string
+	Learned() []string
+}
+
+// Predictor suggests the next words after a chain key, most likely first
+type Predictor interface {
+	Predict(key string) ([]string, error)
+}
+
 // Prefix is a markov chain prefix of one or more words
 type Prefix interface {
 	ToString() string
@@ -53,15 +102,118 @@ type Prefix interface {
 // DBClient is an interface for database access
 type DBClient interface {
 	GetChainByID(id string) (UserChainDao, error)
+	GetChainInfoByID(id string) (UserChainDao, error)
+	HasChain(users []string) (bool, error)
 	UpsertChain(users []string, chain Chain) error
+	UpdateChain(id string, version int64, users []string, chain Chain) error
+	UpsertExternalChain(users []string, chain Chain, entries ChainEntryReader) error
 	GetPrediction(prefix, source string) (Prediction, error)
 	UpsertPrediction(prediction Prediction) error
+	InsertFeedback(feedback FeedbackDao) error
+	CountFeedbackByClient(client string, since time.Time) (int64, error)
+	GetFeedback(prefix, suggestion string) ([]FeedbackDao, error)
+	MarkFeedbackPromoted(ids []string) ([]string, error)
+	UnmarkFeedbackPromoted(ids []string) error
+	GetSafetyPolicy(chainID string) (SafetyPolicyDao, bool, error)
+	UpsertSafetyPolicy(policy SafetyPolicyDao) error
 }
 
 // PredictionResponse is the Dto for returning a prediction
 type PredictionResponse struct {
-	Input       string   `json:"input"`
-	Predictions []string `json:"predictions"`
+	Input       string       `json:"input"`
+	Predictions []string     `json:"predictions"`
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+// Suggestion is the Dto for one prediction and how to add it to the input.
+// Attach is true if it should follow the input without a space.
+type Suggestion struct {
+	Text   string `json:"text"`
+	Attach bool   `json:"attach"`
+}
+
+// InfillResponse is the Dto for returning words to fill in a blank
+type InfillResponse struct {
+	Input      string            `json:"input"`
+	Candidates []InfillCandidate `json:"candidates"`
+}
+
+// InfillCandidate is the Dto for one word to fill in a blank. Forward and
+// Backward are its probabilities from the words before and after the
+// blank, and Score its probability given both.
+type InfillCandidate struct {
+	Word     string  `json:"word"`
+	Score    float64 `json:"score"`
+	Forward  float64 `json:"forward"`
+	Backward float64 `json:"backward"`
+}
+
+// FeedbackRequest is the Dto for reporting an accepted suggestion
+type FeedbackRequest struct {
+	Input      string `json:"input"`
+	Suggestion string `json:"suggestion"`
+}
+
+// ChainStats is the Dto for what a chain contains. Sentence starts and
+// ends count as tokens in n-grams.
+type ChainStats struct {
+	PrefixLen int `json:"prefixLen"`
+	// Tokens is the number of suffixes counted
+	Tokens int `json:"tokens"`
+	// Vocabulary is the number of distinct words, not counting sentence ends
+	Vocabulary int          `json:"vocabulary"`
+	Prefixes   int          `json:"prefixes"`
+	NGrams     []NGramStats `json:"ngrams"`
+	// CountOfCounts is the number of n-grams of the chain's highest order
+	// seen each number of times, up to 10
+	CountOfCounts []CountOfCount `json:"countOfCounts"`
+	// CountOfCountsOver is the number seen more than the last count
+	CountOfCountsOver int `json:"countOfCountsOver"`
+	// BranchingFactor is the average number of distinct suffixes of a prefix
+	BranchingFactor float64 `json:"branchingFactor"`
+	// ConditionalEntropy is the entropy of a suffix given its prefix in bits
+	ConditionalEntropy float64 `json:"conditionalEntropy"`
+	// SingletonRatio is the fraction of the highest order n-grams seen once
+	SingletonRatio float64     `json:"singletonRatio"`
+	TopPrefixes    []CountStat `json:"topPrefixes"`
+	TopSuffixes    []CountStat `json:"topSuffixes"`
+}
+
+// NGramStats counts the distinct n-grams of one order, and how many
+// of them were seen once
+type NGramStats struct {
+	Order      int `json:"order"`
+	Distinct   int `json:"distinct"`
+	Singletons int `json:"singletons"`
+}
+
+// CountOfCount is the number of n-grams seen Count times
+type CountOfCount struct {
+	Count  int `json:"count"`
+	NGrams int `json:"ngrams"`
+}
+
+// CountStat is how many times a key was counted
+type CountStat struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// DistinctivePhrases is the Dto for the words and phrases a chain uses
+// more often than a background chain, most distinctive first
+type DistinctivePhrases struct {
+	Method  string              `json:"method"`
+	Words   []DistinctivePhrase `json:"words"`
+	Phrases []DistinctivePhrase `json:"phrases"`
+}
+
+// DistinctivePhrase is how distinctive a word or phrase is, and how many
+// times it was counted in the chain and the background
+type DistinctivePhrase struct {
+	Phrase          string  `json:"phrase"`
+	Score           float64 `json:"score"`
+	Count           int     `json:"count"`
+	BackgroundCount int     `json:"backgroundCount"`
 }
 
 // PredictionDao is the data access object / schema for a prediction
@@ -73,6 +225,8 @@ type PredictionDao struct {
 
 // Prediction is a struct containing
 type Prediction struct {
+	// Source is the id of the chain the prediction was generated from
+	Source   string
 	Prefix   string
 	Suffixes []Pair
 }
@@ -83,6 +237,127 @@ type UserChainDao struct {
 	Data         map[string]map[string]int `bson:"data"`
 	PrefixLen    int                       `bson:"prefixlen"`
 	LastModified time.Time                 `bson:"lastmodified"`
+	Forms        map[string]map[string]int `bson:"forms"`
+	Tokenizer    TokenizerDao              `bson:"tokenizer"`
+	Segmenter    SegmenterDao              `bson:"segmenter"`
+
+	// Weights are the decayed suffix weights at Epoch, if HalfLife is set
+	Weights  map[string]map[string]float64 `bson:"weights"`
+	HalfLife time.Duration                 `bson:"halflife"`
+	Epoch    time.Time                     `bson:"epoch"`
+
+	// Split identifies the part of the users' text the chain was built
+	// from. It is empty if the chain was built from all of it.
+	Split   string      `bson:"split"`
+	Holdout *HoldoutDao `bson:"holdout"`
+
+	// External is true if Data and Forms are too large for one document,
+	// so are stored as a ChainEntryDao for each key
+	External bool `bson:"external"`
+
+	// Merge records the chains this chain was merged from, or is nil if
+	// it was built from text
+	Merge *MergeDao `bson:"merge"`
+
+	// Reverse maps the words after each word to it, or is nil if the
+	// chain was built without a reverse chain
+	Reverse map[string]map[string]int `bson:"reverse"`
+
+	// Version is incremented on every write, so a chain read and
+	// changed is only written back if no one else wrote it since
+	Version int64 `bson:"version,omitempty"`
+}
+
+// Kinds of chain entry
+const (
+	ChainEntryData  = "data"
+	ChainEntryForms = "forms"
+)
+
+// ChainEntryDao is the data access object / schema for one key of the
+// data or forms of an external chain
+type ChainEntryDao struct {
+	ChainID string         `bson:"chainid"`
+	Kind    string         `bson:"kind"`
+	Key     string         `bson:"key"`
+	Values  map[string]int `bson:"values"`
+}
+
+// ChainEntryReader reads the entries of a chain in order. Next returns
+// io.EOF after the last entry.
+type ChainEntryReader interface {
+	Next() (ChainEntryDao, error)
+}
+
+// HoldoutDao is the data access object / schema for the text held out
+// of a chain's training text
+type HoldoutDao struct {
+	Split     string        `bson:"split"`
+	Documents []DocumentDao `bson:"documents"`
+}
+
+// MergeDao is the data access object / schema for how a chain was merged
+// from other chains
+type MergeDao struct {
+	Normalization string           `bson:"normalization"`
+	Sources       []ChainSourceDao `bson:"sources"`
+	Merged        time.Time        `bson:"merged"`
+}
+
+// ChainSourceDao is the data access object / schema for one chain merged
+// into another, and the weight it was given
+type ChainSourceDao struct {
+	ChainID string   `bson:"chainid"`
+	Users   []string `bson:"users"`
+	Split   string   `bson:"split"`
+	Weight  float64  `bson:"weight"`
+}
+
+// DocumentDao is the data access object / schema for one document of text
+type DocumentDao struct {
+	ID      string    `bson:"id"`
+	User    string    `bson:"user"`
+	Text    string    `bson:"text"`
+	Created time.Time `bson:"created"`
+}
+
+// FeedbackDao is the data access object / schema for an accepted suggestion
+type FeedbackDao struct {
+	// ID is the stored id of feedback read from the db
+	ID         string    `bson:"-"`
+	Prefix     string    `bson:"prefix"`
+	Suggestion string    `bson:"suggestion"`
+	Client     string    `bson:"client"`
+	Promoted   bool      `bson:"promoted"`
+	Created    time.Time `bson:"created"`
+}
+
+// SafetyPolicyDao is the data access object / schema for the blocklists
+// applied to a chain's suggestions. It is also the Dto of the admin API.
+type SafetyPolicyDao struct {
+	ChainID string `bson:"chainid" json:"chainId"`
+	// Action is "filter" to remove blocked suggestions or "downrank"
+	// to scale their weight by Penalty
+	Action       string    `bson:"action" json:"action"`
+	Penalty      float64   `bson:"penalty" json:"penalty"`
+	Words        []string  `bson:"words" json:"words"`
+	Phrases      []string  `bson:"phrases" json:"phrases"`
+	Patterns     []string  `bson:"patterns" json:"patterns"`
+	LastModified time.Time `bson:"lastmodified" json:"lastModified"`
+}
+
+// TokenizerDao is the data access object / schema for recording
+// how a chain's text was tokenized
+type TokenizerDao struct {
+	Name   string            `bson:"name"`
+	Config map[string]string `bson:"config"`
+}
+
+// SegmenterDao is the data access object / schema for recording how
+// a chain's text was split into sentences
+type SegmenterDao struct {
+	Language string   `bson:"language"`
+	Learned  []string `bson:"learned"`
 }
 
 // Pair is a struct containing a string and int
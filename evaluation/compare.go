@@ -0,0 +1,258 @@
+package evaluation
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+)
+
+// Metric names used in comparisons
+const (
+	MetricPerplexity       = "perplexity"
+	MetricTop1             = "top1"
+	MetricTop3             = "top3"
+	MetricMRR              = "mrr"
+	MetricCoverage         = "coverage"
+	MetricKeystrokeSavings = "keystrokeSavings"
+)
+
+// Delta is the change in a metric from a baseline to a candidate, with
+// a bootstrap confidence interval
+type Delta struct {
+	Metric    string  `json:"metric"`
+	Baseline  float64 `json:"baseline"`
+	Candidate float64 `json:"candidate"`
+	Delta     float64 `json:"delta"`
+	Low       float64 `json:"low"`
+	High      float64 `json:"high"`
+	// Regressed is true if the candidate is significantly worse by
+	// more than the comparison's threshold
+	Regressed bool `json:"regressed"`
+}
+
+// PrefixChange is a prefix whose suggestions differ between models
+type PrefixChange struct {
+	Key string `json:"key"`
+	// Count is how many times the prefix occurs in the corpus
+	Count     int      `json:"count"`
+	Baseline  []string `json:"baseline"`
+	Candidate []string `json:"candidate"`
+	// Change is the fraction of suggestion positions that differ
+	Change float64 `json:"change"`
+}
+
+// Comparison is the result of comparing a candidate model to a baseline
+type Comparison struct {
+	Baseline  Report  `json:"baseline"`
+	Candidate Report  `json:"candidate"`
+	Deltas    []Delta `json:"deltas"`
+	// Paired is true if both models split the corpus into the same
+	// tokens, so the outcomes of each token were resampled together
+	Paired    bool           `json:"paired"`
+	Changed   []PrefixChange `json:"changed"`
+	Regressed bool           `json:"regressed"`
+}
+
+// CompareOptions configures a comparison
+type CompareOptions struct {
+	// Samples is the number of bootstrap resamples
+	Samples int
+	// Confidence is the width of the confidence intervals, such as 0.95
+	Confidence float64
+	// Threshold is the largest drop in a rate, or relative increase in
+	// perplexity, that is not a regression
+	Threshold float64
+	// Changed is the number of changed prefixes to list
+	Changed int
+	Seed    int64
+}
+
+// metrics returns the value of each metric in a report
+func metrics(r Report, probability bool) map[string]float64 {
+	values := map[string]float64{
+		MetricTop1:             r.Top1,
+		MetricTop3:             r.Top3,
+		MetricMRR:              r.MRR,
+		MetricCoverage:         r.Coverage,
+		MetricKeystrokeSavings: r.KeystrokeSavings,
+	}
+	if probability {
+		values[MetricPerplexity] = r.Perplexity
+	}
+	return values
+}
+
+// Compare compares a candidate evaluator to a baseline evaluator that
+// evaluated the same corpus. A metric regresses if its confidence
+// interval is entirely worse than no change and the candidate is worse
+// by more than the threshold.
+func Compare(baseline, candidate *Evaluator, opts CompareOptions) (Comparison, error) {
+	a, b := baseline.Outcomes(), candidate.Outcomes()
+	if len(a) == 0 || len(b) == 0 {
+		return Comparison{}, errors.New("both models must be evaluated on some text")
+	}
+	probability := baseline.HasProbability() && candidate.HasProbability()
+	comparison := Comparison{
+		Baseline:  Summarize(a, probability),
+		Candidate: Summarize(b, probability),
+		Paired:    aligned(a, b),
+	}
+	before := metrics(comparison.Baseline, probability)
+	after := metrics(comparison.Candidate, probability)
+
+	// resample the corpus, taking the same tokens from both models
+	// if they are paired and independent samples if they are not
+	rng := rand.New(rand.NewSource(opts.Seed))
+	samples := make(map[string][]float64)
+	sampleA := make([]Outcome, len(a))
+	sampleB := make([]Outcome, len(b))
+	for i := 0; i < opts.Samples; i++ {
+		for j := range sampleA {
+			k := rng.Intn(len(a))
+			sampleA[j] = a[k]
+			if comparison.Paired {
+				sampleB[j] = b[k]
+			}
+		}
+		if !comparison.Paired {
+			for j := range sampleB {
+				sampleB[j] = b[rng.Intn(len(b))]
+			}
+		}
+
+		resampledA := metrics(Summarize(sampleA, probability), probability)
+		resampledB := metrics(Summarize(sampleB, probability), probability)
+		for metric := range before {
+			samples[metric] = append(samples[metric], resampledB[metric]-resampledA[metric])
+		}
+	}
+
+	for _, metric := range []string{MetricPerplexity, MetricTop1, MetricTop3,
+		MetricMRR, MetricCoverage, MetricKeystrokeSavings} {
+		if _, ok := before[metric]; !ok {
+			continue
+		}
+		delta := Delta{
+			Metric:    metric,
+			Baseline:  before[metric],
+			Candidate: after[metric],
+			Delta:     after[metric] - before[metric],
+		}
+		delta.Low, delta.High = interval(samples[metric], opts.Confidence)
+		delta.Regressed = regressed(delta, opts.Threshold)
+		comparison.Regressed = comparison.Regressed || delta.Regressed
+		comparison.Deltas = append(comparison.Deltas, delta)
+	}
+
+	changed, err := changedPrefixes(baseline, candidate, opts.Changed)
+	comparison.Changed = changed
+	return comparison, err
+}
+
+// aligned returns true if a and b are outcomes of the same tokens
+func aligned(a, b []Outcome) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Token != b[i].Token || a[i].End != b[i].End {
+			return false
+		}
+	}
+	return true
+}
+
+// interval returns the central confidence interval of samples
+func interval(samples []float64, confidence float64) (low, high float64) {
+	if len(samples) == 0 {
+		return 0, 0
+	}
+	sorted := append([]float64{}, samples...)
+	sort.Float64s(sorted)
+
+	tail := (1 - confidence) / 2
+	lowIndex := int(tail * float64(len(sorted)-1))
+	highIndex := int((1 - tail) * float64(len(sorted)-1))
+	return sorted[lowIndex], sorted[highIndex]
+}
+
+// regressed returns true if the candidate is significantly worse than
+// the baseline by more than threshold
+func regressed(d Delta, threshold float64) bool {
+	if d.Metric == MetricPerplexity {
+		// lower perplexity is better and it is compared relatively
+		return d.Low > 0 && d.Baseline > 0 && d.Delta/d.Baseline > threshold
+	}
+	return d.High < 0 && -d.Delta > threshold
+}
+
+// changedPrefixes finds the n prefixes of the corpus whose suggestions
+// changed most, weighted by how often they occur. Prefixes are counted
+// as the baseline split the corpus, or as the candidate did for prefixes
+// only the candidate has.
+func changedPrefixes(baseline, candidate *Evaluator, n int) ([]PrefixChange, error) {
+	counts := prefixCounts(baseline.Outcomes())
+	for key, count := range prefixCounts(candidate.Outcomes()) {
+		if _, ok := counts[key]; !ok {
+			counts[key] = count
+		}
+	}
+
+	changes := make([]PrefixChange, 0)
+	for key, count := range counts {
+		before, err := baseline.Predictor.Predict(key)
+		if err != nil {
+			return nil, err
+		}
+		after, err := candidate.Predictor.Predict(key)
+		if err != nil {
+			return nil, err
+		}
+
+		positions := len(before)
+		if len(after) > positions {
+			positions = len(after)
+		}
+		differ := 0
+		for i := 0; i < positions; i++ {
+			if i >= len(before) || i >= len(after) || before[i] != after[i] {
+				differ++
+			}
+		}
+		if differ == 0 {
+			continue
+		}
+
+		changes = append(changes, PrefixChange{
+			Key:       key,
+			Count:     count,
+			Baseline:  before,
+			Candidate: after,
+			Change:    float64(differ) / float64(positions),
+		})
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		wi := changes[i].Change * float64(changes[i].Count)
+		wj := changes[j].Change * float64(changes[j].Count)
+		if wi != wj {
+			return wi > wj
+		}
+		return changes[i].Key < changes[j].Key
+	})
+	if len(changes) > n {
+		changes = changes[:n]
+	}
+	return changes, nil
+}
+
+// prefixCounts counts the prefixes before each token of outcomes
+func prefixCounts(outcomes []Outcome) map[string]int {
+	counts := make(map[string]int)
+	for _, outcome := range outcomes {
+		if !outcome.End {
+			counts[outcome.Key]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,173 @@
+package evaluation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const compareCorpus = `The cat sat. The cat ran. The dog sat. A cat sat.
+The dog ran. The cat sat. A dog sat. The cat ran.`
+
+// evaluated returns an evaluator that has evaluated compareCorpus
+func evaluated(t *testing.T, predictor mapPredictor) *Evaluator {
+	t.Helper()
+	e := newTestEvaluator(predictor)
+	if err := e.Evaluate(strings.NewReader(compareCorpus)); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestCompare(t *testing.T) {
+	good := mapPredictor{
+		"<s> <s>": {"The", "A"},
+		"<s> the": {"cat", "dog"},
+		"the cat": {"sat", "ran"},
+		"the dog": {"sat", "ran"},
+		"<s> a":   {"cat", "dog"},
+		"a cat":   {"sat"},
+		"a dog":   {"sat"},
+	}
+	worse := mapPredictor{
+		"<s> <s>": {"The", "A"},
+		"<s> the": {"cat", "dog"},
+	}
+	opts := CompareOptions{Samples: 200, Confidence: 0.9, Threshold: 0.01, Changed: 10, Seed: 1}
+
+	tests := []struct {
+		name          string
+		baseline      mapPredictor
+		candidate     mapPredictor
+		wantRegressed bool
+	}{
+		{"same model", good, good, false},
+		{"better model", worse, good, false},
+		{"worse model", good, worse, true},
+	}
+
+	for _, test := range tests {
+		comparison, err := Compare(evaluated(t, test.baseline), evaluated(t, test.candidate), opts)
+		if err != nil {
+			t.Errorf("%v: Compare() error = %v", test.name, err)
+			continue
+		}
+		if !comparison.Paired {
+			t.Errorf("%v: models that split text the same way are not paired", test.name)
+		}
+		if comparison.Regressed != test.wantRegressed {
+			t.Errorf("%v: Regressed = %v, want %v", test.name, comparison.Regressed, test.wantRegressed)
+		}
+		for _, delta := range comparison.Deltas {
+			if delta.Low > delta.Delta || delta.High < delta.Delta {
+				t.Errorf("%v: %v changed by %v outside its interval [%v, %v]",
+					test.name, delta.Metric, delta.Delta, delta.Low, delta.High)
+			}
+			if test.name == "same model" && (delta.Low != 0 || delta.High != 0) {
+				t.Errorf("%v: %v interval = [%v, %v], want [0, 0]", test.name, delta.Metric, delta.Low, delta.High)
+			}
+		}
+
+		// the same seed resamples the same way
+		again, _ := Compare(evaluated(t, test.baseline), evaluated(t, test.candidate), opts)
+		if !reflect.DeepEqual(again.Deltas, comparison.Deltas) {
+			t.Errorf("%v: the same seed gave different intervals", test.name)
+		}
+	}
+}
+
+func TestCompareWithoutText(t *testing.T) {
+	empty := newTestEvaluator(mapPredictor{})
+	full := evaluated(t, mapPredictor{})
+	tests := []struct {
+		name                string
+		baseline, candidate *Evaluator
+	}{
+		{"no baseline text", empty, full},
+		{"no candidate text", full, empty},
+		{"no text", empty, empty},
+	}
+
+	for _, test := range tests {
+		if _, err := Compare(test.baseline, test.candidate, CompareOptions{Samples: 10}); err == nil {
+			t.Errorf("%v: Compare() compared models without outcomes", test.name)
+		}
+	}
+}
+
+func TestInterval(t *testing.T) {
+	tests := []struct {
+		name       string
+		samples    []float64
+		confidence float64
+		low, high  float64
+	}{
+		{"no samples", nil, 0.95, 0, 0},
+		{"central half", []float64{4, 0, 3, 1, 2}, 0.5, 1, 3},
+		{"everything", []float64{4, 0, 3, 1, 2}, 1, 0, 4},
+		{"one sample", []float64{7}, 0.95, 7, 7},
+	}
+
+	for _, test := range tests {
+		low, high := interval(test.samples, test.confidence)
+		if low != test.low || high != test.high {
+			t.Errorf("%v: interval() = [%v, %v], want [%v, %v]", test.name, low, high, test.low, test.high)
+		}
+	}
+}
+
+func TestRegressed(t *testing.T) {
+	tests := []struct {
+		name  string
+		delta Delta
+		want  bool
+	}{
+		{"significant drop", Delta{Metric: MetricTop1, Baseline: 0.5, Delta: -0.1, Low: -0.2, High: -0.05}, true},
+		{"drop within threshold", Delta{Metric: MetricTop1, Baseline: 0.5, Delta: -0.01, Low: -0.02, High: -0.005}, false},
+		{"insignificant drop", Delta{Metric: MetricTop1, Baseline: 0.5, Delta: -0.1, Low: -0.2, High: 0.01}, false},
+		{"rise", Delta{Metric: MetricTop1, Baseline: 0.5, Delta: 0.1, Low: 0.05, High: 0.2}, false},
+		{"significant perplexity rise", Delta{Metric: MetricPerplexity, Baseline: 100, Delta: 10, Low: 5, High: 15}, true},
+		{"perplexity rise within threshold", Delta{Metric: MetricPerplexity, Baseline: 100, Delta: 2, Low: 1, High: 3}, false},
+		{"insignificant perplexity rise", Delta{Metric: MetricPerplexity, Baseline: 100, Delta: 10, Low: -1, High: 20}, false},
+		{"perplexity drop", Delta{Metric: MetricPerplexity, Baseline: 100, Delta: -10, Low: -15, High: -5}, false},
+	}
+
+	for _, test := range tests {
+		if got := regressed(test.delta, 0.05); got != test.want {
+			t.Errorf("%v: regressed() = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChangedPrefixes(t *testing.T) {
+	baseline := newTestEvaluator(mapPredictor{"a": {"x"}, "b": {"y"}, "c": {"z"}})
+	baseline.outcomes = []Outcome{{Key: "a"}, {Key: "a"}, {Key: "b"}, {Key: "b", End: true}}
+	candidate := newTestEvaluator(mapPredictor{"a": {"x"}, "b": {"w", "y"}, "c": {"q"}})
+	// the candidate split the text differently, so has a prefix the
+	// baseline does not
+	candidate.outcomes = []Outcome{{Key: "a"}, {Key: "c"}, {Key: "c"}}
+
+	tests := []struct {
+		name string
+		n    int
+		want []PrefixChange
+	}{
+		{"all", 10, []PrefixChange{
+			{Key: "c", Count: 2, Baseline: []string{"z"}, Candidate: []string{"q"}, Change: 1},
+			{Key: "b", Count: 1, Baseline: []string{"y"}, Candidate: []string{"w", "y"}, Change: 1},
+		}},
+		{"top", 1, []PrefixChange{
+			{Key: "c", Count: 2, Baseline: []string{"z"}, Candidate: []string{"q"}, Change: 1},
+		}},
+	}
+
+	for _, test := range tests {
+		got, err := changedPrefixes(baseline, candidate, test.n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: changedPrefixes() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Package evaluation measures how well predictions match held-out text
+package evaluation
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+
+	"github.com/zacwhalley/predictivetext/common"
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// ProbabilityModel is a predictor that can also estimate how likely
+// a word is to follow a key, which is needed to measure perplexity
+type ProbabilityModel interface {
+	domain.Predictor
+	Probability(key, word string) float64
+}
+
+// Report summarizes how well a predictor did on held-out text.
+// Rates are fractions of the tokens predicted.
+type Report struct {
+	// Tokens is the number of tokens predicted, not counting sentence ends
+	Tokens int `json:"tokens"`
+	// Perplexity is only measured for predictors with probabilities,
+	// and includes predicting the end of each sentence
+	Perplexity float64 `json:"perplexity,omitempty"`
+	Top1       float64 `json:"top1"`
+	Top3       float64 `json:"top3"`
+	// MRR is the mean reciprocal rank of the next word in the suggestions
+	MRR float64 `json:"mrr"`
+	// Coverage is the fraction of tokens with any suggestion
+	Coverage float64 `json:"coverage"`
+	// KeystrokeSavings is the fraction of keystrokes saved by accepting
+	// a suggestion with one keystroke whenever it is the next word
+	KeystrokeSavings float64 `json:"keystrokeSavings"`
+}
+
+func (r Report) String() string {
+	perplexity := "n/a"
+	if r.Perplexity > 0 {
+		perplexity = fmt.Sprintf("%.2f", r.Perplexity)
+	}
+	return fmt.Sprintf("Tokens: %v\n"+
+		"Perplexity: %s\n"+
+		"Top-1 accuracy: %.2f%%\n"+
+		"Top-3 accuracy: %.2f%%\n"+
+		"Mean reciprocal rank: %.4f\n"+
+		"Coverage: %.2f%%\n"+
+		"Keystroke savings: %.2f%%",
+		r.Tokens, perplexity, r.Top1*100, r.Top3*100, r.MRR,
+		r.Coverage*100, r.KeystrokeSavings*100)
+}
+
+// Outcome is how well one token of held-out text was predicted
+type Outcome struct {
+	Key   string
+	Token string
+	// End is true if the token ends a sentence. Sentence ends are only
+	// used to measure perplexity.
+	End bool
+	// Rank is the position of the token in the suggestions from 1,
+	// or 0 if it was not suggested
+	Rank      int
+	Suggested bool
+	LogProb   float64
+	// Typed is the keystrokes needed to type the token and Keystrokes
+	// the keystrokes needed using the suggestions
+	Typed      int
+	Keystrokes int
+}
+
+// Evaluator predicts each token of held-out text from the tokens
+// before it, splitting the text the same way as the chain it tests
+type Evaluator struct {
+	Predictor domain.Predictor
+	Tokenizer domain.Tokenizer
+	Segmenter domain.Segmenter
+	PrefixLen int
+
+	outcomes []Outcome
+}
+
+// Evaluate predicts every token in the text read from r, reading it
+// the way Chain.Build does. It may be called more than once to
+// evaluate several texts together.
+func (e *Evaluator) Evaluate(r io.Reader) error {
+	model, hasProbability := e.Predictor.(ProbabilityModel)
+	br := bufio.NewReader(r)
+	prefix := common.NewPrefix(e.PrefixLen)
+	for {
+		text, err := common.ReadText(br)
+		for _, token := range common.SentenceTokens(e.Tokenizer, e.Segmenter, text) {
+			outcome := Outcome{Key: prefix.ToString(), Token: token}
+			prefix.Shift(token)
+			if hasProbability {
+				outcome.LogProb = math.Log2(model.Probability(outcome.Key, token))
+			}
+			if token == util.SentenceEnd {
+				outcome.End = true
+				e.outcomes = append(e.outcomes, outcome)
+				continue
+			}
+
+			words, predictErr := e.Suggest(outcome.Key)
+			if predictErr != nil {
+				return predictErr
+			}
+			e.outcomes = append(e.outcomes, score(outcome, words))
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// the end of the text also ends its last sentence
+	if !prefix.IsEmpty() {
+		outcome := Outcome{Key: prefix.ToString(), Token: util.SentenceEnd, End: true}
+		if hasProbability {
+			outcome.LogProb = math.Log2(model.Probability(outcome.Key, util.SentenceEnd))
+		}
+		e.outcomes = append(e.outcomes, outcome)
+	}
+	return nil
+}
+
+// Suggest returns the cleaned first token of each suggestion for key,
+// dropping suggestions that start with the same token
+func (e *Evaluator) Suggest(key string) ([]string, error) {
+	suggestions, err := e.Predictor.Predict(key)
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]string, 0, len(suggestions))
+	seen := make(map[string]bool)
+	for _, suggestion := range suggestions {
+		tokens := e.Tokenizer.Tokenize(suggestion)
+		if len(tokens) == 0 {
+			continue
+		}
+		word := util.Clean(tokens[0])
+		if !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words, nil
+}
+
+// score records how well words predicted the outcome's token
+func score(outcome Outcome, words []string) Outcome {
+	outcome.Suggested = len(words) > 0
+
+	// typing a word takes a keystroke for each character and the space
+	outcome.Typed = utf8.RuneCountInString(outcome.Token) + 1
+	outcome.Keystrokes = outcome.Typed
+
+	key := util.Clean(outcome.Token)
+	for i, word := range words {
+		if word == key {
+			outcome.Rank = i + 1
+			// accepting the suggestion takes one keystroke
+			outcome.Keystrokes = 1
+			break
+		}
+	}
+	return outcome
+}
+
+// Outcomes returns the outcome of every token evaluated so far
+func (e *Evaluator) Outcomes() []Outcome {
+	return e.outcomes
+}
+
+// HasProbability returns true if the predictor can measure perplexity
+func (e *Evaluator) HasProbability() bool {
+	_, ok := e.Predictor.(ProbabilityModel)
+	return ok
+}
+
+// Report returns the results of all text evaluated so far
+func (e *Evaluator) Report() Report {
+	return Summarize(e.outcomes, e.HasProbability())
+}
+
+// Summarize reports the results of outcomes. Perplexity is only
+// measured if hasProbability is true.
+func Summarize(outcomes []Outcome, hasProbability bool) Report {
+	var report Report
+	var logProb float64
+	var rankSum float64
+	var top1, top3, covered, typed, keystrokes int
+	for _, outcome := range outcomes {
+		logProb += outcome.LogProb
+		if outcome.End {
+			continue
+		}
+
+		report.Tokens++
+		if outcome.Rank == 1 {
+			top1++
+		}
+		if outcome.Rank >= 1 && outcome.Rank <= 3 {
+			top3++
+		}
+		if outcome.Rank > 0 {
+			rankSum += 1 / float64(outcome.Rank)
+		}
+		if outcome.Suggested {
+			covered++
+		}
+		typed += outcome.Typed
+		keystrokes += outcome.Keystrokes
+	}
+	if report.Tokens == 0 {
+		return Report{}
+	}
+
+	tokens := float64(report.Tokens)
+	report.Top1 = float64(top1) / tokens
+	report.Top3 = float64(top3) / tokens
+	report.MRR = rankSum / tokens
+	report.Coverage = float64(covered) / tokens
+	report.KeystrokeSavings = 1 - float64(keystrokes)/float64(typed)
+	if hasProbability {
+		report.Perplexity = math.Exp2(-logProb / float64(len(outcomes)))
+	}
+	return report
+}
+
+// Mean averages reports, such as those of each fold of a cross-validation
+func Mean(reports []Report) Report {
+	var mean Report
+	if len(reports) == 0 {
+		return mean
+	}
+
+	n := float64(len(reports))
+	for _, r := range reports {
+		mean.Tokens += r.Tokens
+		mean.Perplexity += r.Perplexity / n
+		mean.Top1 += r.Top1 / n
+		mean.Top3 += r.Top3 / n
+		mean.MRR += r.MRR / n
+		mean.Coverage += r.Coverage / n
+		mean.KeystrokeSavings += r.KeystrokeSavings / n
+	}
+	return mean
+}
@@ -0,0 +1,205 @@
+package evaluation
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zacwhalley/predictivetext/common"
+	"github.com/zacwhalley/predictivetext/domain"
+	"github.com/zacwhalley/predictivetext/util"
+)
+
+// mapPredictor suggests fixed words after each key
+type mapPredictor map[string][]string
+
+func (p mapPredictor) Predict(key string) ([]string, error) {
+	return p[key], nil
+}
+
+// probabilityPredictor gives every word the same probability
+type probabilityPredictor struct {
+	mapPredictor
+	probability float64
+}
+
+func (p probabilityPredictor) Probability(key, word string) float64 {
+	return p.probability
+}
+
+// testSuggestions are hand-checked against "The cat sat.": the is first,
+// cat second, sat is not suggested and nothing is suggested for "."
+var testSuggestions = mapPredictor{
+	"<s> <s>": {"The", "A"},
+	"<s> the": {"dog", "cat"},
+	"the cat": {"ran"},
+}
+
+func newTestEvaluator(predictor domain.Predictor) *Evaluator {
+	return &Evaluator{
+		Predictor: predictor,
+		Tokenizer: common.DefaultTokenizer,
+		Segmenter: common.DefaultSegmenter,
+		PrefixLen: 2,
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	// The, cat, sat and . are typed with 4 + 4 + 4 + 2 keystrokes, or
+	// 1 + 1 + 4 + 2 accepting the suggestions of the and cat
+	tests := []struct {
+		name      string
+		predictor domain.Predictor
+		want      Report
+	}{
+		{
+			name:      "without probabilities",
+			predictor: testSuggestions,
+			want: Report{
+				Tokens:           4,
+				Top1:             0.25,
+				Top3:             0.5,
+				MRR:              (1 + 0.5) / 4,
+				Coverage:         0.75,
+				KeystrokeSavings: 6.0 / 14,
+			},
+		},
+		{
+			name:      "with probabilities",
+			predictor: probabilityPredictor{testSuggestions, 0.25},
+			want: Report{
+				Tokens:           4,
+				Perplexity:       4,
+				Top1:             0.25,
+				Top3:             0.5,
+				MRR:              (1 + 0.5) / 4,
+				Coverage:         0.75,
+				KeystrokeSavings: 6.0 / 14,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		e := newTestEvaluator(test.predictor)
+		if err := e.Evaluate(strings.NewReader("The cat sat.")); err != nil {
+			t.Errorf("%v: Evaluate() error = %v", test.name, err)
+			continue
+		}
+		if got := e.Report(); !reportsEqual(got, test.want) {
+			t.Errorf("%v: Report() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEvaluateOutcomes(t *testing.T) {
+	e := newTestEvaluator(testSuggestions)
+	// the second text has no full stop, so its end is added
+	for _, text := range []string{"The cat sat.", "The dog"} {
+		if err := e.Evaluate(strings.NewReader(text)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []Outcome{
+		{Key: "<s> <s>", Token: "The", Rank: 1, Suggested: true, Typed: 4, Keystrokes: 1},
+		{Key: "<s> the", Token: "cat", Rank: 2, Suggested: true, Typed: 4, Keystrokes: 1},
+		{Key: "the cat", Token: "sat", Suggested: true, Typed: 4, Keystrokes: 4},
+		{Key: "cat sat", Token: ".", Typed: 2, Keystrokes: 2},
+		{Key: "sat .", Token: util.SentenceEnd, End: true},
+		{Key: "<s> <s>", Token: "The", Rank: 1, Suggested: true, Typed: 4, Keystrokes: 1},
+		{Key: "<s> the", Token: "dog", Rank: 1, Suggested: true, Typed: 4, Keystrokes: 1},
+		{Key: "the dog", Token: util.SentenceEnd, End: true},
+	}
+	if got := e.Outcomes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Outcomes() = %+v, want %+v", got, want)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	e := newTestEvaluator(mapPredictor{
+		"key": {"The cat", "the dog", "", "A", "(aside)"},
+	})
+	got, err := e.Suggest("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"the", "a", "("}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Suggest() = %q, want %q", got, want)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		name        string
+		outcomes    []Outcome
+		probability bool
+		want        Report
+	}{
+		{"nothing", nil, true, Report{}},
+		{"only sentence ends", []Outcome{{End: true, LogProb: -1}}, true, Report{}},
+		{
+			name: "ranks",
+			outcomes: []Outcome{
+				{Rank: 1, Suggested: true, Typed: 4, Keystrokes: 1},
+				{Rank: 3, Suggested: true, Typed: 3, Keystrokes: 1},
+				{Rank: 4, Suggested: true, Typed: 2, Keystrokes: 1},
+				{Typed: 3, Keystrokes: 3},
+			},
+			want: Report{
+				Tokens:           4,
+				Top1:             0.25,
+				Top3:             0.5,
+				MRR:              (1 + 1.0/3 + 0.25) / 4,
+				Coverage:         0.75,
+				KeystrokeSavings: 1 - 6.0/12,
+			},
+		},
+		{
+			// sentence ends count towards perplexity but not accuracy
+			name: "perplexity",
+			outcomes: []Outcome{
+				{Rank: 1, Suggested: true, LogProb: -1, Typed: 2, Keystrokes: 1},
+				{End: true, LogProb: -3},
+			},
+			probability: true,
+			want: Report{
+				Tokens:           1,
+				Perplexity:       4,
+				Top1:             1,
+				Top3:             1,
+				MRR:              1,
+				Coverage:         1,
+				KeystrokeSavings: 0.5,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		if got := Summarize(test.outcomes, test.probability); !reportsEqual(got, test.want) {
+			t.Errorf("%v: Summarize() = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMean(t *testing.T) {
+	got := Mean([]Report{
+		{Tokens: 10, Perplexity: 4, Top1: 0.5, Top3: 1, MRR: 0.5, Coverage: 1, KeystrokeSavings: 0.5},
+		{Tokens: 30, Perplexity: 2, Top1: 0.25, Top3: 0.5, MRR: 0.25, Coverage: 0.5, KeystrokeSavings: 0.1},
+	})
+	want := Report{Tokens: 40, Perplexity: 3, Top1: 0.375, Top3: 0.75, MRR: 0.375, Coverage: 0.75, KeystrokeSavings: 0.3}
+	if !reportsEqual(got, want) {
+		t.Errorf("Mean() = %+v, want %+v", got, want)
+	}
+	if got := Mean(nil); got != (Report{}) {
+		t.Errorf("Mean(nil) = %+v, want an empty report", got)
+	}
+}
+
+// reportsEqual compares reports, allowing for rounding in the rates
+func reportsEqual(a, b Report) bool {
+	near := func(x, y float64) bool { return math.Abs(x-y) < 1e-12 }
+	return a.Tokens == b.Tokens && near(a.Perplexity, b.Perplexity) &&
+		near(a.Top1, b.Top1) && near(a.Top3, b.Top3) && near(a.MRR, b.MRR) &&
+		near(a.Coverage, b.Coverage) && near(a.KeystrokeSavings, b.KeystrokeSavings)
+}
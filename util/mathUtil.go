@@ -17,3 +17,12 @@ func MinInt(a, b int) int {
 
 	return b
 }
+
+// AbsInt returns the absolute value of an integer
+func AbsInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+
+	return a
+}
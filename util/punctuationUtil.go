@@ -0,0 +1,125 @@
+package util
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Punctuation marks kept as tokens. Opening marks attach to the word
+// after them, closing marks to the word before them and quotes to
+// whichever word they are quoting.
+const (
+	openingMarks  = "([¿¡«“‘„"
+	closingMarks  = ".,!?;:" + closingQuotes + "。、，！？：；…"
+	closingQuotes = ")]»”’"
+	sentenceEnds  = ".!?。！？…"
+	quoteMarks    = "\""
+)
+
+// IsPunctuation returns true if s is a punctuation token
+func IsPunctuation(s string) bool {
+	if s == "" {
+		return false
+	}
+	if strings.Trim(s, sentenceEnds) == "" {
+		// runs like ... and ?! are a single token
+		return true
+	}
+	return utf8.RuneCountInString(s) == 1 &&
+		strings.ContainsAny(s, openingMarks+closingMarks+quoteMarks)
+}
+
+// IsWord returns true if s is neither punctuation nor a sentence boundary
+func IsWord(s string) bool {
+	return !IsPunctuation(s) && !IsBoundary(s)
+}
+
+// SplitPunctuation splits the punctuation marks at the start and end
+// of word into their own tokens
+func SplitPunctuation(word string) []string {
+	if IsBoundary(word) || IsPunctuation(word) {
+		return []string{word}
+	}
+
+	leading := make([]string, 0)
+	for len(word) > 0 {
+		r, size := utf8.DecodeRuneInString(word)
+		if !strings.ContainsRune(openingMarks+quoteMarks, r) {
+			break
+		}
+		leading = append(leading, word[:size])
+		word = word[size:]
+	}
+
+	trailing := make([]string, 0)
+	for len(word) > 0 {
+		r, size := utf8.DecodeLastRuneInString(word)
+		if strings.ContainsRune(sentenceEnds, r) {
+			// keep runs like ... and ?! together
+			core := strings.TrimRight(word, sentenceEnds)
+			trailing = append([]string{word[len(core):]}, trailing...)
+			word = core
+		} else if strings.ContainsRune(closingMarks+quoteMarks, r) {
+			trailing = append([]string{word[len(word)-size:]}, trailing...)
+			word = word[:len(word)-size]
+		} else {
+			break
+		}
+	}
+
+	tokens := leading
+	if word != "" {
+		tokens = append(tokens, word)
+	}
+	return append(tokens, trailing...)
+}
+
+// IsClosingQuote returns true if token is a closing bracket or quote,
+// which is written after the full stop of the sentence it closes
+func IsClosingQuote(token string) bool {
+	return token != "" && strings.Contains(closingQuotes, token)
+}
+
+// AttachesLeft returns true if text starts with a mark that should be
+// written directly after the preceding text, without a space
+func AttachesLeft(text string) bool {
+	r, _ := utf8.DecodeRuneInString(text)
+	return strings.ContainsRune(closingMarks+quoteMarks, r)
+}
+
+// JoinTokens joins tokens into text, attaching each punctuation mark to
+// the word it belongs to. A quote at the start of the tokens is assumed
+// to close a quote in the preceding text.
+func JoinTokens(tokens []string) string {
+	var b strings.Builder
+	attachNext := true
+	inQuote := len(tokens) > 0 && tokens[0] == quoteMarks
+	for _, token := range tokens {
+		if IsBoundary(token) || token == "" {
+			continue
+		}
+
+		attach := attachNext
+		attachNext = false
+		switch {
+		case token == quoteMarks:
+			if inQuote {
+				attach = true
+			} else {
+				attachNext = true
+			}
+			inQuote = !inQuote
+		case IsPunctuation(token) && strings.Contains(openingMarks, token):
+			attachNext = true
+		case IsPunctuation(token):
+			attach = true
+		}
+
+		if !attach {
+			b.WriteString(" ")
+		}
+		b.WriteString(token)
+	}
+
+	return b.String()
+}
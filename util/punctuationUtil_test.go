@@ -0,0 +1,78 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitPunctuation(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"hello", []string{"hello"}},
+		{"hello,", []string{"hello", ","}},
+		{"end.", []string{"end", "."}},
+		{"wait...", []string{"wait", "..."}},
+		{"what?!", []string{"what", "?!"}},
+		{"(aside)", []string{"(", "aside", ")"}},
+		{"(end).", []string{"(", "end", ")", "."}},
+		{`"quoted"`, []string{`"`, "quoted", `"`}},
+		{"¿qué?", []string{"¿", "qué", "?"}},
+		{"e.g.", []string{"e.g", "."}},
+		{"don't", []string{"don't"}},
+		{".", []string{"."}},
+		{SentenceEnd, []string{SentenceEnd}},
+	}
+
+	for _, test := range tests {
+		if got := SplitPunctuation(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitPunctuation(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestJoinTokens(t *testing.T) {
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{"hello", ",", "world"}, "hello, world"},
+		{[]string{"(", "aside", ")"}, "(aside)"},
+		{[]string{"he", "said", `"`, "hi", `"`, "."}, `he said "hi".`},
+		// a quote at the start closes a quote before the tokens
+		{[]string{`"`, "and", "so"}, `" and so`},
+		{[]string{"wait", "...", SentenceEnd, "ok"}, "wait... ok"},
+		{[]string{"¿", "qué", "?"}, "¿qué?"},
+		{[]string{}, ""},
+	}
+
+	for _, test := range tests {
+		if got := JoinTokens(test.in); got != test.want {
+			t.Errorf("JoinTokens(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestIsPunctuation(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{".", true},
+		{"...", true},
+		{"?!", true},
+		{",", true},
+		{"«", true},
+		{"a", false},
+		{",,", false},
+		{SentenceEnd, false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if got := IsPunctuation(test.in); got != test.want {
+			t.Errorf("IsPunctuation(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
@@ -3,8 +3,45 @@ package util
 import (
 	"regexp"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Tokens marking the start and end of a sentence in a chain
+const (
+	SentenceStart = "<s>"
+	SentenceEnd   = "</s>"
+)
+
+// IsBoundary returns true if s is a sentence start or end token
+func IsBoundary(s string) bool {
+	return s == SentenceStart || s == SentenceEnd
+}
+
+// Placeholder tokens standing in for text that is replaced before
+// a chain is built
+const (
+	UserMention      = "<user>"
+	SubredditMention = "<subreddit>"
 )
 
+// Placeholders lists every placeholder token
+var Placeholders = []string{UserMention, SubredditMention}
+
+// IsPlaceholder returns true if s is a placeholder token
+func IsPlaceholder(s string) bool {
+	for _, placeholder := range Placeholders {
+		if s == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
 // EndsSentence returns true if s ends with a ./!/? and is not
 // a common word like Mr. or Dr.
 func EndsSentence(s string) bool {
@@ -17,7 +54,7 @@ func EndsSentence(s string) bool {
 	}
 
 	// check for matches against cases that would end a sentence
-	return DoesEndWith(s, []string{".", "!", "?"})
+	return DoesEndWith(s, []string{".", "!", "?", "。", "！", "？"})
 }
 
 // DoesEndWith returns true if s has any string from match as a suffix
@@ -31,38 +68,145 @@ func DoesEndWith(s string, match []string) bool {
 	return false
 }
 
+// Precompiled matchers used by Filter
+var (
+	linkPattern   = regexp.MustCompile(`[-a-zA-Z0-9@:%_\+.~#?&//=]{2,256}\.[a-z]{2,4}\b(\/[-a-zA-Z0-9@:%_\+.~#?&//=]*)?`)
+	entityPattern = regexp.MustCompile(`&[a-zA-Z]+;`)
+)
+
+// foldPool holds case folders, which cannot be shared between goroutines
+var foldPool = sync.Pool{
+	New: func() interface{} { return cases.Fold() },
+}
+
 // Filter removes links and unwanted punctuation
 func Filter(s string) string {
-	linkPattern := `[-a-zA-Z0-9@:%_\+.~#?&//=]{2,256}\.[a-z]{2,4}\b(\/[-a-zA-Z0-9@:%_\+.~#?&//=]*)?`
-	miscMarkdownPattern := `[&[a-zA-Z]+;]`
+	// links have a . and entities have a &
+	if !strings.ContainsAny(s, ".&") {
+		return s
+	}
 
-	s = RemoveMatch(s, linkPattern)
-	s = RemoveMatch(s, miscMarkdownPattern)
+	s = linkPattern.ReplaceAllString(s, "")
+	s = entityPattern.ReplaceAllString(s, "")
 
 	return s
 }
 
-// Clean removes punctuation from a string for use as a key
+// Clean removes punctuation from a string for use as a key.
+// Keys are NFKC normalized and case folded so that different ways
+// of writing the same word share a key.
 func Clean(s string) string {
-	specCharPattern := `[^a-zA-Z0-9 ]`
-	s = RemoveMatch(s, specCharPattern)
+	if isASCII(s) {
+		// ASCII is unchanged by NFKC and folds to lower case
+		return toLowerASCII(Strip(s))
+	}
+
+	s = norm.NFKC.String(Strip(s))
+	caser := foldPool.Get().(cases.Caser)
+	defer foldPool.Put(caser)
+	return caser.String(s)
+}
+
+// Strip removes punctuation from a string, keeping its case. Sentence
+// boundary, placeholder and punctuation tokens are returned unchanged.
+func Strip(s string) string {
+	if isAlphanumericASCII(s) {
+		return s
+	}
+	if !isASCII(s) {
+		s = norm.NFC.String(s)
+	}
+	if IsBoundary(s) || IsPlaceholder(s) || IsPunctuation(s) {
+		return s
+	}
+
+	// keep letters, combining marks and numbers from any script
+	s = strings.Map(func(r rune) rune {
+		if r == ' ' || unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsNumber(r) {
+			return r
+		}
+		return -1
+	}, s)
 	s = strings.Trim(s, " ")
 
 	if s == "" {
 		s = " "
 	}
 
-	return strings.ToLower(s)
+	return s
+}
+
+// isASCII returns true if s only contains ASCII characters
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// isAlphanumericASCII returns true if s is a non-empty string of
+// ASCII letters and digits
+func isAlphanumericASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return s != ""
 }
 
+// toLowerASCII lower cases an ASCII string, only allocating if it
+// has upper case letters
+func toLowerASCII(s string) string {
+	for i := 0; i < len(s); i++ {
+		if 'A' <= s[i] && s[i] <= 'Z' {
+			return strings.ToLower(s)
+		}
+	}
+	return s
+}
+
+// Capitalize returns s with its first letter in upper case
+func Capitalize(s string) string {
+	r, size := utf8.DecodeRuneInString(s)
+	if r == utf8.RuneError {
+		return s
+	}
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
+// CapitalizesSentences returns true unless most sentences in input
+// start with a lower case letter
+func CapitalizesSentences(input string) bool {
+	upper, lower := 0, 0
+	atStart := true
+	for _, word := range strings.Fields(input) {
+		if atStart {
+			r, _ := utf8.DecodeRuneInString(word)
+			if unicode.IsUpper(r) {
+				upper++
+			} else if unicode.IsLower(r) {
+				lower++
+			}
+		}
+		atStart = EndsSentence(word)
+	}
+
+	return lower <= upper
+}
+
+// compiled holds the patterns compiled by RemoveMatch
+var compiled sync.Map
+
 // RemoveMatch removes all substrings in s that match pattern
 func RemoveMatch(s, pattern string) string {
-	// Remove all characters that are not part of words
-	regex, err := regexp.Compile(pattern)
-	if err != nil {
-		panic(err)
+	regex, ok := compiled.Load(pattern)
+	if !ok {
+		regex, _ = compiled.LoadOrStore(pattern, regexp.MustCompile(pattern))
 	}
 
-	s = regex.ReplaceAllString(s, "")
-	return s
+	return regex.(*regexp.Regexp).ReplaceAllString(s, "")
 }
@@ -0,0 +1,113 @@
+package util
+
+import "testing"
+
+func TestClean(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"hello", "hello"},
+		{"Hello", "hello"},
+		{"don't", "dont"},
+		{"Straße", "strasse"},
+		{"Ｈｅｌｌｏ", "hello"},
+		{"café", "café"},
+		{SentenceEnd, SentenceEnd},
+		{UserMention, UserMention},
+	}
+
+	for _, test := range tests {
+		if got := Clean(test.in); got != test.want {
+			t.Errorf("Clean(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"hello", "hello"},
+		{"example.com", ""},
+		{"&amp;", ""},
+		{"end.", "end."},
+	}
+
+	for _, test := range tests {
+		if got := Filter(test.in); got != test.want {
+			t.Errorf("Filter(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func BenchmarkClean(b *testing.B) {
+	benchmarks := []struct {
+		name  string
+		words []string
+	}{
+		{"ascii", []string{"the", "quick", "brown", "fox", "jumps"}},
+		{"punctuated", []string{"Hello,", "(world)", "it's", "\"quoted\"", "end."}},
+		{"unicode", []string{"Straße", "café", "naïve", "Ｈｅｌｌｏ", "東京"}},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Clean(bm.words[i%len(bm.words)])
+			}
+		})
+	}
+}
+
+func BenchmarkFilter(b *testing.B) {
+	words := []string{"plain", "end.", "example.com/page", "&amp;", "words"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Filter(words[i%len(words)])
+	}
+}
+
+func TestCapitalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"hello", "Hello"},
+		{"Hello", "Hello"},
+		{"élan", "Élan"},
+		{"1st", "1st"},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if got := Capitalize(test.in); got != test.want {
+			t.Errorf("Capitalize(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestCapitalizesSentences(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"", true},
+		{"Hello there", true},
+		{"hello there", false},
+		{"hello. World! Yes", true},
+		{"hi. there. You", false},
+		// abbreviations do not end sentences
+		{"Mr. smith went", true},
+		{"i think so. ok", false},
+		{"123 go", true},
+	}
+
+	for _, test := range tests {
+		if got := CapitalizesSentences(test.in); got != test.want {
+			t.Errorf("CapitalizesSentences(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}